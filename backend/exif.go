@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// exifScanLimit is how much of an upload is read for EXIF data. The APP1
+// segment holding it comes first and can't exceed 64KB.
+const exifScanLimit = 128 * 1024
+
+// EXIF tags used to find the GPS position
+const (
+	tagGPSIFD       = 0x8825
+	tagGPSLatRef    = 0x0001
+	tagGPSLatitude  = 0x0002
+	tagGPSLongRef   = 0x0003
+	tagGPSLongitude = 0x0004
+)
+
+// exifGPS reads the GPS position from the EXIF data of a JPEG image. ok is
+// false for other formats and photos taken without location.
+func exifGPS(data []byte) (lat, lng float64, ok bool) {
+	tiff := exifTIFF(data)
+	if len(tiff) < 8 {
+		return 0, 0, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, 0, false
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	gpsOffset, found := ifd0[tagGPSIFD]
+	if !found {
+		return 0, 0, false
+	}
+	gps := readIFD(tiff, order, order.Uint32(gpsOffset[8:]))
+
+	lat, latOK := gpsCoordinate(tiff, order, gps[tagGPSLatitude], gps[tagGPSLatRef], 'S')
+	lng, lngOK := gpsCoordinate(tiff, order, gps[tagGPSLongitude], gps[tagGPSLongRef], 'W')
+	if !latOK || !lngOK || (lat == 0 && lng == 0) {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// exifTIFF returns the TIFF structure inside a JPEG's Exif APP1 segment
+func exifTIFF(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // image data starts, no EXIF
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// readIFD returns the raw 12-byte entries of an image file directory by tag
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if int(offset)+2 > len(tiff) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	for i := 0; i < count; i++ {
+		pos := start + i*12
+		if pos+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[pos:])] = tiff[pos : pos+12]
+	}
+	return entries
+}
+
+// gpsCoordinate converts a degrees/minutes/seconds rational triple to decimal
+// degrees, negated when the reference is S or W
+func gpsCoordinate(tiff []byte, order binary.ByteOrder, value, ref []byte, negative byte) (float64, bool) {
+	if value == nil || order.Uint32(value[4:]) != 3 {
+		return 0, false
+	}
+	offset := int(order.Uint32(value[8:]))
+	if offset+24 > len(tiff) {
+		return 0, false
+	}
+
+	var parts [3]float64
+	for i := range parts {
+		num := order.Uint32(tiff[offset+i*8:])
+		den := order.Uint32(tiff[offset+i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+
+	// ASCII references of up to four bytes are stored inline
+	if ref != nil && ref[8] == negative {
+		degrees = -degrees
+	}
+	return degrees, true
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Household roles
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+var (
+	errHouseholdNotFound  = errors.New("household not found")
+	errNotHouseholdMember = errors.New("user is not a member of this household")
+	errHouseholdReadOnly  = errors.New("user cannot add data to this household")
+)
+
+// Household represents a group of users sharing receipts and inventory
+type Household struct {
+	ID        string            `json:"id" firestore:"id"`
+	Name      string            `json:"name" firestore:"name"`
+	OwnerID   string            `json:"owner_id" firestore:"owner_id"`
+	Members   []HouseholdMember `json:"members" firestore:"members"`
+	MemberIDs []string          `json:"member_ids" firestore:"member_ids"` // for array-contains queries
+	CreatedAt time.Time         `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" firestore:"updated_at"`
+}
+
+// HouseholdMember represents a user's membership in a household
+type HouseholdMember struct {
+	UserID   string    `json:"user_id" firestore:"user_id"`
+	Role     string    `json:"role" firestore:"role"` // owner, member, viewer
+	JoinedAt time.Time `json:"joined_at" firestore:"joined_at"`
+}
+
+// Role returns the user's role in the household, or "" if they are not a member
+func (h *Household) Role(userID string) string {
+	for _, m := range h.Members {
+		if m.UserID == userID {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// CanWrite reports whether the user may attach receipts and stock items
+func (h *Household) CanWrite(userID string) bool {
+	role := h.Role(userID)
+	return role == RoleOwner || role == RoleMember
+}
+
+// SetMember adds the user or changes their role
+func (h *Household) SetMember(userID, role string) {
+	for i, m := range h.Members {
+		if m.UserID == userID {
+			h.Members[i].Role = role
+			return
+		}
+	}
+	h.Members = append(h.Members, HouseholdMember{UserID: userID, Role: role, JoinedAt: time.Now()})
+	h.MemberIDs = append(h.MemberIDs, userID)
+}
+
+// RemoveMember drops the user from the household
+func (h *Household) RemoveMember(userID string) {
+	members := h.Members[:0]
+	for _, m := range h.Members {
+		if m.UserID != userID {
+			members = append(members, m)
+		}
+	}
+	h.Members = members
+
+	ids := h.MemberIDs[:0]
+	for _, id := range h.MemberIDs {
+		if id != userID {
+			ids = append(ids, id)
+		}
+	}
+	h.MemberIDs = ids
+}
+
+func isValidRole(role string) bool {
+	return role == RoleOwner || role == RoleMember || role == RoleViewer
+}
+
+func getHousehold(ctx context.Context, householdID string) (*Household, error) {
+	doc, err := firestoreClient.Collection("households").Doc(householdID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errHouseholdNotFound
+		}
+		return nil, err
+	}
+
+	var household Household
+	if err := doc.DataTo(&household); err != nil {
+		return nil, err
+	}
+	return &household, nil
+}
+
+// authorizeHousehold loads the household and checks the user's access to it.
+// It writes the error response and returns nil when access is denied.
+func authorizeHousehold(w http.ResponseWriter, r *http.Request, householdID, userID string, write bool) *Household {
+	household, err := getHousehold(r.Context(), householdID)
+	if err == errHouseholdNotFound {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch household", http.StatusInternalServerError)
+		return nil
+	}
+
+	if household.Role(userID) == "" {
+		http.Error(w, errNotHouseholdMember.Error(), http.StatusForbidden)
+		return nil
+	}
+	if write && !household.CanWrite(userID) {
+		http.Error(w, errHouseholdReadOnly.Error(), http.StatusForbidden)
+		return nil
+	}
+	return household
+}
+
+func householdsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "POST":
+		createHousehold(w, r)
+	case "GET":
+		getHouseholds(w, r)
+	case "DELETE":
+		deleteHousehold(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createHousehold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID string `json:"user_id"`
+		Name   string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || req.Name == "" {
+		http.Error(w, "user_id and name are required", http.StatusBadRequest)
+		return
+	}
+
+	household := Household{
+		ID:        generateID(),
+		Name:      req.Name,
+		OwnerID:   req.UserID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	household.SetMember(req.UserID, RoleOwner)
+
+	_, err := firestoreClient.Collection("households").Doc(household.ID).Set(ctx, household)
+	if err != nil {
+		http.Error(w, "Failed to save household", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(household)
+}
+
+func getHouseholds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	iter := firestoreClient.Collection("households").Where("member_ids", "array-contains", userID).Documents(ctx)
+	var households []Household
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch households", http.StatusInternalServerError)
+			return
+		}
+
+		var household Household
+		if err := doc.DataTo(&household); err != nil {
+			continue
+		}
+		households = append(households, household)
+	}
+
+	json.NewEncoder(w).Encode(households)
+}
+
+func deleteHousehold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	householdID := r.URL.Query().Get("id")
+	userID := r.URL.Query().Get("user_id")
+	if householdID == "" || userID == "" {
+		http.Error(w, "id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	household := authorizeHousehold(w, r, householdID, userID, true)
+	if household == nil {
+		return
+	}
+	if household.Role(userID) != RoleOwner {
+		http.Error(w, "Only the owner can delete a household", http.StatusForbidden)
+		return
+	}
+
+	_, err := firestoreClient.Collection("households").Doc(householdID).Delete(ctx)
+	if err != nil {
+		http.Error(w, "Failed to delete household", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func householdMembersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "POST":
+		setHouseholdMember(w, r)
+	case "DELETE":
+		removeHouseholdMember(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func setHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		HouseholdID string `json:"household_id"`
+		UserID      string `json:"user_id"`   // acting user, must be the owner
+		MemberID    string `json:"member_id"` // user being added or changed
+		Role        string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.HouseholdID == "" || req.UserID == "" || req.MemberID == "" {
+		http.Error(w, "household_id, user_id and member_id are required", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleMember
+	}
+	if !isValidRole(req.Role) {
+		http.Error(w, "role must be owner, member or viewer", http.StatusBadRequest)
+		return
+	}
+
+	household := authorizeHousehold(w, r, req.HouseholdID, req.UserID, true)
+	if household == nil {
+		return
+	}
+	if household.Role(req.UserID) != RoleOwner {
+		http.Error(w, "Only the owner can manage members", http.StatusForbidden)
+		return
+	}
+	if req.MemberID == household.OwnerID && req.Role != RoleOwner {
+		http.Error(w, "The household owner's role cannot be changed", http.StatusBadRequest)
+		return
+	}
+
+	household.SetMember(req.MemberID, req.Role)
+	household.UpdatedAt = time.Now()
+
+	if err := saveHousehold(ctx, household); err != nil {
+		http.Error(w, "Failed to update household", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(household)
+}
+
+func removeHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	householdID := r.URL.Query().Get("household_id")
+	userID := r.URL.Query().Get("user_id")
+	memberID := r.URL.Query().Get("member_id")
+	if householdID == "" || userID == "" || memberID == "" {
+		http.Error(w, "household_id, user_id and member_id are required", http.StatusBadRequest)
+		return
+	}
+
+	household := authorizeHousehold(w, r, householdID, userID, false)
+	if household == nil {
+		return
+	}
+
+	// Owners can remove anyone else; members can only leave themselves
+	if memberID == household.OwnerID {
+		http.Error(w, "The household owner cannot be removed", http.StatusBadRequest)
+		return
+	}
+	if household.Role(userID) != RoleOwner && memberID != userID {
+		http.Error(w, "Only the owner can remove other members", http.StatusForbidden)
+		return
+	}
+
+	household.RemoveMember(memberID)
+	household.UpdatedAt = time.Now()
+
+	if err := saveHousehold(ctx, household); err != nil {
+		http.Error(w, "Failed to update household", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(household)
+}
+
+func saveHousehold(ctx context.Context, household *Household) error {
+	_, err := firestoreClient.Collection("households").Doc(household.ID).Set(ctx, household)
+	return err
+}
+
+// scopedQuery returns a query over a collection for either a single user or,
+// when householdID is set, every document shared with that household.
+func scopedQuery(collection, userID, householdID string) firestore.Query {
+	if householdID != "" {
+		return firestoreClient.Collection(collection).Where("household_id", "==", householdID)
+	}
+	return firestoreClient.Collection(collection).Where("user_id", "==", userID)
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestHouseholdMembership(t *testing.T) {
+	household := &Household{ID: "h1", OwnerID: "alice"}
+	household.SetMember("alice", RoleOwner)
+	household.SetMember("bob", RoleMember)
+	household.SetMember("carol", RoleViewer)
+
+	if !household.CanWrite("alice") || !household.CanWrite("bob") {
+		t.Error("Expected owner and member to have write access")
+	}
+	if household.CanWrite("carol") {
+		t.Error("Expected viewer to be read-only")
+	}
+	if household.Role("dave") != "" {
+		t.Error("Expected non-member to have no role")
+	}
+
+	household.SetMember("carol", RoleMember)
+	if household.Role("carol") != RoleMember || len(household.Members) != 3 {
+		t.Errorf("Expected role change without duplicating member, got %+v", household.Members)
+	}
+
+	household.RemoveMember("bob")
+	if household.Role("bob") != "" || len(household.MemberIDs) != 2 {
+		t.Errorf("Expected bob to be removed, got %v", household.MemberIDs)
+	}
+}
+
+func TestMemberBreakdown(t *testing.T) {
+	receipts := []Receipt{
+		{UserID: "alice", TotalAmount: 30, Items: []Item{{Category: "dairy", Price: 10, Quantity: 3}}},
+		{UserID: "alice", TotalAmount: 10},
+		{UserID: "bob", TotalAmount: 25},
+	}
+
+	breakdown := memberBreakdown(receipts)
+	if got := breakdown["alice"]["total_spent"]; got != 40.0 {
+		t.Errorf("Expected alice to have spent 40, got %v", got)
+	}
+	if got := breakdown["bob"]["receipt_count"]; got != 1 {
+		t.Errorf("Expected bob to have 1 receipt, got %v", got)
+	}
+	if got := summarizeSpending(nil)["average_per_receipt"]; got != 0.0 {
+		t.Errorf("Expected zero average for no receipts, got %v", got)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// storeCellPrecision groups receipts into store locations about 150m across,
+// so branches of one chain stay apart
+const storeCellPrecision = 7
+
+const earthRadiusKm = 6371.0
+
+// Known reports whether the location was recorded. (0, 0) is treated as
+// unknown, since it is what receipts without a location contain.
+func (l Location) Known() bool {
+	return l.Latitude != 0 || l.Longitude != 0
+}
+
+// parseLocation reads latitude, longitude and address from form or query
+// values. ok is false when no coordinates were given.
+func parseLocation(get func(string) string) (loc Location, ok bool, err error) {
+	latText, lngText := get("latitude"), get("longitude")
+	if latText == "" && lngText == "" {
+		return Location{}, false, nil
+	}
+	lat, err1 := strconv.ParseFloat(latText, 64)
+	lng, err2 := strconv.ParseFloat(lngText, 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return Location{}, false, fmt.Errorf("latitude and longitude must be valid coordinates")
+	}
+	return Location{Latitude: lat, Longitude: lng, Address: strings.TrimSpace(get("address"))}, true, nil
+}
+
+// AreaSpending is spending within one geohash cell
+type AreaSpending struct {
+	Geohash      string   `json:"geohash"`
+	Latitude     float64  `json:"latitude"` // cell center
+	Longitude    float64  `json:"longitude"`
+	TotalSpent   float64  `json:"total_spent"`
+	ReceiptCount int      `json:"receipt_count"`
+	Stores       []string `json:"stores"`
+}
+
+// StoreLocation is a store the user has bought from, located by the receipts
+// uploaded there
+type StoreLocation struct {
+	Store      string    `json:"store"`
+	MerchantID string    `json:"merchant_id,omitempty"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Address    string    `json:"address,omitempty"`
+	Visits     int       `json:"visits"`
+	TotalSpent float64   `json:"total_spent"`
+	LastVisit  time.Time `json:"last_visit"`
+	DistanceKm float64   `json:"distance_km,omitempty"`
+}
+
+// spendingByArea sums located receipts per geohash cell, biggest spend first
+func spendingByArea(receipts []Receipt, precision int) []AreaSpending {
+	areas := make(map[string]*AreaSpending)
+	for _, receipt := range receipts {
+		if !receipt.Location.Known() {
+			continue
+		}
+		hash, lat, lng := geohash(receipt.Location.Latitude, receipt.Location.Longitude, precision)
+		area := areas[hash]
+		if area == nil {
+			area = &AreaSpending{Geohash: hash, Latitude: lat, Longitude: lng, Stores: []string{}}
+			areas[hash] = area
+		}
+		area.TotalSpent += receipt.TotalAmount
+		area.ReceiptCount++
+		if receipt.StoreName != "" && !containsString(area.Stores, receipt.StoreName) {
+			area.Stores = append(area.Stores, receipt.StoreName)
+		}
+	}
+
+	result := make([]AreaSpending, 0, len(areas))
+	for _, area := range areas {
+		area.TotalSpent = math.Round(area.TotalSpent*100) / 100
+		sort.Strings(area.Stores)
+		result = append(result, *area)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalSpent != result[j].TotalSpent {
+			return result[i].TotalSpent > result[j].TotalSpent
+		}
+		return result[i].Geohash < result[j].Geohash
+	})
+	return result
+}
+
+// storeLocations groups located receipts by store and place. The location is
+// the average of the receipts' positions and the address the latest one given.
+func storeLocations(receipts []Receipt) []StoreLocation {
+	type accumulator struct {
+		StoreLocation
+		latSum, lngSum float64
+		addressAt      time.Time
+	}
+
+	stores := make(map[string]*accumulator)
+	for _, receipt := range receipts {
+		if !receipt.Location.Known() {
+			continue
+		}
+		storeKey := receipt.MerchantID
+		if storeKey == "" {
+			storeKey = strings.ToLower(strings.TrimSpace(receipt.StoreName))
+		}
+		cell, _, _ := geohash(receipt.Location.Latitude, receipt.Location.Longitude, storeCellPrecision)
+		key := storeKey + "|" + cell
+
+		s := stores[key]
+		if s == nil {
+			s = &accumulator{StoreLocation: StoreLocation{Store: receipt.StoreName, MerchantID: receipt.MerchantID}}
+			stores[key] = s
+		}
+		s.Visits++
+		s.TotalSpent += receipt.TotalAmount
+		s.latSum += receipt.Location.Latitude
+		s.lngSum += receipt.Location.Longitude
+		if receipt.Date.After(s.LastVisit) {
+			s.LastVisit = receipt.Date
+		}
+		if receipt.Location.Address != "" && !receipt.Date.Before(s.addressAt) {
+			s.Address, s.addressAt = receipt.Location.Address, receipt.Date
+		}
+	}
+
+	result := make([]StoreLocation, 0, len(stores))
+	for _, s := range stores {
+		s.Latitude = s.latSum / float64(s.Visits)
+		s.Longitude = s.lngSum / float64(s.Visits)
+		s.TotalSpent = math.Round(s.TotalSpent*100) / 100
+		result = append(result, s.StoreLocation)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Visits != result[j].Visits {
+			return result[i].Visits > result[j].Visits
+		}
+		return result[i].Store < result[j].Store
+	})
+	return result
+}
+
+// nearestStores returns the stores within radiusKm of a point, closest first
+func nearestStores(stores []StoreLocation, lat, lng, radiusKm float64, limit int) []StoreLocation {
+	nearby := []StoreLocation{}
+	for _, s := range stores {
+		s.DistanceKm = math.Round(haversineKm(lat, lng, s.Latitude, s.Longitude)*100) / 100
+		if s.DistanceKm <= radiusKm {
+			nearby = append(nearby, s)
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool { return nearby[i].DistanceKm < nearby[j].DistanceKm })
+	if limit > 0 && len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby
+}
+
+// storesGeoJSON renders store locations as a GeoJSON FeatureCollection of points
+func storesGeoJSON(stores []StoreLocation) map[string]interface{} {
+	features := make([]map[string]interface{}, 0, len(stores))
+	for _, s := range stores {
+		features = append(features, map[string]interface{}{
+			"type": "Feature",
+			"geometry": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{s.Longitude, s.Latitude},
+			},
+			"properties": map[string]interface{}{
+				"store":       s.Store,
+				"merchant_id": s.MerchantID,
+				"address":     s.Address,
+				"visits":      s.Visits,
+				"total_spent": s.TotalSpent,
+				"last_visit":  s.LastVisit.Format(time.RFC3339),
+			},
+		})
+	}
+	return map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	}
+}
+
+// geohash encodes a position at the given precision and returns the center of
+// its cell
+func geohash(lat, lng float64, precision int) (string, float64, float64) {
+	const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+
+	var b strings.Builder
+	bit, ch, even := 0, 0, true
+	for b.Len() < precision {
+		r, v := &latRange, lat
+		if even {
+			r, v = &lngRange, lng
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+
+		if bit++; bit == 5 {
+			b.WriteByte(base32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return b.String(), (latRange[0] + latRange[1]) / 2, (lngRange[0] + lngRange[1]) / 2
+}
+
+// haversineKm is the great-circle distance between two positions
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// locatedReceipts loads the user's (or household's) receipts for the
+// location endpoints, writing the error response and returning false on failure
+func locatedReceipts(w http.ResponseWriter, r *http.Request) ([]Receipt, bool) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return nil, false
+	}
+	householdID := r.URL.Query().Get("household_id")
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return nil, false
+	}
+
+	receipts, err := fetchReceipts(r.Context(), userID, householdID)
+	if err != nil {
+		http.Error(w, "Failed to fetch receipts", http.StatusInternalServerError)
+		return nil, false
+	}
+	return receipts, true
+}
+
+func fetchReceipts(ctx context.Context, userID, householdID string) ([]Receipt, error) {
+	iter := scopedQuery("receipts", userID, householdID).Documents(ctx)
+	var receipts []Receipt
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var receipt Receipt
+		if err := doc.DataTo(&receipt); err != nil {
+			continue
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+// areaAnalysisHandler reports spending per area, as geohash cells
+func areaAnalysisHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	precision := 5
+	if v := r.URL.Query().Get("precision"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 9 {
+			http.Error(w, "precision must be between 1 and 9", http.StatusBadRequest)
+			return
+		}
+		precision = n
+	}
+
+	receipts, ok := locatedReceipts(w, r)
+	if !ok {
+		return
+	}
+
+	areas := spendingByArea(receipts, precision)
+	located := 0
+	for _, area := range areas {
+		located += area.ReceiptCount
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"precision": precision,
+		"areas":     areas,
+		"unlocated": len(receipts) - located,
+	})
+}
+
+// nearbyStoresHandler lists the stores the user has bought from near a point
+func nearbyStoresHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	point, ok, err := parseLocation(r.URL.Query().Get)
+	if err != nil || !ok {
+		http.Error(w, "latitude and longitude are required", http.StatusBadRequest)
+		return
+	}
+
+	radiusKm := 5.0
+	if v := r.URL.Query().Get("radius_km"); v != "" {
+		radiusKm, err = strconv.ParseFloat(v, 64)
+		if err != nil || radiusKm <= 0 {
+			http.Error(w, "radius_km must be positive", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	receipts, ok := locatedReceipts(w, r)
+	if !ok {
+		return
+	}
+
+	stores := nearestStores(storeLocations(receipts), point.Latitude, point.Longitude, radiusKm, limit)
+	json.NewEncoder(w).Encode(stores)
+}
+
+// storesGeoJSONHandler exports the user's store locations for map display
+func storesGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	receipts, ok := locatedReceipts(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(storesGeoJSON(storeLocations(receipts)))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGeohash(t *testing.T) {
+	hash, lat, lng := geohash(57.64911, 10.40744, 11)
+	if hash != "u4pruydqqvj" {
+		t.Errorf("geohash = %q, want u4pruydqqvj", hash)
+	}
+	if math.Abs(lat-57.64911) > 0.0001 || math.Abs(lng-10.40744) > 0.0001 {
+		t.Errorf("Expected the cell center near the point, got %v, %v", lat, lng)
+	}
+}
+
+func TestHaversineKm(t *testing.T) {
+	// Mumbai to Pune is roughly 120km as the crow flies
+	d := haversineKm(19.0760, 72.8777, 18.5204, 73.8567)
+	if d < 115 || d > 125 {
+		t.Errorf("haversineKm = %v, want about 120", d)
+	}
+}
+
+func TestStoreLocationsAndAreas(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	receipts := []Receipt{
+		{StoreName: "D-Mart", MerchantID: "dmart", TotalAmount: 100, Date: day,
+			Location: Location{Latitude: 18.5910, Longitude: 73.7380, Address: "Hinjewadi"}},
+		{StoreName: "D-Mart", MerchantID: "dmart", TotalAmount: 50.5, Date: day.Add(24 * time.Hour),
+			Location: Location{Latitude: 18.5912, Longitude: 73.7382}},
+		{StoreName: "D-Mart", MerchantID: "dmart", TotalAmount: 70, Date: day,
+			Location: Location{Latitude: 19.0760, Longitude: 72.8777}},
+		{StoreName: "Corner Store", TotalAmount: 20, Date: day},
+	}
+
+	stores := storeLocations(receipts)
+	if len(stores) != 2 {
+		t.Fatalf("Expected two D-Mart branches, got %+v", stores)
+	}
+	pune := stores[0]
+	if pune.Visits != 2 || pune.TotalSpent != 150.5 || pune.Address != "Hinjewadi" || !pune.LastVisit.Equal(day.Add(24*time.Hour)) {
+		t.Errorf("Unexpected Pune branch %+v", pune)
+	}
+
+	nearby := nearestStores(stores, 18.59, 73.74, 5, 10)
+	if len(nearby) != 1 || nearby[0].DistanceKm == 0 || nearby[0].DistanceKm > 1 {
+		t.Errorf("Expected only the Pune branch nearby, got %+v", nearby)
+	}
+
+	areas := spendingByArea(receipts, 5)
+	if len(areas) != 2 || areas[0].TotalSpent != 150.5 || areas[0].ReceiptCount != 2 {
+		t.Errorf("Unexpected areas %+v", areas)
+	}
+
+	geo := storesGeoJSON(stores)
+	features := geo["features"].([]map[string]interface{})
+	coords := features[0]["geometry"].(map[string]interface{})["coordinates"].([]float64)
+	if geo["type"] != "FeatureCollection" || coords[0] != pune.Longitude || coords[1] != pune.Latitude {
+		t.Errorf("Expected [lng, lat] point features, got %+v", features[0])
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	values := map[string]string{"latitude": "18.59", "longitude": "73.74", "address": " Hinjewadi "}
+	loc, ok, err := parseLocation(func(k string) string { return values[k] })
+	if err != nil || !ok || loc.Latitude != 18.59 || loc.Address != "Hinjewadi" {
+		t.Errorf("Unexpected location %+v %v %v", loc, ok, err)
+	}
+
+	if _, ok, err := parseLocation(func(string) string { return "" }); ok || err != nil {
+		t.Errorf("Expected no location without coordinates, got %v %v", ok, err)
+	}
+
+	values["latitude"] = "123"
+	if _, _, err := parseLocation(func(k string) string { return values[k] }); err == nil {
+		t.Error("Expected out of range latitude to fail")
+	}
+}
+
+func TestExifGPS(t *testing.T) {
+	// 18° 35' 24" N, 73° 44' 24" W in a little-endian EXIF block
+	order := binary.LittleEndian
+	var tiff bytes.Buffer
+	write := func(v interface{}) { binary.Write(&tiff, order, v) }
+
+	tiff.WriteString("II")
+	write(uint16(42))
+	write(uint32(8)) // IFD0
+	write(uint16(1))
+	write([]uint16{tagGPSIFD, 4})
+	write([]uint32{1, 26}) // GPS IFD at 26
+	write(uint32(0))
+
+	write(uint16(4))
+	write([]uint16{tagGPSLatRef, 2})
+	write(uint32(2))
+	tiff.WriteString("N\x00\x00\x00")
+	write([]uint16{tagGPSLatitude, 5})
+	write([]uint32{3, 80})
+	write([]uint16{tagGPSLongRef, 2})
+	write(uint32(2))
+	tiff.WriteString("W\x00\x00\x00")
+	write([]uint16{tagGPSLongitude, 5})
+	write([]uint32{3, 104})
+	write(uint32(0))
+	write([]uint32{18, 1, 35, 1, 24, 1}) // at 80
+	write([]uint32{73, 1, 44, 1, 24, 1}) // at 104
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(app1)+2))
+	jpeg = append(jpeg, app1...)
+	jpeg = append(jpeg, 0xFF, 0xDA, 0, 2)
+
+	lat, lng, ok := exifGPS(jpeg)
+	if !ok || math.Abs(lat-18.59) > 1e-9 || math.Abs(lng+73.74) > 1e-9 {
+		t.Errorf("exifGPS = %v, %v, %v; want 18.59, -73.74", lat, lng, ok)
+	}
+
+	if _, _, ok := exifGPS([]byte("not a jpeg")); ok {
+		t.Error("Expected no position from a non-JPEG")
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/barcode"
+	"raseed-shared/loyalty"
+	"raseed-shared/wallet"
+)
+
+var (
+	errLoyaltyCardNotFound = errors.New("loyalty card not found")
+	errDuplicateCard       = errors.New("loyalty card already saved")
+	errInvalidCardChange   = errors.New("invalid loyalty card change")
+)
+
+// loyaltyChanges is an update to a card. Fields left out are unchanged; the
+// member number and merchant are the card's identity and can't be changed.
+type loyaltyChanges struct {
+	UserID        string   `json:"user_id"`
+	Program       *string  `json:"program"`
+	PointsBalance *float64 `json:"points_balance"`
+	BarcodeType   *string  `json:"barcode_type"` // empty for the configured default
+}
+
+// apply makes the changes to the card. A balance given by hand is as of now.
+func (c loyaltyChanges) apply(card *loyalty.Card, now time.Time) error {
+	if c.Program != nil {
+		card.Program = strings.TrimSpace(*c.Program)
+	}
+	if c.PointsBalance != nil {
+		card.PointsBalance, card.PointsAsOf = *c.PointsBalance, now
+	}
+	if c.BarcodeType != nil {
+		card.BarcodeType = ""
+		if *c.BarcodeType != "" {
+			symbology, err := barcode.Symbology(*c.BarcodeType)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errInvalidCardChange, err)
+			}
+			card.BarcodeType = symbology
+		}
+	}
+	if err := card.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidCardChange, err)
+	}
+	card.UpdatedAt = now
+	return nil
+}
+
+func loyaltyCardsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		getLoyaltyCards(w, r)
+	case "POST":
+		createLoyaltyCard(w, r)
+	case "PUT":
+		updateLoyaltyCard(w, r)
+	case "DELETE":
+		deleteLoyaltyCard(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getLoyaltyCards lists a user's loyalty cards by program, optionally only
+// those of one merchant, or returns one card by ID
+func getLoyaltyCards(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		doc, err := firestoreClient.Collection("loyalty_cards").Doc(id).Get(ctx)
+		card, err := loadLoyaltyCard(doc, err, userID)
+		if err == errLoyaltyCardNotFound {
+			http.Error(w, "Loyalty card not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch loyalty card", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(card)
+		return
+	}
+
+	query := firestoreClient.Collection("loyalty_cards").Where("user_id", "==", userID)
+	if merchantID := r.URL.Query().Get("merchant_id"); merchantID != "" {
+		query = query.Where("merchant_id", "==", merchantID)
+	}
+	iter := query.Documents(ctx)
+	cards := []loyalty.Card{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch loyalty cards", http.StatusInternalServerError)
+			return
+		}
+
+		var card loyalty.Card
+		if err := doc.DataTo(&card); err != nil {
+			continue
+		}
+		cards = append(cards, card)
+	}
+
+	sort.Slice(cards, func(i, j int) bool { return strings.ToLower(cards[i].Program) < strings.ToLower(cards[j].Program) })
+	json.NewEncoder(w).Encode(cards)
+}
+
+// createLoyaltyCard saves a card entered by hand, linked to the merchant
+// directory, and issues its loyalty pass. A user has one card per merchant
+// and member number.
+func createLoyaltyCard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID        string   `json:"user_id"`
+		Program       string   `json:"program"`
+		MemberID      string   `json:"member_id"`
+		Merchant      string   `json:"merchant"` // store name; the program name if empty
+		PointsBalance *float64 `json:"points_balance"`
+		BarcodeType   string   `json:"barcode_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" || strings.TrimSpace(req.Program) == "" || req.MemberID == "" {
+		http.Error(w, "user_id, program and member_id are required", http.StatusBadRequest)
+		return
+	}
+	if req.Merchant == "" {
+		req.Merchant = req.Program
+	}
+
+	merchant, err := merchantDir.Resolve(ctx, req.Merchant, nil)
+	if err != nil {
+		http.Error(w, "Failed to resolve merchant", http.StatusInternalServerError)
+		return
+	}
+	if merchant.ID == "" {
+		http.Error(w, "merchant must name a store", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	card := loyalty.Card{
+		ID:           generateID(),
+		UserID:       req.UserID,
+		Program:      strings.TrimSpace(req.Program),
+		MemberID:     loyalty.NormalizeMemberID(req.MemberID),
+		MerchantID:   merchant.ID,
+		MerchantName: merchant.Name,
+		Source:       loyalty.Manual,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if req.PointsBalance != nil {
+		card.RecordPoints(*req.PointsBalance, now)
+	}
+	if req.BarcodeType != "" {
+		if card.BarcodeType, err = barcode.Symbology(req.BarcodeType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := card.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pass, err := loyaltyPass(ctx, card)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	duplicates := firestoreClient.Collection("loyalty_cards").
+		Where("user_id", "==", card.UserID).
+		Where("merchant_id", "==", card.MerchantID).
+		Where("member_id", "==", card.MemberID).Limit(1)
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := tx.Documents(duplicates).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return errDuplicateCard
+		}
+		if err := tx.Create(firestoreClient.Collection("loyalty_cards").Doc(card.ID), card); err != nil {
+			return err
+		}
+		return tx.Set(firestoreClient.Collection("wallet_passes").Doc(pass.ID), pass)
+	})
+	if err == errDuplicateCard {
+		http.Error(w, "Loyalty card already saved", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save loyalty card", http.StatusInternalServerError)
+		return
+	}
+
+	publishWalletPassEvent(ctx, WalletPass{ID: pass.ID, UserID: pass.UserID, Type: pass.Type, Title: pass.Title}, "created")
+
+	json.NewEncoder(w).Encode(card)
+}
+
+// updateLoyaltyCard renames a card, sets its points balance or barcode type,
+// and brings its pass in line
+func updateLoyaltyCard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	var changes loyaltyChanges
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if changes.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var card loyalty.Card
+	var pass WalletPass
+	cardRef := firestoreClient.Collection("loyalty_cards").Doc(id)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(cardRef)
+		if card, err = loadLoyaltyCard(doc, err, changes.UserID); err != nil {
+			return err
+		}
+		if err := changes.apply(&card, time.Now()); err != nil {
+			return err
+		}
+
+		updated, err := loyaltyPass(ctx, card)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidCardChange, err)
+		}
+		passRef := firestoreClient.Collection("wallet_passes").Doc(updated.ID)
+		passDoc, err := tx.Get(passRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		pass = WalletPass{ID: updated.ID, UserID: updated.UserID, Type: updated.Type, Title: updated.Title}
+		if err := tx.Set(cardRef, card); err != nil {
+			return err
+		}
+		if passDoc == nil || !passDoc.Exists() {
+			return tx.Set(passRef, updated)
+		}
+		// Update in place, so the pass keeps its state and issued object
+		if object, err := passDoc.DataAt("wallet_object_id"); err == nil {
+			pass.WalletObjectID, _ = object.(string)
+		}
+		return tx.Update(passRef, []firestore.Update{
+			{Path: "title", Value: updated.Title},
+			{Path: "description", Value: updated.Description},
+			{Path: "payload", Value: updated.Payload},
+			{Path: "barcode", Value: updated.Barcode},
+			{Path: "updated_at", Value: card.UpdatedAt},
+		})
+	})
+	if err == errLoyaltyCardNotFound {
+		http.Error(w, "Loyalty card not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errInvalidCardChange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update loyalty card", http.StatusInternalServerError)
+		return
+	}
+
+	publishWalletPassEvent(ctx, pass, "updated")
+	json.NewEncoder(w).Encode(card)
+}
+
+// deleteLoyaltyCard removes a card and its pass, revoking the issued object
+func deleteLoyaltyCard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.URL.Query().Get("id")
+	userID := r.URL.Query().Get("user_id")
+	if id == "" || userID == "" {
+		http.Error(w, "id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	var pass WalletPass
+	cardRef := firestoreClient.Collection("loyalty_cards").Doc(id)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(cardRef)
+		card, err := loadLoyaltyCard(doc, err, userID)
+		if err != nil {
+			return err
+		}
+
+		passRef := firestoreClient.Collection("wallet_passes").Doc(card.PassID())
+		passDoc, err := tx.Get(passRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		pass = WalletPass{}
+		if passDoc != nil && passDoc.Exists() {
+			if err := passDoc.DataTo(&pass); err != nil {
+				return err
+			}
+			pass.ID = passRef.ID
+			if err := tx.Delete(passRef); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(cardRef)
+	})
+	if err == errLoyaltyCardNotFound {
+		http.Error(w, "Loyalty card not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete loyalty card", http.StatusInternalServerError)
+		return
+	}
+
+	if pass.ID != "" {
+		publishWalletPassEvent(ctx, pass, "deleted")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadLoyaltyCard reads a fetched card. Cards of other users are reported as
+// not found.
+func loadLoyaltyCard(doc *firestore.DocumentSnapshot, err error, userID string) (loyalty.Card, error) {
+	var card loyalty.Card
+	if status.Code(err) == codes.NotFound {
+		return card, errLoyaltyCardNotFound
+	}
+	if err != nil {
+		return card, err
+	}
+	if err := doc.DataTo(&card); err != nil {
+		return card, err
+	}
+	if card.UserID != userID {
+		return loyalty.Card{}, errLoyaltyCardNotFound
+	}
+	return card, nil
+}
+
+// loyaltyPass is the card's pass, with the member number as its barcode
+func loyaltyPass(ctx context.Context, card loyalty.Card) (wallet.Pass, error) {
+	pass, err := card.Pass()
+	if err != nil {
+		return pass, err
+	}
+	cfg := runtimeConfig.Get(ctx)
+	pass.Barcode = wallet.DefaultBarcode(pass, cfg.BarcodeType, cfg.AppLinkBase)
+	return pass, nil
+}
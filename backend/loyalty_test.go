@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"raseed-shared/barcode"
+	"raseed-shared/loyalty"
+)
+
+func TestLoyaltyChangesApply(t *testing.T) {
+	now := time.Date(2026, 5, 2, 9, 0, 0, 0, time.UTC)
+	card := loyalty.Card{ID: "c1", UserID: "alice", Program: "More Rewards", MemberID: "9845012345", MerchantID: "m1"}
+
+	var changes loyaltyChanges
+	if err := json.Unmarshal([]byte(`{"user_id":"alice","program":" More Club ","points_balance":410,"barcode_type":"code128"}`), &changes); err != nil {
+		t.Fatal(err)
+	}
+	if err := changes.apply(&card, now); err != nil {
+		t.Fatal(err)
+	}
+	if card.Program != "More Club" || card.PointsBalance != 410 || !card.PointsAsOf.Equal(now) || card.BarcodeType != barcode.Code128 {
+		t.Errorf("unexpected card %+v", card)
+	}
+
+	// An empty barcode type goes back to the configured default
+	empty := ""
+	if err := (loyaltyChanges{BarcodeType: &empty}).apply(&card, now); err != nil || card.BarcodeType != "" {
+		t.Errorf("Expected the barcode type cleared, got %q, %v", card.BarcodeType, err)
+	}
+
+	negative := -5.0
+	for name, changes := range map[string]loyaltyChanges{
+		"empty program":    {Program: &empty},
+		"negative balance": {PointsBalance: &negative},
+	} {
+		c := card
+		if err := changes.apply(&c, now); !errors.Is(err, errInvalidCardChange) {
+			t.Errorf("%s: expected errInvalidCardChange, got %v", name, err)
+		}
+	}
+	pdf := "pdf_417"
+	if err := (loyaltyChanges{BarcodeType: &pdf}).apply(&card, now); !errors.Is(err, errInvalidCardChange) {
+		t.Errorf("Expected errInvalidCardChange for an unsupported barcode type, got %v", err)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -16,29 +20,51 @@ import (
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	"raseed-shared/barcode"
+	"raseed-shared/catalog"
+	"raseed-shared/config"
+	"raseed-shared/merchants"
+	"raseed-shared/shelflife"
+	"raseed-shared/wallet"
 )
 
 // Receipt represents a receipt document in Firestore
 type Receipt struct {
-	ID          string    `json:"id" firestore:"id"`
-	UserID      string    `json:"user_id" firestore:"user_id"`
-	StoreName   string    `json:"store_name" firestore:"store_name"`
-	TotalAmount float64   `json:"total_amount" firestore:"total_amount"`
-	TaxAmount   float64   `json:"tax_amount" firestore:"tax_amount"`
-	Items       []Item    `json:"items" firestore:"items"`
-	Date        time.Time `json:"date" firestore:"date"`
-	ImageURL    string    `json:"image_url" firestore:"image_url"`
-	Location    Location  `json:"location" firestore:"location"`
-	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+	ID           string             `json:"id" firestore:"id"`
+	UserID       string             `json:"user_id" firestore:"user_id"`
+	HouseholdID  string             `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	StoreName    string             `json:"store_name" firestore:"store_name"`
+	RawStoreName string             `json:"raw_store_name,omitempty" firestore:"raw_store_name,omitempty"` // store name as read, before merchant matching
+	MerchantID   string             `json:"merchant_id,omitempty" firestore:"merchant_id,omitempty"`
+	TotalAmount  float64            `json:"total_amount" firestore:"total_amount"`
+	TaxAmount    float64            `json:"tax_amount" firestore:"tax_amount"`
+	TaxBreakdown map[string]float64 `json:"tax_breakdown,omitempty" firestore:"tax_breakdown,omitempty"` // e.g. CGST, SGST, IGST
+	Items        []Item             `json:"items" firestore:"items"`
+	Date         time.Time          `json:"date" firestore:"date"`
+	ImageURL     string             `json:"image_url" firestore:"image_url"`
+	Location     Location           `json:"location" firestore:"location"`
+	Tags         []string           `json:"tags,omitempty" firestore:"tags,omitempty"`
+	Notes        string             `json:"notes,omitempty" firestore:"notes,omitempty"`
+	CustomFields map[string]string  `json:"custom_fields,omitempty" firestore:"custom_fields,omitempty"`
+	SkipStock    bool               `json:"skip_stock,omitempty" firestore:"skip_stock,omitempty"` // opt out of automatic pantry stocking
+	Stocked      bool               `json:"stocked,omitempty" firestore:"stocked,omitempty"`       // grocery items were added to the pantry
+	CreatedAt    time.Time          `json:"created_at" firestore:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" firestore:"updated_at"`
 }
 
 // Item represents an item in a receipt
 type Item struct {
-	Name     string  `json:"name" firestore:"name"`
-	Price    float64 `json:"price" firestore:"price"`
-	Quantity int     `json:"quantity" firestore:"quantity"`
-	Category string  `json:"category" firestore:"category"`
+	Name         string            `json:"name" firestore:"name"`
+	Price        float64           `json:"price" firestore:"price"`
+	Quantity     int               `json:"quantity" firestore:"quantity"`
+	Category     string            `json:"category" firestore:"category"`
+	Tags         []string          `json:"tags,omitempty" firestore:"tags,omitempty"`
+	Notes        string            `json:"notes,omitempty" firestore:"notes,omitempty"`
+	CustomFields map[string]string `json:"custom_fields,omitempty" firestore:"custom_fields,omitempty"`
+
+	WarrantyMonths int `json:"warranty_months,omitempty" firestore:"warranty_months,omitempty"`
+	ReturnDays     int `json:"return_days,omitempty" firestore:"return_days,omitempty"`
 }
 
 // Location represents store location
@@ -65,23 +91,48 @@ type WalletPass struct {
 	Type        string    `json:"type" firestore:"type"` // receipt, shopping_list, insight
 	Title       string    `json:"title" firestore:"title"`
 	Description string    `json:"description" firestore:"description"`
-	Data        string    `json:"data" firestore:"data"` // JSON string
 	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	SaveURL     string    `json:"save_url,omitempty" firestore:"-"` // Save to Google Wallet link, signed per request
+
+	// Typed by pass type, see raseed-shared/wallet. Passes from before
+	// schema version 1 have a JSON string in Data instead.
+	SchemaVersion int         `json:"schema_version" firestore:"schema_version"`
+	Payload       interface{} `json:"payload,omitempty" firestore:"payload,omitempty"`
+	Data          string      `json:"data,omitempty" firestore:"data,omitempty"`
+
+	// Lifecycle, see raseed-shared/wallet. State is reported as of the request,
+	// so an active pass past expires_at reads as expired.
+	State     string     `json:"state" firestore:"state"` // active, expired, revoked
+	ExpiresAt *time.Time `json:"expires_at,omitempty" firestore:"expires_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at,omitempty" firestore:"updated_at,omitempty"`
+
+	// Shown on the pass, and rendered by GET /wallet-passes/{id}/barcode
+	Barcode *barcode.Barcode `json:"barcode,omitempty" firestore:"barcode,omitempty"`
+
+	// Set by the wallet pass creator
+	WalletStatus   string `json:"wallet_status,omitempty" firestore:"wallet_status,omitempty"` // issued, invalid, failed
+	WalletError    string `json:"wallet_error,omitempty" firestore:"wallet_error,omitempty"`
+	WalletObjectID string `json:"wallet_object_id,omitempty" firestore:"wallet_object_id,omitempty"`
 }
 
 // StockItem represents a stock item in inventory
 type StockItem struct {
-	ID           string    `json:"id" firestore:"id"`
-	UserID       string    `json:"user_id" firestore:"user_id"`
-	Name         string    `json:"name" firestore:"name"`
-	Category     string    `json:"category" firestore:"category"`
-	Quantity     int       `json:"quantity" firestore:"quantity"`
-	Unit         string    `json:"unit" firestore:"unit"`
-	PurchaseDate time.Time `json:"purchase_date" firestore:"purchase_date"`
-	ExpiryDate   time.Time `json:"expiry_date" firestore:"expiry_date"`
-	Status       string    `json:"status" firestore:"status"` // fresh, expiring_soon, expired
-	CreatedAt    time.Time `json:"created_at" firestore:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" firestore:"updated_at"`
+	ID              string    `json:"id" firestore:"id"`
+	UserID          string    `json:"user_id" firestore:"user_id"`
+	HouseholdID     string    `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	Name            string    `json:"name" firestore:"name"`
+	NormalizedName  string    `json:"normalized_name" firestore:"normalized_name"`           // for matching receipt items
+	ReceiptID       string    `json:"receipt_id,omitempty" firestore:"receipt_id,omitempty"` // last receipt that stocked the item
+	Category        string    `json:"category" firestore:"category"`
+	Quantity        int       `json:"quantity" firestore:"quantity"`
+	Unit            string    `json:"unit" firestore:"unit"`
+	Storage         string    `json:"storage" firestore:"storage"` // pantry, fridge, freezer
+	PurchaseDate    time.Time `json:"purchase_date" firestore:"purchase_date"`
+	ExpiryDate      time.Time `json:"expiry_date" firestore:"expiry_date"`
+	ExpiryEstimated bool      `json:"expiry_estimated" firestore:"expiry_estimated"` // expiry_date comes from the shelf-life table
+	Status          string    `json:"status" firestore:"status"`                     // fresh, expiring_soon, expired, depleted
+	CreatedAt       time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" firestore:"updated_at"`
 }
 
 // Global clients
@@ -89,6 +140,8 @@ var (
 	firestoreClient *firestore.Client
 	pubsubClient    *pubsub.Client
 	storageClient   *storage.Client
+	runtimeConfig   *config.Store
+	merchantDir     *merchants.Directory
 )
 
 func main() {
@@ -116,13 +169,56 @@ func main() {
 	}
 	defer storageClient.Close()
 
+	// Load runtime configuration from system_config and keep it fresh
+	runtimeConfig = config.NewFirestore(ctx, firestoreClient)
+	go runtimeConfig.Watch(ctx)
+
+	merchantDir = merchants.NewDirectory(firestoreClient)
+
+	// Sign Save to Google Wallet links when an issuer is configured
+	initWalletSigner()
+
+	// Keep the receipt search index in sync with Firestore
+	go watchReceiptsForSearch(ctx)
+
 	// Set up HTTP routes
 	http.HandleFunc("/health", healthHandler)
 	http.HandleFunc("/receipts", receiptsHandler)
 	http.HandleFunc("/queries", queriesHandler)
 	http.HandleFunc("/wallet-passes", walletPassesHandler)
+	http.HandleFunc("/wallet-passes/", walletPassRoutesHandler)
 	http.HandleFunc("/analysis", analysisHandler)
+	http.HandleFunc("/analysis/areas", areaAnalysisHandler)
+	http.HandleFunc("/analysis/nearby", nearbyStoresHandler)
+	http.HandleFunc("/analysis/geojson", storesGeoJSONHandler)
 	http.HandleFunc("/stock-items", stockItemsHandler)
+	http.HandleFunc("/stock-items/", stockItemRoutesHandler)
+	http.HandleFunc("/households", householdsHandler)
+	http.HandleFunc("/households/members", householdMembersHandler)
+	http.HandleFunc("/splits", splitsHandler)
+	http.HandleFunc("/splits/balances", splitBalancesHandler)
+	http.HandleFunc("/splits/settle", settleUpHandler)
+	http.HandleFunc("/shopping-lists", shoppingListsHandler)
+	http.HandleFunc("/shopping-lists/items", shoppingListItemsHandler)
+	http.HandleFunc("/replenishment", replenishmentHandler)
+	http.HandleFunc("/prices", pricesHandler)
+	http.HandleFunc("/merchants", merchantsHandler)
+	http.HandleFunc("/merchants/merge", mergeMerchantsHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/warranties", warrantiesHandler)
+	http.HandleFunc("/loyalty-cards", loyaltyCardsHandler)
+	http.HandleFunc("/notifications", notificationsHandler)
+	http.HandleFunc("/notifications/unread-count", unreadCountHandler)
+	http.HandleFunc("/notifications/read", markReadHandler)
+	http.HandleFunc("/notifications/read-all", markAllReadHandler)
+	http.HandleFunc("/webhooks", webhooksHandler)
+	http.HandleFunc("/webhooks/deliveries", webhookDeliveriesHandler)
+	http.HandleFunc("/webhooks/redeliver", redeliverHandler)
+	http.HandleFunc("/tax/report", taxReportHandler)
+	http.HandleFunc("/tax/settings", taxSettingsHandler)
+	http.HandleFunc("/tasks/stock-sweep", stockSweepHandler)
+	http.HandleFunc("/tasks/warranty-reminders", warrantyRemindersHandler)
+	http.HandleFunc("/tasks/notification-cleanup", notificationCleanupHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -146,6 +242,8 @@ func receiptsHandler(w http.ResponseWriter, r *http.Request) {
 		uploadReceipt(w, r)
 	case "GET":
 		getReceipts(w, r)
+	case "PATCH":
+		updateReceiptAnnotations(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -153,9 +251,11 @@ func receiptsHandler(w http.ResponseWriter, r *http.Request) {
 
 func uploadReceipt(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	cfg := runtimeConfig.Get(ctx)
 
-	// Parse multipart form
-	err := r.ParseMultipartForm(32 << 20) // 32MB max
+	// Parse multipart form, rejecting uploads above the configured limit
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadBytes)
+	err := r.ParseMultipartForm(cfg.MaxUploadBytes)
 	if err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -168,6 +268,29 @@ func uploadReceipt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Optionally share the receipt with a household the user can write to
+	householdID := r.FormValue("household_id")
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, true) == nil {
+		return
+	}
+
+	// Grocery items are added to the pantry unless the user opts out
+	skipStock := false
+	if v := r.FormValue("skip_stock"); v != "" {
+		skipStock, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "skip_stock must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Where the receipt was captured, if the client sent it
+	location, located, err := parseLocation(r.FormValue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get file from form
 	file, header, err := r.FormFile("receipt")
 	if err != nil {
@@ -176,15 +299,28 @@ func uploadReceipt(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// Fall back to the photo's GPS position, read from the start of the file
+	head := make([]byte, exifScanLimit)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	head = head[:n]
+	if !located {
+		location.Address = strings.TrimSpace(r.FormValue("address"))
+		location.Latitude, location.Longitude, _ = exifGPS(head)
+	}
+
 	// Upload to Cloud Storage
 	bucketName := os.Getenv("CLOUD_STORAGE_BUCKET")
 	bucket := storageClient.Bucket(bucketName)
-	
+
 	objectName := fmt.Sprintf("receipts/%s/%s", userID, header.Filename)
 	obj := bucket.Object(objectName)
 	writer := obj.NewWriter(ctx)
-	
-	if _, err := io.Copy(writer, file); err != nil {
+
+	if _, err := io.Copy(writer, io.MultiReader(bytes.NewReader(head), file)); err != nil {
 		http.Error(w, "Failed to upload file", http.StatusInternalServerError)
 		return
 	}
@@ -195,12 +331,15 @@ func uploadReceipt(w http.ResponseWriter, r *http.Request) {
 
 	// Create receipt document
 	receipt := Receipt{
-		ID:        generateID(),
-		UserID:    userID,
-		ImageURL:  imageURL,
-		Date:      time.Now(),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:          generateID(),
+		UserID:      userID,
+		HouseholdID: householdID,
+		ImageURL:    imageURL,
+		SkipStock:   skipStock,
+		Location:    location,
+		Date:        time.Now(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
 	}
 
 	// Save to Firestore
@@ -228,9 +367,15 @@ func getReceipts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	iter := firestoreClient.Collection("receipts").Where("user_id", "==", userID).Documents(ctx)
+	// List the whole household's receipts when a household is requested
+	householdID := r.URL.Query().Get("household_id")
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+
+	iter := scopedQuery("receipts", userID, householdID).Documents(ctx)
 	var receipts []Receipt
-	
+
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -248,6 +393,11 @@ func getReceipts(w http.ResponseWriter, r *http.Request) {
 		receipts = append(receipts, receipt)
 	}
 
+	// Optionally keep only receipts tagged at receipt or item level
+	if tag := normalizeTag(r.URL.Query().Get("tag")); tag != "" {
+		receipts = filterReceiptsByTag(receipts, tag)
+	}
+
 	json.NewEncoder(w).Encode(receipts)
 }
 
@@ -319,7 +469,7 @@ func getQueries(w http.ResponseWriter, r *http.Request) {
 
 	iter := firestoreClient.Collection("queries").Where("user_id", "==", userID).Documents(ctx)
 	var queries []Query
-	
+
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -357,11 +507,14 @@ func createWalletPass(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var req struct {
-		UserID      string `json:"user_id"`
-		Type        string `json:"type"`
-		Title       string `json:"title"`
-		Description string `json:"description"`
-		Data        string `json:"data"`
+		UserID      string           `json:"user_id"`
+		Type        string           `json:"type"`
+		Title       string           `json:"title"`
+		Description string           `json:"description"`
+		Payload     json.RawMessage  `json:"payload"`
+		Data        string           `json:"data"` // pre-schema payload string, rejected
+		ExpiresAt   *time.Time       `json:"expires_at"`
+		Barcode     *barcode.Barcode `json:"barcode"` // type defaults to the configured one
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -373,33 +526,65 @@ func createWalletPass(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "user_id, type, and title are required", http.StatusBadRequest)
 		return
 	}
+	// The untyped data string predates schema version 1 and its keys, like
+	// items_count, don't map onto the typed payloads
+	if req.Data != "" {
+		http.Error(w, "data is no longer supported, send the pass content as payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Payload) == 0 {
+		http.Error(w, "payload is required", http.StatusBadRequest)
+		return
+	}
+
+	payload, err := wallet.DecodePayload(req.Type, req.Payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Create wallet pass
 	pass := WalletPass{
-		ID:          generateID(),
-		UserID:      req.UserID,
-		Type:        req.Type,
-		Title:       req.Title,
-		Description: req.Description,
-		Data:        req.Data,
-		CreatedAt:   time.Now(),
+		ID:            generateID(),
+		UserID:        req.UserID,
+		Type:          req.Type,
+		Title:         req.Title,
+		Description:   req.Description,
+		SchemaVersion: wallet.SchemaVersion,
+		Payload:       payload,
+		State:         wallet.StateActive,
+		ExpiresAt:     req.ExpiresAt,
+		CreatedAt:     time.Now(),
+	}
+
+	// Passes get a barcode for what they show unless the client brings one,
+	// like a loyalty number
+	cfg := runtimeConfig.Get(ctx)
+	if req.Barcode != nil {
+		if req.Barcode.Type == "" {
+			req.Barcode.Type = cfg.BarcodeType
+		}
+		code, err := barcode.New(req.Barcode.Type, req.Barcode.Value, req.Barcode.AlternateText)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pass.Barcode = &code
+	} else {
+		pass.Barcode = wallet.DefaultBarcode(sharedPass(pass), cfg.BarcodeType, cfg.AppLinkBase)
 	}
 
 	// Save to Firestore
-	_, err := firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass)
+	_, err = firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass)
 	if err != nil {
 		http.Error(w, "Failed to save wallet pass", http.StatusInternalServerError)
 		return
 	}
 
 	// Publish event to Pub/Sub for Google Wallet API integration
-	topic := pubsubClient.Topic("wallet-pass-creation")
-	msg := &pubsub.Message{
-		Data: []byte(fmt.Sprintf(`{"pass_id": "%s", "user_id": "%s", "type": "%s", "title": "%s"}`, pass.ID, req.UserID, req.Type, req.Title)),
-	}
-	topic.Publish(ctx, msg)
+	publishWalletPassEvent(ctx, pass, "created")
 
-	json.NewEncoder(w).Encode(pass)
+	json.NewEncoder(w).Encode(presentWalletPass(pass, time.Now()))
 }
 
 func getWalletPasses(w http.ResponseWriter, r *http.Request) {
@@ -412,7 +597,8 @@ func getWalletPasses(w http.ResponseWriter, r *http.Request) {
 
 	iter := firestoreClient.Collection("wallet_passes").Where("user_id", "==", userID).Documents(ctx)
 	var passes []WalletPass
-	
+	now := time.Now()
+
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -427,7 +613,7 @@ func getWalletPasses(w http.ResponseWriter, r *http.Request) {
 		if err := doc.DataTo(&pass); err != nil {
 			continue
 		}
-		passes = append(passes, pass)
+		passes = append(passes, presentWalletPass(pass, now))
 	}
 
 	json.NewEncoder(w).Encode(passes)
@@ -452,10 +638,15 @@ func getSpendingAnalysis(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user's receipts
-	iter := firestoreClient.Collection("receipts").Where("user_id", "==", userID).Documents(ctx)
+	householdID := r.URL.Query().Get("household_id")
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+
+	// Get user's (or household's) receipts
+	iter := scopedQuery("receipts", userID, householdID).Documents(ctx)
 	var receipts []Receipt
-	
+
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -473,10 +664,30 @@ func getSpendingAnalysis(w http.ResponseWriter, r *http.Request) {
 		receipts = append(receipts, receipt)
 	}
 
-	// Calculate basic analytics
+	// Restrict the analysis to spending covered by a tag
+	tag := normalizeTag(r.URL.Query().Get("tag"))
+	if tag != "" {
+		receipts = taggedPortions(receipts, tag)
+	}
+
+	analysis := summarizeSpending(receipts)
+	analysis["tag_spending"] = tagSpending(receipts)
+	if tag != "" {
+		analysis["tag"] = tag
+	}
+	if householdID != "" {
+		analysis["household_id"] = householdID
+		analysis["member_breakdown"] = memberBreakdown(receipts)
+	}
+
+	json.NewEncoder(w).Encode(analysis)
+}
+
+// summarizeSpending calculates the basic analytics for a set of receipts
+func summarizeSpending(receipts []Receipt) map[string]interface{} {
 	totalSpent := 0.0
 	categorySpending := make(map[string]float64)
-	
+
 	for _, receipt := range receipts {
 		totalSpent += receipt.TotalAmount
 		for _, item := range receipt.Items {
@@ -484,14 +695,31 @@ func getSpendingAnalysis(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	analysis := map[string]interface{}{
-		"total_spent":        totalSpent,
-		"category_spending":  categorySpending,
-		"receipt_count":      len(receipts),
-		"average_per_receipt": totalSpent / float64(len(receipts)),
+	averagePerReceipt := 0.0
+	if len(receipts) > 0 {
+		averagePerReceipt = totalSpent / float64(len(receipts))
 	}
 
-	json.NewEncoder(w).Encode(analysis)
+	return map[string]interface{}{
+		"total_spent":         totalSpent,
+		"category_spending":   categorySpending,
+		"receipt_count":       len(receipts),
+		"average_per_receipt": averagePerReceipt,
+	}
+}
+
+// memberBreakdown splits household spending by the member who uploaded each receipt
+func memberBreakdown(receipts []Receipt) map[string]map[string]interface{} {
+	byMember := make(map[string][]Receipt)
+	for _, receipt := range receipts {
+		byMember[receipt.UserID] = append(byMember[receipt.UserID], receipt)
+	}
+
+	breakdown := make(map[string]map[string]interface{}, len(byMember))
+	for userID, memberReceipts := range byMember {
+		breakdown[userID] = summarizeSpending(memberReceipts)
+	}
+	return breakdown
 }
 
 func stockItemsHandler(w http.ResponseWriter, r *http.Request) {
@@ -516,10 +744,12 @@ func createStockItem(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		UserID       string    `json:"user_id"`
+		HouseholdID  string    `json:"household_id"`
 		Name         string    `json:"name"`
 		Category     string    `json:"category"`
 		Quantity     int       `json:"quantity"`
 		Unit         string    `json:"unit"`
+		Storage      string    `json:"storage"`
 		PurchaseDate time.Time `json:"purchase_date"`
 		ExpiryDate   time.Time `json:"expiry_date"`
 	}
@@ -533,31 +763,48 @@ func createStockItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "user_id and name are required", http.StatusBadRequest)
 		return
 	}
+	if req.Storage != "" && !shelflife.IsValidStorage(req.Storage) {
+		http.Error(w, "storage must be pantry, fridge or freezer", http.StatusBadRequest)
+		return
+	}
 
-	// Determine status based on expiry date
-	status := "fresh"
-	now := time.Now()
-	if req.ExpiryDate.Before(now) {
-		status = "expired"
-	} else if req.ExpiryDate.Sub(now) < 7*24*time.Hour { // 7 days
-		status = "expiring_soon"
+	if req.HouseholdID != "" && authorizeHousehold(w, r, req.HouseholdID, req.UserID, true) == nil {
+		return
+	}
+
+	if req.Storage == "" {
+		req.Storage = shelflife.DefaultStorage(req.Category)
+	}
+	if req.PurchaseDate.IsZero() {
+		req.PurchaseDate = time.Now()
 	}
 
 	// Create stock item
 	item := StockItem{
-		ID:           generateID(),
-		UserID:       req.UserID,
-		Name:         req.Name,
-		Category:     req.Category,
-		Quantity:     req.Quantity,
-		Unit:         req.Unit,
-		PurchaseDate: req.PurchaseDate,
-		ExpiryDate:   req.ExpiryDate,
-		Status:       status,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		ID:             generateID(),
+		UserID:         req.UserID,
+		HouseholdID:    req.HouseholdID,
+		Name:           req.Name,
+		NormalizedName: catalog.NormalizeName(req.Name),
+		Category:       req.Category,
+		Quantity:       req.Quantity,
+		Unit:           req.Unit,
+		Storage:        req.Storage,
+		PurchaseDate:   req.PurchaseDate,
+		ExpiryDate:     req.ExpiryDate,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	// Estimate the expiry date when the user doesn't know it
+	if item.ExpiryDate.IsZero() {
+		estimateExpiry(&item)
 	}
 
+	// Determine status based on expiry date
+	status := runtimeConfig.Get(ctx).ExpiryStatus(item.ExpiryDate, time.Now())
+	item.Status = status
+
 	// Save to Firestore
 	_, err := firestoreClient.Collection("stock_items").Doc(item.ID).Set(ctx, item)
 	if err != nil {
@@ -583,18 +830,22 @@ func getStockItems(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	householdID := r.URL.Query().Get("household_id")
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+
 	// Get status filter if provided
 	status := r.URL.Query().Get("status")
 
-	var iter *firestore.DocumentIterator
+	query := scopedQuery("stock_items", userID, householdID)
 	if status != "" {
-		iter = firestoreClient.Collection("stock_items").Where("user_id", "==", userID).Where("status", "==", status).Documents(ctx)
-	} else {
-		iter = firestoreClient.Collection("stock_items").Where("user_id", "==", userID).Documents(ctx)
+		query = query.Where("status", "==", status)
 	}
+	iter := query.Documents(ctx)
 
 	var items []StockItem
-	
+
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
@@ -628,6 +879,7 @@ func updateStockItem(w http.ResponseWriter, r *http.Request) {
 		Category   string    `json:"category"`
 		Quantity   int       `json:"quantity"`
 		Unit       string    `json:"unit"`
+		Storage    string    `json:"storage"`
 		ExpiryDate time.Time `json:"expiry_date"`
 	}
 
@@ -636,6 +888,11 @@ func updateStockItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Storage != "" && !shelflife.IsValidStorage(req.Storage) {
+		http.Error(w, "storage must be pantry, fridge or freezer", http.StatusBadRequest)
+		return
+	}
+
 	// Get existing item
 	doc, err := firestoreClient.Collection("stock_items").Doc(itemID).Get(ctx)
 	if err != nil {
@@ -656,6 +913,7 @@ func updateStockItem(w http.ResponseWriter, r *http.Request) {
 	// Update fields
 	if req.Name != "" {
 		item.Name = req.Name
+		item.NormalizedName = catalog.NormalizeName(req.Name)
 	}
 	if req.Category != "" {
 		item.Category = req.Category
@@ -666,18 +924,23 @@ func updateStockItem(w http.ResponseWriter, r *http.Request) {
 	if req.Unit != "" {
 		item.Unit = req.Unit
 	}
+	if req.Storage != "" {
+		item.Storage = req.Storage
+	}
+
+	// A user-supplied expiry date overrides the estimate; otherwise keep the
+	// estimate in line with the item's current name, category and storage
 	if !req.ExpiryDate.IsZero() {
 		item.ExpiryDate = req.ExpiryDate
+		item.ExpiryEstimated = false
+	} else if item.ExpiryEstimated || item.ExpiryDate.IsZero() {
+		estimateExpiry(&item)
 	}
 
-	// Update status based on new expiry date
-	now := time.Now()
-	if item.ExpiryDate.Before(now) {
-		item.Status = "expired"
-	} else if item.ExpiryDate.Sub(now) < 7*24*time.Hour {
-		item.Status = "expiring_soon"
-	} else {
-		item.Status = "fresh"
+	// Update status based on new expiry date; used-up items stay depleted
+	// until they are restocked
+	if item.Status != StatusDepleted || item.Quantity > 0 {
+		item.Status = runtimeConfig.Get(ctx).ExpiryStatus(item.ExpiryDate, time.Now())
 	}
 
 	item.UpdatedAt = time.Now()
@@ -741,6 +1004,40 @@ func deleteStockItem(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// estimateExpiry sets an estimated expiry date from the item's purchase date,
+// name, category and storage, when the shelf-life table knows the item
+func estimateExpiry(item *StockItem) {
+	purchased := item.PurchaseDate
+	if purchased.IsZero() {
+		purchased = item.CreatedAt
+	}
+	if purchased.IsZero() {
+		return
+	}
+	if expiry, ok := shelflife.ExpiryDate(purchased, item.Name, item.Category, item.Storage); ok {
+		item.ExpiryDate = expiry
+		item.ExpiryEstimated = true
+	}
+}
+
+// requireAdmin checks the bearer token on scheduled-task and admin endpoints
+// against ADMIN_TOKEN. It writes the error response and returns false when the
+// token is missing or wrong, or when no token is configured.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func generateID() string {
 	return fmt.Sprintf("%d", time.Now().UnixNano())
-} 
\ No newline at end of file
+}
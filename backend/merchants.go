@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/merchants"
+	"raseed-shared/prices"
+)
+
+var (
+	errMerchantNotFound = errors.New("merchant not found")
+	errInvalidMerge     = errors.New("invalid merge")
+)
+
+func merchantsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		getMerchants(w, r)
+	case "PUT":
+		updateMerchant(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getMerchants lists the merchant directory, optionally filtered by a name
+// fragment, or returns one merchant by ID
+func getMerchants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		merchant, err := getMerchant(ctx, id)
+		if err == errMerchantNotFound {
+			http.Error(w, "Merchant not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch merchant", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(merchant)
+		return
+	}
+
+	query := merchants.Key(r.URL.Query().Get("q"))
+	iter := firestoreClient.Collection("merchants").Documents(ctx)
+	list := []merchants.Merchant{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch merchants", http.StatusInternalServerError)
+			return
+		}
+
+		var merchant merchants.Merchant
+		if err := doc.DataTo(&merchant); err != nil {
+			continue
+		}
+		if merchant.MergedInto != "" || !matchesMerchant(merchant, query) {
+			continue
+		}
+		list = append(list, merchant)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	json.NewEncoder(w).Encode(list)
+}
+
+func matchesMerchant(merchant merchants.Merchant, query string) bool {
+	if query == "" {
+		return true
+	}
+	for _, key := range merchant.AliasKeys {
+		if strings.Contains(key, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateMerchant lets an admin rename a merchant or set its category,
+// location and extra aliases
+func updateMerchant(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name     *string             `json:"name"`
+		Category *string             `json:"category"`
+		Location *merchants.Location `json:"location"`
+		Aliases  []string            `json:"aliases"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Category != nil {
+		if _, ok := merchants.Categories[*req.Category]; !ok {
+			http.Error(w, "Unknown merchant category", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Name != nil && merchants.Key(*req.Name) == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	var merchant merchants.Merchant
+	ref := firestoreClient.Collection("merchants").Doc(id)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errMerchantNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&merchant); err != nil {
+			return err
+		}
+		if merchant.MergedInto != "" {
+			return errMerchantNotFound
+		}
+
+		if req.Name != nil {
+			merchant.Name = strings.TrimSpace(*req.Name)
+			merchant.AddAlias(merchant.Name)
+		}
+		if req.Category != nil {
+			merchant.Category = *req.Category
+		}
+		if req.Location != nil {
+			merchant.Location = req.Location
+		}
+		for _, alias := range req.Aliases {
+			merchant.AddAlias(alias)
+		}
+		merchant.UpdatedAt = time.Now()
+		return tx.Set(ref, merchant)
+	})
+	if err == errMerchantNotFound {
+		http.Error(w, "Merchant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update merchant", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// mergeMerchantsHandler folds duplicate merchants into one. The sources keep
+// their documents, marked merged_into the target, and receipts, bills, price
+// points and loyalty cards that referenced them are moved over.
+func mergeMerchantsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+
+	var req struct {
+		TargetID  string   `json:"target_id"`
+		SourceIDs []string `json:"source_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TargetID == "" || len(req.SourceIDs) == 0 {
+		http.Error(w, "target_id and source_ids are required", http.StatusBadRequest)
+		return
+	}
+
+	var target merchants.Merchant
+	var sources []merchants.Merchant
+	targetRef := firestoreClient.Collection("merchants").Doc(req.TargetID)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		refs := []*firestore.DocumentRef{targetRef}
+		for _, id := range req.SourceIDs {
+			refs = append(refs, firestoreClient.Collection("merchants").Doc(id))
+		}
+		docs, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+
+		loaded := make([]merchants.Merchant, len(docs))
+		for i, doc := range docs {
+			if !doc.Exists() {
+				return errMerchantNotFound
+			}
+			if err := doc.DataTo(&loaded[i]); err != nil {
+				return err
+			}
+		}
+
+		target = loaded[0]
+		sources = loaded[1:]
+		if err := mergeMerchants(&target, sources, time.Now()); err != nil {
+			return err
+		}
+
+		if err := tx.Set(targetRef, target); err != nil {
+			return err
+		}
+		for i, source := range sources {
+			if err := tx.Set(refs[i+1], source); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == errMerchantNotFound {
+		http.Error(w, "Merchant not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errInvalidMerge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to merge merchants", http.StatusInternalServerError)
+		return
+	}
+	merchantDir.Invalidate()
+
+	moved, err := repointMerchants(ctx, sources, target)
+	if err != nil {
+		// The directory is merged; rerunning the merge finishes the move
+		log.Printf("Failed to move references to merchant %s: %v", target.ID, err)
+		http.Error(w, "Merged, but failed to update references", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merchant": target,
+		"moved":    moved,
+	})
+}
+
+// mergeMerchants folds the sources into the target and marks them merged.
+// Merging a merchant twice is allowed so an interrupted merge can be rerun.
+func mergeMerchants(target *merchants.Merchant, sources []merchants.Merchant, now time.Time) error {
+	if target.MergedInto != "" {
+		return fmt.Errorf("%w: %s was merged into %s", errInvalidMerge, target.ID, target.MergedInto)
+	}
+	for i := range sources {
+		source := &sources[i]
+		if source.ID == target.ID {
+			return fmt.Errorf("%w: cannot merge %s into itself", errInvalidMerge, target.ID)
+		}
+		if source.MergedInto != "" && source.MergedInto != target.ID {
+			return fmt.Errorf("%w: %s was already merged into %s", errInvalidMerge, source.ID, source.MergedInto)
+		}
+
+		target.Absorb(*source)
+		if target.Category == "" || target.Category == "5999" {
+			target.Category = source.Category
+		}
+		source.MergedInto = target.ID
+		source.AliasKeys = []string{}
+		source.UpdatedAt = now
+	}
+	target.UpdatedAt = now
+	return nil
+}
+
+// repointMerchants moves receipts, third-party bills, price points and loyalty
+// cards from the merged merchants to the target, returning how many documents
+// changed
+func repointMerchants(ctx context.Context, sources []merchants.Merchant, target merchants.Merchant) (int, error) {
+	sourceIDs := make([]string, len(sources))
+	for i, source := range sources {
+		sourceIDs[i] = source.ID
+	}
+
+	moves := []struct {
+		collection string
+		field      string
+		values     []string
+		updates    []firestore.Update
+	}{
+		{"receipts", "merchant_id", sourceIDs, []firestore.Update{
+			{Path: "merchant_id", Value: target.ID},
+			{Path: "store_name", Value: target.Name},
+		}},
+		{"third_party_bills", "merchant_id", sourceIDs, []firestore.Update{
+			{Path: "merchant_id", Value: target.ID},
+			{Path: "merchant_name", Value: target.Name},
+		}},
+		{"price_points", "store_key", sourceStoreKeys(sources), []firestore.Update{
+			{Path: "store_key", Value: target.ID},
+			{Path: "store", Value: target.Name},
+		}},
+		{"loyalty_cards", "merchant_id", sourceIDs, []firestore.Update{
+			{Path: "merchant_id", Value: target.ID},
+			{Path: "merchant_name", Value: target.Name},
+		}},
+	}
+
+	writer := firestoreClient.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, move := range moves {
+		for _, value := range move.values {
+			iter := firestoreClient.Collection(move.collection).Where(move.field, "==", value).Documents(ctx)
+			for {
+				doc, err := iter.Next()
+				if err == iterator.Done {
+					break
+				}
+				if err != nil {
+					writer.End()
+					return 0, fmt.Errorf("%s: %v", move.collection, err)
+				}
+				job, err := writer.Update(doc.Ref, move.updates)
+				if err != nil {
+					writer.End()
+					return 0, err
+				}
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	writer.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return 0, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// sourceStoreKeys lists the store_key values price points of the merged
+// merchants can have: the merchant ID, or the store key of a name seen on
+// receipts from before the merchant was resolved
+func sourceStoreKeys(sources []merchants.Merchant) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, source := range sources {
+		add(source.ID)
+		add(prices.StoreKey(source.Name))
+		for _, alias := range source.Aliases {
+			add(prices.StoreKey(alias))
+		}
+	}
+	return keys
+}
+
+func getMerchant(ctx context.Context, id string) (merchants.Merchant, error) {
+	var merchant merchants.Merchant
+	doc, err := firestoreClient.Collection("merchants").Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return merchant, errMerchantNotFound
+		}
+		return merchant, err
+	}
+	err = doc.DataTo(&merchant)
+	return merchant, err
+}
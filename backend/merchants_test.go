@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"raseed-shared/merchants"
+)
+
+func TestMergeMerchants(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	target := merchants.Merchant{ID: "dmart", Name: "D-Mart", Aliases: []string{"DMART"}, AliasKeys: []string{"dmart"}, Category: "5999"}
+	sources := []merchants.Merchant{
+		{ID: "avenuesupermarts", Name: "Avenue Supermarts", Aliases: []string{"Avenue Supermarts"}, AliasKeys: []string{"avenuesupermarts"}, Category: "5411"},
+	}
+
+	if err := mergeMerchants(&target, sources, now); err != nil {
+		t.Fatalf("mergeMerchants returned %v", err)
+	}
+	if len(target.AliasKeys) != 2 || target.Category != "5411" || !target.UpdatedAt.Equal(now) {
+		t.Errorf("Expected the source to be absorbed, got %+v", target)
+	}
+	if sources[0].MergedInto != "dmart" || len(sources[0].AliasKeys) != 0 {
+		t.Errorf("Expected the source to be marked merged, got %+v", sources[0])
+	}
+
+	// Rerunning an interrupted merge is fine
+	if err := mergeMerchants(&target, sources, now); err != nil {
+		t.Errorf("Expected a repeated merge to succeed, got %v", err)
+	}
+
+	if err := mergeMerchants(&target, []merchants.Merchant{target}, now); !errors.Is(err, errInvalidMerge) {
+		t.Errorf("Expected merging into itself to fail, got %v", err)
+	}
+	elsewhere := []merchants.Merchant{{ID: "x", MergedInto: "other"}}
+	if err := mergeMerchants(&target, elsewhere, now); !errors.Is(err, errInvalidMerge) {
+		t.Errorf("Expected a merchant merged elsewhere to be rejected, got %v", err)
+	}
+}
+
+func TestSourceStoreKeys(t *testing.T) {
+	sources := []merchants.Merchant{
+		{ID: "avenuesupermarts", Name: "Avenue Supermarts", Aliases: []string{"AVENUE  SUPERMARTS", "DMart Ready"}},
+		{ID: "dmartready", Name: "DMart Ready"},
+	}
+	want := []string{"avenuesupermarts", "avenue supermarts", "dmart ready", "dmartready"}
+	if got := sourceStoreKeys(sources); !reflect.DeepEqual(got, want) {
+		t.Errorf("sourceStoreKeys = %v, want %v", got, want)
+	}
+}
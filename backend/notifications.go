@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestorepb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+)
+
+// InboxNotification is a notification in the user's in-app inbox, written by
+// the notification processor from notification-events
+type InboxNotification struct {
+	ID        string                 `json:"id" firestore:"id"`
+	UserID    string                 `json:"user_id" firestore:"user_id"`
+	Type      string                 `json:"type" firestore:"type"`
+	Title     string                 `json:"title" firestore:"title"`
+	Message   string                 `json:"message" firestore:"message"`
+	Data      map[string]interface{} `json:"data,omitempty" firestore:"data,omitempty"`
+	Read      bool                   `json:"read" firestore:"read"`
+	ReadAt    *time.Time             `json:"read_at,omitempty" firestore:"read_at,omitempty"`
+	CreatedAt time.Time              `json:"created_at" firestore:"created_at"`
+}
+
+// Inbox page sizes
+const (
+	defaultInboxLimit = 50
+	maxInboxLimit     = 200
+)
+
+// inboxQuery is a page of a user's inbox, newest first
+type inboxQuery struct {
+	UserID     string
+	UnreadOnly bool
+	Limit      int
+	Before     time.Time // only notifications created before; zero for the newest
+}
+
+// parseInboxQuery reads ?user_id&unread&limit&before
+func parseInboxQuery(values url.Values) (inboxQuery, error) {
+	q := inboxQuery{UserID: values.Get("user_id"), Limit: defaultInboxLimit}
+	if q.UserID == "" {
+		return q, errors.New("user_id is required")
+	}
+	if v := values.Get("unread"); v != "" {
+		unread, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, errors.New("unread must be true or false")
+		}
+		q.UnreadOnly = unread
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return q, errors.New("limit must be a positive integer")
+		}
+		if limit > maxInboxLimit {
+			limit = maxInboxLimit
+		}
+		q.Limit = limit
+	}
+	if v := values.Get("before"); v != "" {
+		before, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return q, errors.New("before must be an RFC 3339 timestamp")
+		}
+		q.Before = before
+	}
+	return q, nil
+}
+
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		getNotifications(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getNotifications returns a page of the user's inbox with their unread
+// count. The next page is requested with before set to next_before.
+func getNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q, err := parseInboxQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := firestoreClient.Collection("notifications").Where("user_id", "==", q.UserID)
+	if q.UnreadOnly {
+		query = query.Where("read", "==", false)
+	}
+	if !q.Before.IsZero() {
+		query = query.Where("created_at", "<", q.Before)
+	}
+	iter := query.OrderBy("created_at", firestore.Desc).Limit(q.Limit).Documents(ctx)
+	notifications := []InboxNotification{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+			return
+		}
+
+		var n InboxNotification
+		if err := doc.DataTo(&n); err != nil {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	unread, err := countUnread(ctx, q.UserID)
+	if err != nil {
+		http.Error(w, "Failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"notifications": notifications,
+		"unread_count":  unread,
+	}
+	if len(notifications) == q.Limit {
+		response["next_before"] = notifications[len(notifications)-1].CreatedAt.Format(time.RFC3339Nano)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// unreadCountHandler returns the user's unread count, for the app's badge
+func unreadCountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	unread, err := countUnread(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]int64{"unread_count": unread})
+}
+
+// countUnread counts the user's unread notifications without reading them
+func countUnread(ctx context.Context, userID string) (int64, error) {
+	query := firestoreClient.Collection("notifications").
+		Where("user_id", "==", userID).Where("read", "==", false)
+	result, err := query.NewAggregationQuery().WithCount("unread").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := result["unread"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", result["unread"])
+	}
+	return count.GetIntegerValue(), nil
+}
+
+// markReadHandler marks some of the user's notifications read. IDs that
+// aren't the user's, or are already read, are left alone.
+func markReadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UserID string   `json:"user_id"`
+		IDs    []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" || len(req.IDs) == 0 {
+		http.Error(w, "user_id and ids are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > maxInboxLimit {
+		http.Error(w, fmt.Sprintf("At most %d ids can be marked at once", maxInboxLimit), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	refs := make([]*firestore.DocumentRef, len(req.IDs))
+	for i, id := range req.IDs {
+		refs[i] = firestoreClient.Collection("notifications").Doc(id)
+	}
+	docs, err := firestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	var unread []*firestore.DocumentRef
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var n InboxNotification
+		if err := doc.DataTo(&n); err != nil || n.UserID != req.UserID || n.Read {
+			continue
+		}
+		unread = append(unread, doc.Ref)
+	}
+
+	if err := markRead(ctx, unread, time.Now()); err != nil {
+		log.Printf("Failed to mark notifications read for %s: %v", req.UserID, err)
+		http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+	respondMarked(w, r, req.UserID, len(unread))
+}
+
+// markAllReadHandler marks every unread notification of the user read
+func markAllReadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	query := firestoreClient.Collection("notifications").
+		Where("user_id", "==", req.UserID).Where("read", "==", false).Limit(500)
+
+	marked := 0
+	for {
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+			return
+		}
+		if len(docs) == 0 {
+			break
+		}
+		refs := make([]*firestore.DocumentRef, len(docs))
+		for i, doc := range docs {
+			refs[i] = doc.Ref
+		}
+		if err := markRead(ctx, refs, now); err != nil {
+			log.Printf("Failed to mark notifications read for %s: %v", req.UserID, err)
+			http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+			return
+		}
+		marked += len(refs)
+	}
+	respondMarked(w, r, req.UserID, marked)
+}
+
+func markRead(ctx context.Context, refs []*firestore.DocumentRef, now time.Time) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	writer := firestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(refs))
+	for _, ref := range refs {
+		job, err := writer.Update(ref, []firestore.Update{
+			{Path: "read", Value: true},
+			{Path: "read_at", Value: now},
+		})
+		if err != nil {
+			writer.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respondMarked reports how many notifications were marked and what is left
+// unread, so the app can update its badge without another request
+func respondMarked(w http.ResponseWriter, r *http.Request, userID string, marked int) {
+	unread, err := countUnread(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to count notifications", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"marked_read":  marked,
+		"unread_count": unread,
+	})
+}
+
+// notificationCleanupHandler deletes inbox notifications, and the records of
+// their delivery, once they are older than the configured retention. It is
+// triggered daily by Cloud Scheduler.
+func notificationCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	cutoff := time.Now().Add(-runtimeConfig.Get(ctx).NotificationRetention)
+
+	deleted := make(map[string]int)
+	for _, collection := range []string{"notifications", "notification_deliveries"} {
+		n, err := deleteCreatedBefore(ctx, collection, cutoff)
+		deleted[collection] = n
+		if err != nil {
+			log.Printf("Notification cleanup failed on %s after %d deletions: %v", collection, n, err)
+			http.Error(w, "Failed to delete old notifications", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("Notification cleanup deleted %d notifications and %d delivery records created before %s",
+		deleted["notifications"], deleted["notification_deliveries"], cutoff.Format(time.RFC3339))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cutoff":                   cutoff,
+		"notifications_deleted":    deleted["notifications"],
+		"delivery_records_deleted": deleted["notification_deliveries"],
+	})
+}
+
+// deleteCreatedBefore deletes a collection's documents created before cutoff,
+// a page at a time
+func deleteCreatedBefore(ctx context.Context, collection string, cutoff time.Time) (int, error) {
+	query := firestoreClient.Collection(collection).Where("created_at", "<", cutoff).Limit(500)
+
+	deleted := 0
+	for {
+		docs, err := query.Documents(ctx).GetAll()
+		if err != nil {
+			return deleted, err
+		}
+		if len(docs) == 0 {
+			return deleted, nil
+		}
+
+		writer := firestoreClient.BulkWriter(ctx)
+		jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+		for _, doc := range docs {
+			job, err := writer.Delete(doc.Ref)
+			if err != nil {
+				writer.End()
+				return deleted, err
+			}
+			jobs = append(jobs, job)
+		}
+		writer.End()
+
+		for _, job := range jobs {
+			if _, err := job.Results(); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseInboxQuery(t *testing.T) {
+	q, err := parseInboxQuery(url.Values{"user_id": {"u1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != defaultInboxLimit || q.UnreadOnly || !q.Before.IsZero() {
+		t.Errorf("Unexpected defaults %+v", q)
+	}
+
+	q, err = parseInboxQuery(url.Values{
+		"user_id": {"u1"},
+		"unread":  {"true"},
+		"limit":   {"1000"},
+		"before":  {"2026-03-01T10:00:00.5Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.UnreadOnly || q.Limit != maxInboxLimit {
+		t.Errorf("Expected unread only, capped at %d; got %+v", maxInboxLimit, q)
+	}
+	if want := time.Date(2026, 3, 1, 10, 0, 0, 5e8, time.UTC); !q.Before.Equal(want) {
+		t.Errorf("before = %v, want %v", q.Before, want)
+	}
+
+	for _, bad := range []url.Values{
+		{},
+		{"user_id": {"u1"}, "limit": {"0"}},
+		{"user_id": {"u1"}, "unread": {"maybe"}},
+		{"user_id": {"u1"}, "before": {"yesterday"}},
+	} {
+		if _, err := parseInboxQuery(bad); err == nil {
+			t.Errorf("Expected %v to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/iterator"
+
+	"raseed-shared/catalog"
+	"raseed-shared/prices"
+)
+
+// pricesHandler returns an item's price history per store and the cheapest
+// store among those visited recently
+func pricesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	householdID := r.URL.Query().Get("household_id")
+	item := catalog.NormalizeName(r.URL.Query().Get("item"))
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if item == "" {
+		http.Error(w, "item is required", http.StatusBadRequest)
+		return
+	}
+
+	recentDays := 90
+	if v := r.URL.Query().Get("recent_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "recent_days must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		recentDays = n
+	}
+
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+
+	iter := scopedQuery("price_points", userID, householdID).Where("normalized_name", "==", item).Documents(ctx)
+	var points []prices.Point
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch prices", http.StatusInternalServerError)
+			return
+		}
+
+		var point prices.Point
+		if err := doc.DataTo(&point); err != nil {
+			continue
+		}
+		points = append(points, point)
+	}
+
+	if len(points) == 0 {
+		http.Error(w, "No prices recorded for this item", http.StatusNotFound)
+		return
+	}
+
+	summary := prices.Summarize(points, time.Now(), time.Duration(recentDays)*24*time.Hour)
+	json.NewEncoder(w).Encode(summary)
+}
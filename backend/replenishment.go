@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+
+	"raseed-shared/catalog"
+	"raseed-shared/config"
+)
+
+// replenishmentHistory is how far back purchases and consumption are considered
+const replenishmentHistory = 180 * 24 * time.Hour
+
+// Replenishment is an item predicted to run out within the requested window
+type Replenishment struct {
+	Name            string    `json:"name"`
+	NormalizedName  string    `json:"normalized_name"`
+	Category        string    `json:"category"`
+	SuggestedQty    int       `json:"suggested_quantity"`
+	InStock         int       `json:"in_stock"`
+	PurchaseCount   int       `json:"purchase_count"`
+	AvgIntervalDays float64   `json:"avg_interval_days"`
+	DailyRate       float64   `json:"daily_rate"`
+	LastPurchased   time.Time `json:"last_purchased"`
+	RunOutDate      time.Time `json:"run_out_date"`
+	DaysLeft        float64   `json:"days_left"`
+	Basis           string    `json:"basis"` // consumption, purchases, interval, depleted
+}
+
+// purchaseHistory collects every purchase of one product
+type purchaseHistory struct {
+	name     string
+	category string
+	days     map[string]int // purchase day (YYYY-MM-DD) -> quantity bought
+}
+
+// stockLevel is the current pantry quantity of one product
+type stockLevel struct {
+	quantity int
+	tracked  bool
+}
+
+// consumptionStats totals the consumption ledger of one product
+type consumptionStats struct {
+	amount int
+	first  time.Time
+	last   time.Time
+}
+
+// purchaseHistories groups receipt items by normalized name, keeping only
+// stockable categories so restaurant bills and electronics are ignored
+func purchaseHistories(receipts []Receipt, cfg config.Config, since time.Time) map[string]*purchaseHistory {
+	histories := make(map[string]*purchaseHistory)
+	for _, receipt := range receipts {
+		if receipt.Date.Before(since) {
+			continue
+		}
+		day := receipt.Date.Format("2006-01-02")
+		for _, item := range receipt.Items {
+			key := catalog.NormalizeName(item.Name)
+			if key == "" || !cfg.IsStockable(item.Category) {
+				continue
+			}
+			h := histories[key]
+			if h == nil {
+				h = &purchaseHistory{name: item.Name, category: item.Category, days: make(map[string]int)}
+				histories[key] = h
+			}
+			quantity := item.Quantity
+			if quantity <= 0 {
+				quantity = 1
+			}
+			h.days[day] += quantity
+		}
+	}
+	return histories
+}
+
+// predictReplenishment estimates when each regularly bought product runs out
+// and returns those due within the window, soonest first.
+//
+// Products bought at least twice count as regular. When the pantry tracks the
+// product, its remaining quantity is divided by the daily consumption rate
+// (from the consumption ledger, or failing that from how much was bought over
+// the history). Otherwise the product is expected to run out one average
+// purchase interval after it was last bought. Tracked products that are used
+// up are always due.
+func predictReplenishment(histories map[string]*purchaseHistory, stock map[string]stockLevel, consumption map[string]consumptionStats, now time.Time, window time.Duration) []Replenishment {
+	var due []Replenishment
+	for key, h := range histories {
+		days := make([]string, 0, len(h.days))
+		total := 0
+		for day, quantity := range h.days {
+			days = append(days, day)
+			total += quantity
+		}
+		sort.Strings(days)
+
+		level := stock[key]
+		if len(days) < 2 && !(level.tracked && level.quantity == 0) {
+			continue
+		}
+
+		first, _ := time.Parse("2006-01-02", days[0])
+		last, _ := time.Parse("2006-01-02", days[len(days)-1])
+		r := Replenishment{
+			Name:           h.name,
+			NormalizedName: key,
+			Category:       h.category,
+			SuggestedQty:   int(math.Max(1, math.Round(float64(total)/float64(len(days))))),
+			InStock:        level.quantity,
+			PurchaseCount:  len(days),
+			LastPurchased:  last,
+		}
+
+		span := last.Sub(first).Hours() / 24
+		if len(days) > 1 {
+			r.AvgIntervalDays = span / float64(len(days)-1)
+			// Everything but the last purchase was used up between the first
+			// and last purchase
+			r.DailyRate = float64(total-h.days[days[len(days)-1]]) / span
+		}
+		if c, ok := consumption[key]; ok {
+			if used := c.last.Sub(c.first).Hours() / 24; used >= 1 && c.amount > 0 {
+				r.DailyRate = float64(c.amount) / used
+			}
+		}
+
+		switch {
+		case level.tracked && level.quantity == 0:
+			r.RunOutDate = now
+			r.Basis = "depleted"
+		case level.tracked && r.DailyRate > 0:
+			r.RunOutDate = now.Add(time.Duration(float64(level.quantity) / r.DailyRate * float64(24*time.Hour)))
+			r.Basis = "consumption"
+			if _, ok := consumption[key]; !ok {
+				r.Basis = "purchases"
+			}
+		default:
+			r.RunOutDate = last.Add(time.Duration(r.AvgIntervalDays * float64(24*time.Hour)))
+			r.Basis = "interval"
+		}
+
+		if r.RunOutDate.After(now.Add(window)) {
+			continue
+		}
+		r.DaysLeft = math.Max(0, math.Round(r.RunOutDate.Sub(now).Hours()/24*10)/10)
+		due = append(due, r)
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].RunOutDate.Equal(due[j].RunOutDate) {
+			return due[i].RunOutDate.Before(due[j].RunOutDate)
+		}
+		return due[i].NormalizedName < due[j].NormalizedName
+	})
+	return due
+}
+
+func replenishmentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		getReplenishment(w, r)
+	case "POST":
+		createReplenishmentList(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getReplenishment(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	householdID := r.URL.Query().Get("household_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "days must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+
+	items, err := replenishmentFor(r.Context(), userID, householdID, days)
+	if err != nil {
+		http.Error(w, "Failed to predict replenishment", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"days":  days,
+		"items": items,
+	})
+}
+
+// createReplenishmentList saves the due items as a shopping list, replacing
+// the previous replenishment list, and optionally notifies the user
+func createReplenishmentList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID      string `json:"user_id"`
+		HouseholdID string `json:"household_id"`
+		Days        *int   `json:"days"`
+		Notify      bool   `json:"notify"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	days := 7
+	if req.Days != nil {
+		if *req.Days < 0 {
+			http.Error(w, "days must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		days = *req.Days
+	}
+
+	if req.HouseholdID != "" && authorizeHousehold(w, r, req.HouseholdID, req.UserID, true) == nil {
+		return
+	}
+
+	items, err := replenishmentFor(ctx, req.UserID, req.HouseholdID, days)
+	if err != nil {
+		http.Error(w, "Failed to predict replenishment", http.StatusInternalServerError)
+		return
+	}
+
+	// One replenishment list per user or household, regenerated each time
+	now := time.Now()
+	list := ShoppingList{
+		ID:          "replenish_" + req.UserID,
+		Name:        "Running Low",
+		OwnerID:     req.UserID,
+		HouseholdID: req.HouseholdID,
+		Items:       []ShoppingListItem{},
+		Source:      "replenishment",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if req.HouseholdID != "" {
+		list.ID = "replenish_household_" + req.HouseholdID
+	}
+	list.Share(nil)
+	for _, item := range items {
+		list.AddItem(listItemInput{Name: item.Name, Quantity: item.SuggestedQty, Category: item.Category}, req.UserID, now)
+	}
+
+	_, err = firestoreClient.Collection("shopping_lists").Doc(list.ID).Set(ctx, list)
+	if err != nil {
+		http.Error(w, "Failed to save shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	notified := false
+	if req.Notify && len(items) > 0 {
+		if err := publishReplenishment(ctx, req.UserID, list.ID, items); err != nil {
+			http.Error(w, "Failed to send notification", http.StatusInternalServerError)
+			return
+		}
+		notified = true
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"days":     days,
+		"items":    items,
+		"list":     list,
+		"notified": notified,
+	})
+}
+
+// replenishmentFor loads the purchase history, pantry and consumption ledger
+// for a user or household and predicts what runs out within days
+func replenishmentFor(ctx context.Context, userID, householdID string, days int) ([]Replenishment, error) {
+	now := time.Now()
+	since := now.Add(-replenishmentHistory)
+
+	var receipts []Receipt
+	iter := scopedQuery("receipts", userID, householdID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var receipt Receipt
+		if err := doc.DataTo(&receipt); err != nil {
+			continue
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	stock := make(map[string]stockLevel)
+	iter = scopedQuery("stock_items", userID, householdID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var item StockItem
+		if err := doc.DataTo(&item); err != nil {
+			continue
+		}
+		key := item.NormalizedName
+		if key == "" {
+			key = catalog.NormalizeName(item.Name)
+		}
+		level := stock[key]
+		level.quantity += item.Quantity
+		level.tracked = true
+		stock[key] = level
+	}
+
+	// Ledger entries carry the item owner's user_id, so personal scope
+	// matches the stock items above whoever recorded the consumption
+	consumption := make(map[string]consumptionStats)
+	iter = scopedQuery("stock_consumption", userID, householdID).Where("created_at", ">=", since).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry StockConsumption
+		if err := doc.DataTo(&entry); err != nil {
+			continue
+		}
+		c := consumption[entry.NormalizedName]
+		c.amount += entry.Amount
+		if c.first.IsZero() || entry.CreatedAt.Before(c.first) {
+			c.first = entry.CreatedAt
+		}
+		if entry.CreatedAt.After(c.last) {
+			c.last = entry.CreatedAt
+		}
+		consumption[entry.NormalizedName] = c
+	}
+
+	histories := purchaseHistories(receipts, runtimeConfig.Get(ctx), since)
+	window := time.Duration(days) * 24 * time.Hour
+	return predictReplenishment(histories, stock, consumption, now, window), nil
+}
+
+func publishReplenishment(ctx context.Context, userID, listID string, items []Replenishment) error {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.Name
+	}
+
+	notification := map[string]interface{}{
+		"id":      "replenishment_" + listID + "_" + generateID(), // redeliveries aren't sent twice
+		"user_id": userID,
+		"type":    "replenishment",
+		"title":   "Running Low",
+		"message": fmt.Sprintf("%d items to restock soon: %s", len(items), strings.Join(names, ", ")),
+		"data": map[string]interface{}{
+			"list_id": listID,
+			"items":   items,
+		},
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	topic := pubsubClient.Topic("notification-events")
+	_, err = topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
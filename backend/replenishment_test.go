@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"raseed-shared/config"
+)
+
+func TestPurchaseHistories(t *testing.T) {
+	cfg := config.Defaults()
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	receipts := []Receipt{
+		{Date: now.Add(-14 * 24 * time.Hour), Items: []Item{
+			{Name: "Milk 1L", Category: "dairy", Quantity: 2},
+			{Name: "HDMI Cable", Category: "electronics", Quantity: 1},
+		}},
+		{Date: now.Add(-7 * 24 * time.Hour), Items: []Item{
+			{Name: "milk", Category: "dairy"},
+			{Name: "Milk 1L", Category: "dairy", Quantity: 1},
+		}},
+		{Date: now.Add(-400 * 24 * time.Hour), Items: []Item{
+			{Name: "Milk", Category: "dairy", Quantity: 5},
+		}},
+	}
+
+	histories := purchaseHistories(receipts, cfg, now.Add(-replenishmentHistory))
+	if len(histories) != 1 {
+		t.Fatalf("Expected only milk to be tracked, got %+v", histories)
+	}
+	milk := histories["milk"]
+	if milk == nil || len(milk.days) != 2 {
+		t.Fatalf("Expected two purchase days for milk, got %+v", milk)
+	}
+	if milk.days["2024-03-03"] != 2 {
+		t.Errorf("Expected same-day lines to be merged, got %+v", milk.days)
+	}
+}
+
+func TestPredictReplenishment(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	histories := map[string]*purchaseHistory{
+		// Weekly, last bought 5 days ago and not tracked: due in 2 days
+		"bread": {name: "Bread", category: "bakery", days: map[string]int{"2024-02-20": 1, "2024-02-27": 1, "2024-03-05": 1}},
+		// 10 bought over 10 days, 4 left: 4 days left
+		"eggs": {name: "Eggs", category: "dairy", days: map[string]int{"2024-02-20": 10, "2024-03-01": 12}},
+		// Ledger says 1 a day, 30 left: not due
+		"rice": {name: "Rice", category: "grocery", days: map[string]int{"2024-01-01": 1, "2024-02-01": 1}},
+		// Bought once and used up
+		"salt": {name: "Salt", category: "grocery", days: map[string]int{"2024-03-01": 1}},
+		// Bought once, nothing known: not regular
+		"jam": {name: "Jam", category: "grocery", days: map[string]int{"2024-03-01": 1}},
+	}
+	stock := map[string]stockLevel{
+		"eggs": {quantity: 4, tracked: true},
+		"rice": {quantity: 30, tracked: true},
+		"salt": {quantity: 0, tracked: true},
+	}
+	consumption := map[string]consumptionStats{
+		"rice": {amount: 10, first: now.Add(-10 * 24 * time.Hour), last: now},
+	}
+
+	due := predictReplenishment(histories, stock, consumption, now, 7*24*time.Hour)
+	if len(due) != 3 {
+		t.Fatalf("Expected salt, bread and eggs, got %+v", due)
+	}
+
+	if due[0].NormalizedName != "salt" || due[0].Basis != "depleted" || due[0].DaysLeft != 0 {
+		t.Errorf("Expected salt first as depleted, got %+v", due[0])
+	}
+	if due[1].NormalizedName != "bread" || due[1].Basis != "interval" || due[1].DaysLeft != 2 {
+		t.Errorf("Expected bread due in 2 days by interval, got %+v", due[1])
+	}
+	if due[2].NormalizedName != "eggs" || due[2].Basis != "purchases" || due[2].DaysLeft != 4 {
+		t.Errorf("Expected eggs due in 4 days by purchases, got %+v", due[2])
+	}
+	if due[2].SuggestedQty != 11 {
+		t.Errorf("Expected the average purchase of 11 eggs, got %d", due[2].SuggestedQty)
+	}
+
+	due = predictReplenishment(histories, stock, consumption, now, 40*24*time.Hour)
+	for _, r := range due {
+		if r.NormalizedName == "rice" {
+			if r.Basis != "consumption" || r.DaysLeft != 30 {
+				t.Errorf("Expected rice to run out in 30 days by consumption, got %+v", r)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected rice within 40 days, got %+v", due)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// receiptSearch indexes every receipt the backend can see
+var receiptSearch = newSearchIndex()
+
+// watchReceiptsForSearch keeps the search index in sync with the receipts
+// collection, including receipts updated by the Cloud Functions. It restarts
+// the listener after transient errors until ctx is cancelled.
+func watchReceiptsForSearch(ctx context.Context) {
+	for {
+		err := syncReceiptSearch(ctx)
+		if ctx.Err() != nil || status.Code(err) == codes.Canceled {
+			return
+		}
+		log.Printf("Receipt search listener stopped, restarting: %v", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func syncReceiptSearch(ctx context.Context) error {
+	snapshots := firestoreClient.Collection("receipts").Snapshots(ctx)
+	defer snapshots.Stop()
+
+	for {
+		snap, err := snapshots.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, change := range snap.Changes {
+			if change.Kind == firestore.DocumentRemoved {
+				receiptSearch.Remove(change.Doc.Ref.ID)
+				continue
+			}
+
+			var receipt Receipt
+			if err := change.Doc.DataTo(&receipt); err != nil {
+				log.Printf("Skipping receipt %s in search index: %v", change.Doc.Ref.ID, err)
+				continue
+			}
+			receipt.ID = change.Doc.Ref.ID
+			receiptSearch.Upsert(receipt)
+		}
+	}
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	query := r.URL.Query().Get("q")
+	if userID == "" || query == "" {
+		http.Error(w, "user_id and q are required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// Receipts shared with any of the user's households are searchable too
+	var householdIDs []string
+	iter := firestoreClient.Collection("households").Where("member_ids", "array-contains", userID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch households", http.StatusInternalServerError)
+			return
+		}
+		householdIDs = append(householdIDs, doc.Ref.ID)
+	}
+
+	hits := receiptSearch.Search(query, userID, householdIDs, limit)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query": query,
+		"count": len(hits),
+		"hits":  hits,
+	})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Search field names and their relevance boosts
+const (
+	fieldStore = "store_name"
+	fieldItem  = "item"
+	fieldTag   = "tag"
+	fieldNotes = "notes"
+)
+
+var fieldBoost = map[string]float64{
+	fieldStore: 2,
+	fieldTag:   2,
+	fieldItem:  1.5,
+	fieldNotes: 1,
+}
+
+// SearchHit is a receipt matching a search query
+type SearchHit struct {
+	ReceiptID   string            `json:"receipt_id"`
+	UserID      string            `json:"user_id"`
+	HouseholdID string            `json:"household_id,omitempty"`
+	StoreName   string            `json:"store_name"`
+	TotalAmount float64           `json:"total_amount"`
+	Date        time.Time         `json:"date"`
+	Score       float64           `json:"score"`
+	Highlights  []SearchHighlight `json:"highlights"`
+}
+
+// SearchHighlight is a matching field with the matched words wrapped in <mark>
+type SearchHighlight struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+}
+
+type searchField struct {
+	name string
+	text string
+}
+
+type searchDoc struct {
+	receipt Receipt
+	fields  []searchField
+	terms   map[string][]string // term -> fields containing it
+}
+
+// searchIndex is an in-memory inverted index over receipt store names, item
+// names, tags and notes. It is safe for concurrent use.
+type searchIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*searchDoc
+	postings map[string]map[string]bool // term -> receipt IDs
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:     make(map[string]*searchDoc),
+		postings: make(map[string]map[string]bool),
+	}
+}
+
+// Upsert adds or replaces a receipt in the index
+func (idx *searchIndex) Upsert(receipt Receipt) {
+	doc := &searchDoc{receipt: receipt, terms: make(map[string][]string)}
+	doc.fields = append(doc.fields, searchField{fieldStore, receipt.StoreName})
+	for _, tag := range receipt.Tags {
+		doc.fields = append(doc.fields, searchField{fieldTag, tag})
+	}
+	doc.fields = append(doc.fields, searchField{fieldNotes, receipt.Notes})
+	for _, item := range receipt.Items {
+		doc.fields = append(doc.fields, searchField{fieldItem, item.Name})
+		for _, tag := range item.Tags {
+			doc.fields = append(doc.fields, searchField{fieldTag, tag})
+		}
+		doc.fields = append(doc.fields, searchField{fieldNotes, item.Notes})
+	}
+	for _, field := range doc.fields {
+		for _, term := range searchTerms(field.text) {
+			doc.terms[term] = append(doc.terms[term], field.name)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(receipt.ID)
+	idx.docs[receipt.ID] = doc
+	for term := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]bool)
+		}
+		idx.postings[term][receipt.ID] = true
+	}
+}
+
+// Remove drops a receipt from the index
+func (idx *searchIndex) Remove(receiptID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(receiptID)
+}
+
+func (idx *searchIndex) removeLocked(receiptID string) {
+	doc, ok := idx.docs[receiptID]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], receiptID)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docs, receiptID)
+}
+
+// Search returns receipts visible to the user (their own, or shared with one of
+// their households) that match every query word, best matches first.
+func (idx *searchIndex) Search(query, userID string, householdIDs []string, limit int) []SearchHit {
+	queryTerms := searchTerms(query)
+	if len(queryTerms) == 0 {
+		return []SearchHit{}
+	}
+
+	households := make(map[string]bool, len(householdIDs))
+	for _, id := range householdIDs {
+		households[id] = true
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Expand each query word to the indexed terms it matches, with a weight
+	// for how closely it matches
+	scores := make(map[string]float64)
+	matched := make(map[string]map[string]bool) // receipt ID -> matched index terms
+	for i, queryTerm := range queryTerms {
+		termScores := make(map[string]float64)
+		for term, receiptIDs := range idx.postings {
+			weight := termMatch(queryTerm, term)
+			if weight == 0 {
+				continue
+			}
+			for receiptID := range receiptIDs {
+				doc := idx.docs[receiptID]
+				if doc.receipt.UserID != userID && !households[doc.receipt.HouseholdID] {
+					continue
+				}
+				boost := 0.0
+				for _, field := range doc.terms[term] {
+					if fieldBoost[field] > boost {
+						boost = fieldBoost[field]
+					}
+				}
+				if s := weight * boost; s > termScores[receiptID] {
+					termScores[receiptID] = s
+				}
+				if matched[receiptID] == nil {
+					matched[receiptID] = make(map[string]bool)
+				}
+				matched[receiptID][term] = true
+			}
+		}
+
+		// Every query word must match: keep only receipts seen for all of them
+		if i == 0 {
+			scores = termScores
+			continue
+		}
+		for receiptID := range scores {
+			if termScores[receiptID] == 0 {
+				delete(scores, receiptID)
+			} else {
+				scores[receiptID] += termScores[receiptID]
+			}
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for receiptID, score := range scores {
+		doc := idx.docs[receiptID]
+		hits = append(hits, SearchHit{
+			ReceiptID:   receiptID,
+			UserID:      doc.receipt.UserID,
+			HouseholdID: doc.receipt.HouseholdID,
+			StoreName:   doc.receipt.StoreName,
+			TotalAmount: doc.receipt.TotalAmount,
+			Date:        doc.receipt.Date,
+			Score:       score,
+			Highlights:  highlight(doc.fields, matched[receiptID]),
+		})
+	}
+
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return hits[a].Date.After(hits[b].Date)
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// termMatch scores how well an indexed term matches a query word: exact
+// matches beat prefix matches, which beat typos within a small edit distance.
+func termMatch(queryTerm, term string) float64 {
+	if queryTerm == term {
+		return 3
+	}
+	if len(queryTerm) >= 3 && strings.HasPrefix(term, queryTerm) {
+		return 2
+	}
+
+	maxEdits := 0
+	switch n := len([]rune(queryTerm)); {
+	case n >= 8:
+		maxEdits = 2
+	case n >= 4:
+		maxEdits = 1
+	}
+	if maxEdits > 0 && editDistance(queryTerm, term, maxEdits) <= maxEdits {
+		return 1
+	}
+	return 0
+}
+
+// editDistance returns the Levenshtein distance between a and b, giving up
+// early with max+1 once the distance is known to exceed max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// searchTerms splits text into lowercase words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isSeparator)
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlight returns the fields containing matched terms, HTML-escaped, with
+// each matched word wrapped in <mark></mark>
+func highlight(fields []searchField, terms map[string]bool) []SearchHighlight {
+	highlights := []SearchHighlight{}
+	for _, field := range fields {
+		var b strings.Builder
+		found := false
+		word := -1
+		runes := []rune(field.text)
+		for i := 0; i <= len(runes); i++ {
+			if i < len(runes) && !isSeparator(runes[i]) {
+				if word < 0 {
+					word = i
+				}
+				continue
+			}
+			if word >= 0 {
+				text := string(runes[word:i])
+				if terms[strings.ToLower(text)] {
+					found = true
+					b.WriteString("<mark>" + html.EscapeString(text) + "</mark>")
+				} else {
+					b.WriteString(html.EscapeString(text))
+				}
+				word = -1
+			}
+			if i < len(runes) {
+				b.WriteString(html.EscapeString(string(runes[i])))
+			}
+		}
+		if found {
+			highlights = append(highlights, SearchHighlight{Field: field.name, Text: b.String()})
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestSearchIndex() *searchIndex {
+	idx := newSearchIndex()
+	idx.Upsert(Receipt{ID: "r1", UserID: "alice", StoreName: "D-Mart", Items: []Item{
+		{Name: "Extra Virgin Olive Oil"},
+		{Name: "Basmati Rice"},
+	}})
+	idx.Upsert(Receipt{ID: "r2", UserID: "alice", StoreName: "Nature's Basket", Tags: []string{"trip-goa"}, Items: []Item{
+		{Name: "Olive Bread"},
+	}})
+	idx.Upsert(Receipt{ID: "r3", UserID: "bob", HouseholdID: "h1", StoreName: "Reliance Fresh", Items: []Item{
+		{Name: "Sunflower Oil"},
+	}})
+	return idx
+}
+
+func TestSearchMatchesAllWords(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	hits := idx.Search("olive oil", "alice", nil, 10)
+	if len(hits) != 1 || hits[0].ReceiptID != "r1" {
+		t.Fatalf("Expected only r1 to contain olive oil, got %+v", hits)
+	}
+
+	found := false
+	for _, h := range hits[0].Highlights {
+		if h.Field == fieldItem && h.Text == "Extra Virgin <mark>Olive</mark> <mark>Oil</mark>" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected highlighted item, got %+v", hits[0].Highlights)
+	}
+}
+
+func TestSearchFuzzyAndPrefix(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	if hits := idx.Search("basmatti", "alice", nil, 10); len(hits) != 1 || hits[0].ReceiptID != "r1" {
+		t.Errorf("Expected typo to match basmati, got %+v", hits)
+	}
+	if hits := idx.Search("oliv", "alice", nil, 10); len(hits) != 2 {
+		t.Errorf("Expected prefix to match both olive receipts, got %+v", hits)
+	}
+	if hits := idx.Search("trip goa", "alice", nil, 10); len(hits) != 1 || hits[0].ReceiptID != "r2" {
+		t.Errorf("Expected tag match, got %+v", hits)
+	}
+}
+
+func TestSearchRespectsAccess(t *testing.T) {
+	idx := newTestSearchIndex()
+
+	if hits := idx.Search("sunflower", "alice", nil, 10); len(hits) != 0 {
+		t.Errorf("Expected bob's receipt to be hidden, got %+v", hits)
+	}
+	if hits := idx.Search("sunflower", "alice", []string{"h1"}, 10); len(hits) != 1 {
+		t.Errorf("Expected household receipt to be visible, got %+v", hits)
+	}
+
+	idx.Remove("r1")
+	if hits := idx.Search("basmati", "alice", nil, 10); len(hits) != 0 {
+		t.Errorf("Expected removed receipt to be gone, got %+v", hits)
+	}
+}
+
+func TestHighlightEscapesHTML(t *testing.T) {
+	got := highlight([]searchField{{fieldNotes, "<b>olive</b> & oil"}}, map[string]bool{"olive": true})
+	if len(got) != 1 || !strings.Contains(got[0].Text, "&lt;b&gt;<mark>olive</mark>&lt;/b&gt;") {
+		t.Errorf("Unexpected highlight %+v", got)
+	}
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/catalog"
+)
+
+var (
+	errShoppingListNotFound = errors.New("shopping list not found")
+	errListItemNotFound     = errors.New("item not found on shopping list")
+)
+
+// ShoppingList is a list of things to buy, owned by a user and optionally
+// shared with other users or a household
+type ShoppingList struct {
+	ID          string             `json:"id" firestore:"id"`
+	Name        string             `json:"name" firestore:"name"`
+	OwnerID     string             `json:"owner_id" firestore:"owner_id"`
+	HouseholdID string             `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	SharedWith  []string           `json:"shared_with" firestore:"shared_with"`
+	MemberIDs   []string           `json:"member_ids" firestore:"member_ids"` // owner and shared_with, for array-contains queries
+	Items       []ShoppingListItem `json:"items" firestore:"items"`
+	Source      string             `json:"source" firestore:"source"` // manual, query, replenishment
+	QueryID     string             `json:"query_id,omitempty" firestore:"query_id,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" firestore:"updated_at"`
+}
+
+// ShoppingListItem is one entry on a shopping list
+type ShoppingListItem struct {
+	ID             string    `json:"id" firestore:"id"`
+	Name           string    `json:"name" firestore:"name"`
+	NormalizedName string    `json:"normalized_name" firestore:"normalized_name"`
+	Quantity       int       `json:"quantity" firestore:"quantity"`
+	Unit           string    `json:"unit" firestore:"unit"`
+	Category       string    `json:"category" firestore:"category"`
+	Note           string    `json:"note,omitempty" firestore:"note,omitempty"`
+	Checked        bool      `json:"checked" firestore:"checked"`
+	CheckedBy      string    `json:"checked_by,omitempty" firestore:"checked_by,omitempty"`
+	CheckedAt      time.Time `json:"checked_at" firestore:"checked_at"`
+	AddedBy        string    `json:"added_by" firestore:"added_by"`
+	AddedAt        time.Time `json:"added_at" firestore:"added_at"`
+}
+
+// listItemInput is the client-supplied part of a shopping list item
+type listItemInput struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	Unit     string `json:"unit"`
+	Category string `json:"category"`
+	Note     string `json:"note"`
+}
+
+// AddItem puts an item on the list. If an unchecked item with the same
+// normalized name and unit is already there, its quantity is increased
+// instead. It returns the item as stored.
+func (l *ShoppingList) AddItem(in listItemInput, userID string, now time.Time) ShoppingListItem {
+	if in.Quantity <= 0 {
+		in.Quantity = 1
+	}
+	key := catalog.NormalizeName(in.Name)
+
+	for i, item := range l.Items {
+		if !item.Checked && item.NormalizedName == key && item.Unit == in.Unit {
+			l.Items[i].Quantity += in.Quantity
+			return l.Items[i]
+		}
+	}
+
+	item := ShoppingListItem{
+		ID:             fmt.Sprintf("%d_%d", now.UnixNano(), len(l.Items)),
+		Name:           strings.TrimSpace(in.Name),
+		NormalizedName: key,
+		Quantity:       in.Quantity,
+		Unit:           in.Unit,
+		Category:       in.Category,
+		Note:           in.Note,
+		AddedBy:        userID,
+		AddedAt:        now,
+	}
+	l.Items = append(l.Items, item)
+	return item
+}
+
+// Item returns the index of the item with the given ID
+func (l *ShoppingList) Item(itemID string) (int, error) {
+	for i, item := range l.Items {
+		if item.ID == itemID {
+			return i, nil
+		}
+	}
+	return -1, errListItemNotFound
+}
+
+// SetChecked checks an item off the list, or unchecks it
+func (l *ShoppingList) SetChecked(index int, checked bool, userID string, now time.Time) {
+	item := &l.Items[index]
+	item.Checked = checked
+	if checked {
+		item.CheckedBy = userID
+		item.CheckedAt = now
+	} else {
+		item.CheckedBy = ""
+		item.CheckedAt = time.Time{}
+	}
+}
+
+// Complete reports whether the list has items and all of them are checked off
+func (l *ShoppingList) Complete() bool {
+	for _, item := range l.Items {
+		if !item.Checked {
+			return false
+		}
+	}
+	return len(l.Items) > 0
+}
+
+// Share replaces the users the list is shared with
+func (l *ShoppingList) Share(userIDs []string) {
+	seen := map[string]bool{l.OwnerID: true}
+	l.SharedWith = []string{}
+	for _, id := range userIDs {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			l.SharedWith = append(l.SharedWith, id)
+		}
+	}
+	l.MemberIDs = append([]string{l.OwnerID}, l.SharedWith...)
+}
+
+// HasMember reports whether the user owns the list or it was shared with them
+func (l *ShoppingList) HasMember(userID string) bool {
+	for _, id := range l.MemberIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func getShoppingList(ctx context.Context, listID string) (*ShoppingList, error) {
+	doc, err := firestoreClient.Collection("shopping_lists").Doc(listID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errShoppingListNotFound
+		}
+		return nil, err
+	}
+
+	var list ShoppingList
+	if err := doc.DataTo(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// authorizeShoppingList loads the list and checks the user can see it (or
+// change it, when write is set): the owner, users it is shared with, and
+// members of its household. It writes the error response and returns nil when
+// access is denied.
+func authorizeShoppingList(w http.ResponseWriter, r *http.Request, listID, userID string, write bool) *ShoppingList {
+	list, err := getShoppingList(r.Context(), listID)
+	if err == errShoppingListNotFound {
+		http.Error(w, "Shopping list not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch shopping list", http.StatusInternalServerError)
+		return nil
+	}
+
+	if list.HasMember(userID) {
+		return list
+	}
+	if list.HouseholdID == "" {
+		http.Error(w, "Shopping list not found", http.StatusNotFound)
+		return nil
+	}
+	if authorizeHousehold(w, r, list.HouseholdID, userID, write) == nil {
+		return nil
+	}
+	return list
+}
+
+// updateShoppingList applies change to the latest copy of the list inside a
+// transaction, so concurrent check-offs from different phones don't clobber
+// each other
+func updateShoppingList(ctx context.Context, listID string, change func(list *ShoppingList) error) (*ShoppingList, error) {
+	var list ShoppingList
+	ref := firestoreClient.Collection("shopping_lists").Doc(listID)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		list = ShoppingList{}
+		if err := doc.DataTo(&list); err != nil {
+			return err
+		}
+		if err := change(&list); err != nil {
+			return err
+		}
+		list.UpdatedAt = time.Now()
+		return tx.Set(ref, list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func shoppingListsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "POST":
+		createShoppingList(w, r)
+	case "GET":
+		getShoppingLists(w, r)
+	case "PUT":
+		updateShoppingListDetails(w, r)
+	case "DELETE":
+		deleteShoppingList(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createShoppingList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID      string          `json:"user_id"`
+		Name        string          `json:"name"`
+		HouseholdID string          `json:"household_id"`
+		SharedWith  []string        `json:"shared_with"`
+		Items       []listItemInput `json:"items"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || req.Name == "" {
+		http.Error(w, "user_id and name are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.HouseholdID != "" && authorizeHousehold(w, r, req.HouseholdID, req.UserID, true) == nil {
+		return
+	}
+
+	now := time.Now()
+	list := ShoppingList{
+		ID:          generateID(),
+		Name:        req.Name,
+		OwnerID:     req.UserID,
+		HouseholdID: req.HouseholdID,
+		Items:       []ShoppingListItem{},
+		Source:      "manual",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	list.Share(req.SharedWith)
+	for _, in := range req.Items {
+		if strings.TrimSpace(in.Name) == "" {
+			http.Error(w, "item name is required", http.StatusBadRequest)
+			return
+		}
+		list.AddItem(in, req.UserID, now)
+	}
+
+	_, err := firestoreClient.Collection("shopping_lists").Doc(list.ID).Set(ctx, list)
+	if err != nil {
+		http.Error(w, "Failed to save shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+func getShoppingLists(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	// A single list by ID
+	if listID := r.URL.Query().Get("id"); listID != "" {
+		list := authorizeShoppingList(w, r, listID, userID, false)
+		if list == nil {
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	// Lists shared with a household, or everything the user owns or was
+	// shared on
+	query := firestoreClient.Collection("shopping_lists").Where("member_ids", "array-contains", userID)
+	if householdID := r.URL.Query().Get("household_id"); householdID != "" {
+		if authorizeHousehold(w, r, householdID, userID, false) == nil {
+			return
+		}
+		query = firestoreClient.Collection("shopping_lists").Where("household_id", "==", householdID)
+	}
+
+	iter := query.Documents(ctx)
+	lists := []ShoppingList{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch shopping lists", http.StatusInternalServerError)
+			return
+		}
+
+		var list ShoppingList
+		if err := doc.DataTo(&list); err != nil {
+			continue
+		}
+		lists = append(lists, list)
+	}
+
+	json.NewEncoder(w).Encode(lists)
+}
+
+func updateShoppingListDetails(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := r.URL.Query().Get("id")
+	if listID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UserID     string    `json:"user_id"`
+		Name       string    `json:"name"`
+		SharedWith *[]string `json:"shared_with"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	existing := authorizeShoppingList(w, r, listID, req.UserID, true)
+	if existing == nil {
+		return
+	}
+	if req.SharedWith != nil && existing.OwnerID != req.UserID {
+		http.Error(w, "Only the owner can change who the list is shared with", http.StatusForbidden)
+		return
+	}
+
+	list, err := updateShoppingList(ctx, listID, func(list *ShoppingList) error {
+		if req.Name != "" {
+			list.Name = req.Name
+		}
+		if req.SharedWith != nil {
+			list.Share(*req.SharedWith)
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to update shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+func deleteShoppingList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := r.URL.Query().Get("id")
+	userID := r.URL.Query().Get("user_id")
+	if listID == "" || userID == "" {
+		http.Error(w, "id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	list := authorizeShoppingList(w, r, listID, userID, true)
+	if list == nil {
+		return
+	}
+	if list.OwnerID != userID {
+		http.Error(w, "Only the owner can delete a shopping list", http.StatusForbidden)
+		return
+	}
+
+	_, err := firestoreClient.Collection("shopping_lists").Doc(listID).Delete(ctx)
+	if err != nil {
+		http.Error(w, "Failed to delete shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func shoppingListItemsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "POST":
+		addShoppingListItems(w, r)
+	case "PATCH":
+		updateShoppingListItem(w, r)
+	case "DELETE":
+		removeShoppingListItem(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func addShoppingListItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := r.URL.Query().Get("list_id")
+	if listID == "" {
+		http.Error(w, "list_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UserID string          `json:"user_id"`
+		Items  []listItemInput `json:"items"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || len(req.Items) == 0 {
+		http.Error(w, "user_id and items are required", http.StatusBadRequest)
+		return
+	}
+	for _, in := range req.Items {
+		if strings.TrimSpace(in.Name) == "" {
+			http.Error(w, "item name is required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if authorizeShoppingList(w, r, listID, req.UserID, true) == nil {
+		return
+	}
+
+	list, err := updateShoppingList(ctx, listID, func(list *ShoppingList) error {
+		now := time.Now()
+		for _, in := range req.Items {
+			list.AddItem(in, req.UserID, now)
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to update shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+func updateShoppingListItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := r.URL.Query().Get("list_id")
+	itemID := r.URL.Query().Get("item_id")
+	if listID == "" || itemID == "" {
+		http.Error(w, "list_id and item_id are required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UserID   string  `json:"user_id"`
+		Checked  *bool   `json:"checked"`
+		Name     string  `json:"name"`
+		Quantity int     `json:"quantity"`
+		Unit     *string `json:"unit"`
+		Note     *string `json:"note"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if authorizeShoppingList(w, r, listID, req.UserID, true) == nil {
+		return
+	}
+
+	list, err := updateShoppingList(ctx, listID, func(list *ShoppingList) error {
+		i, err := list.Item(itemID)
+		if err != nil {
+			return err
+		}
+
+		item := &list.Items[i]
+		if req.Name != "" {
+			item.Name = req.Name
+			item.NormalizedName = catalog.NormalizeName(req.Name)
+		}
+		if req.Quantity > 0 {
+			item.Quantity = req.Quantity
+		}
+		if req.Unit != nil {
+			item.Unit = *req.Unit
+		}
+		if req.Note != nil {
+			item.Note = *req.Note
+		}
+		if req.Checked != nil {
+			list.SetChecked(i, *req.Checked, req.UserID, time.Now())
+		}
+		return nil
+	})
+	if err == errListItemNotFound {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	// Checking off the last item completes the list and its wallet passes expire
+	if req.Checked != nil && *req.Checked && list.Complete() {
+		expireShoppingListPasses(ctx, list.ID)
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+func removeShoppingListItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	listID := r.URL.Query().Get("list_id")
+	itemID := r.URL.Query().Get("item_id")
+	userID := r.URL.Query().Get("user_id")
+	if listID == "" || userID == "" {
+		http.Error(w, "list_id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	if authorizeShoppingList(w, r, listID, userID, true) == nil {
+		return
+	}
+
+	// Without item_id, clear every checked-off item
+	list, err := updateShoppingList(ctx, listID, func(list *ShoppingList) error {
+		items := list.Items[:0]
+		found := false
+		for _, item := range list.Items {
+			remove := item.ID == itemID || (itemID == "" && item.Checked)
+			if remove {
+				found = true
+				continue
+			}
+			items = append(items, item)
+		}
+		if itemID != "" && !found {
+			return errListItemNotFound
+		}
+		list.Items = items
+		return nil
+	})
+	if err == errListItemNotFound {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update shopping list", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
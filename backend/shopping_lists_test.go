@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShoppingListAddItemMerges(t *testing.T) {
+	now := time.Now()
+	list := ShoppingList{OwnerID: "alice"}
+
+	list.AddItem(listItemInput{Name: "Milk 1L", Unit: "l"}, "alice", now)
+	merged := list.AddItem(listItemInput{Name: "milk", Quantity: 2, Unit: "l"}, "bob", now)
+	if len(list.Items) != 1 || merged.Quantity != 3 {
+		t.Fatalf("Expected milk to be merged into one item of 3, got %+v", list.Items)
+	}
+
+	list.AddItem(listItemInput{Name: "Milk", Unit: "packets"}, "alice", now)
+	if len(list.Items) != 2 {
+		t.Errorf("Expected a different unit to be a separate item, got %+v", list.Items)
+	}
+
+	// Checked items are already bought, so a new request starts a new line
+	list.SetChecked(0, true, "bob", now)
+	list.AddItem(listItemInput{Name: "Milk", Unit: "l"}, "alice", now)
+	if len(list.Items) != 3 || list.Items[2].Quantity != 1 {
+		t.Errorf("Expected a new unchecked milk item, got %+v", list.Items)
+	}
+	if list.Items[0].ID == list.Items[2].ID {
+		t.Error("Expected unique item IDs")
+	}
+}
+
+func TestShoppingListCheckoff(t *testing.T) {
+	now := time.Now()
+	list := ShoppingList{OwnerID: "alice"}
+	item := list.AddItem(listItemInput{Name: "Eggs"}, "alice", now)
+
+	i, err := list.Item(item.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	list.SetChecked(i, true, "bob", now)
+	if !list.Items[i].Checked || list.Items[i].CheckedBy != "bob" {
+		t.Errorf("Expected item checked by bob, got %+v", list.Items[i])
+	}
+	list.SetChecked(i, false, "bob", now)
+	if list.Items[i].Checked || list.Items[i].CheckedBy != "" || !list.Items[i].CheckedAt.IsZero() {
+		t.Errorf("Expected item unchecked, got %+v", list.Items[i])
+	}
+
+	if _, err := list.Item("missing"); err != errListItemNotFound {
+		t.Errorf("Expected errListItemNotFound, got %v", err)
+	}
+}
+
+func TestShoppingListShare(t *testing.T) {
+	list := ShoppingList{OwnerID: "alice"}
+	list.Share([]string{"bob", "alice", " bob ", "", "carol"})
+
+	if len(list.SharedWith) != 2 || list.SharedWith[0] != "bob" || list.SharedWith[1] != "carol" {
+		t.Errorf("Unexpected shared_with %v", list.SharedWith)
+	}
+	if !list.HasMember("alice") || !list.HasMember("carol") || list.HasMember("dave") {
+		t.Errorf("Unexpected member_ids %v", list.MemberIDs)
+	}
+}
+
+func TestShoppingListComplete(t *testing.T) {
+	now := time.Now()
+	list := ShoppingList{OwnerID: "alice"}
+	if list.Complete() {
+		t.Error("Expected an empty list not to be complete")
+	}
+
+	list.AddItem(listItemInput{Name: "Milk"}, "alice", now)
+	list.AddItem(listItemInput{Name: "Bread"}, "alice", now)
+	list.SetChecked(0, true, "alice", now)
+	if list.Complete() {
+		t.Error("Expected a list with unchecked items not to be complete")
+	}
+
+	list.SetChecked(1, true, "bob", now)
+	if !list.Complete() {
+		t.Error("Expected the list to be complete once everything is checked off")
+	}
+}
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Split methods
+const (
+	MethodEven    = "even"    // total divided equally among participants
+	MethodShare   = "share"   // total divided by relative shares
+	MethodItem    = "item"    // each item charged to the people who had it
+	MethodPayment = "payment" // settle-up payment between two users
+)
+
+// Split sources
+const (
+	SourceReceipt        = "receipt"
+	SourceThirdPartyBill = "third_party_bill"
+)
+
+// Split records how a receipt or bill was divided between people
+type Split struct {
+	ID             string       `json:"id" firestore:"id"`
+	CreatedBy      string       `json:"created_by" firestore:"created_by"`
+	HouseholdID    string       `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	SourceType     string       `json:"source_type,omitempty" firestore:"source_type,omitempty"` // receipt, third_party_bill
+	SourceID       string       `json:"source_id,omitempty" firestore:"source_id,omitempty"`
+	Description    string       `json:"description" firestore:"description"`
+	Method         string       `json:"method" firestore:"method"` // even, share, item, payment
+	PaidBy         string       `json:"paid_by" firestore:"paid_by"`
+	TotalAmount    float64      `json:"total_amount" firestore:"total_amount"`
+	Shares         []SplitShare `json:"shares" firestore:"shares"`
+	ParticipantIDs []string     `json:"participant_ids" firestore:"participant_ids"` // payer and sharers, for array-contains queries
+	CreatedAt      time.Time    `json:"created_at" firestore:"created_at"`
+}
+
+// SplitShare is one person's portion of a split
+type SplitShare struct {
+	UserID string   `json:"user_id" firestore:"user_id"`
+	Amount float64  `json:"amount" firestore:"amount"`
+	Items  []string `json:"items,omitempty" firestore:"items,omitempty"`
+}
+
+// ItemAssignment charges a line item to the users who shared it
+type ItemAssignment struct {
+	ItemIndex int      `json:"item_index"`
+	UserIDs   []string `json:"user_ids"`
+}
+
+// ThirdPartyBill is the subset of a third_party_bills document used for splitting
+type ThirdPartyBill struct {
+	ID          string    `json:"id" firestore:"id"`
+	UserID      string    `json:"user_id" firestore:"user_id"`
+	Service     string    `json:"service" firestore:"service"`
+	Restaurant  string    `json:"restaurant" firestore:"restaurant"`
+	TotalAmount float64   `json:"total_amount" firestore:"total_amount"`
+	Items       []Item    `json:"items" firestore:"items"`
+	OrderDate   time.Time `json:"order_date" firestore:"order_date"`
+}
+
+// Transfer is a single payment needed to settle up
+type Transfer struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+var errInvalidSplit = errors.New("invalid split")
+
+func splitsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "POST":
+		createSplit(w, r)
+	case "GET":
+		getSplits(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createSplit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID      string             `json:"user_id"`
+		SourceType  string             `json:"source_type"`
+		SourceID    string             `json:"source_id"`
+		Method      string             `json:"method"`
+		PaidBy      string             `json:"paid_by"`
+		Users       []string           `json:"participants"`
+		Shares      map[string]float64 `json:"shares"`
+		Assignments []ItemAssignment   `json:"item_assignments"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || req.SourceType == "" || req.SourceID == "" || req.Method == "" {
+		http.Error(w, "user_id, source_type, source_id and method are required", http.StatusBadRequest)
+		return
+	}
+	if req.PaidBy == "" {
+		req.PaidBy = req.UserID
+	}
+
+	// Load the receipt or bill being split
+	description, householdID, total, items, err := loadSplitSource(ctx, req.SourceType, req.SourceID, req.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidSplit):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case status.Code(err) == codes.NotFound:
+			http.Error(w, "Split source not found", http.StatusNotFound)
+		case errors.Is(err, errNotHouseholdMember):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to fetch split source", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var shares []SplitShare
+	switch req.Method {
+	case MethodEven:
+		shares, err = splitEvenly(total, req.Users)
+	case MethodShare:
+		shares, err = splitByShare(total, req.Shares)
+	case MethodItem:
+		shares, err = splitByItem(total, items, req.Users, req.Assignments)
+	default:
+		err = fmt.Errorf("%w: method must be even, share or item", errInvalidSplit)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var household *Household
+	if householdID != "" {
+		if household, err = getHousehold(ctx, householdID); err != nil {
+			http.Error(w, "Failed to fetch household", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := checkPayer(req.PaidBy, req.UserID, household, shares); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	split := Split{
+		ID:          generateID(),
+		CreatedBy:   req.UserID,
+		HouseholdID: householdID,
+		SourceType:  req.SourceType,
+		SourceID:    req.SourceID,
+		Description: description,
+		Method:      req.Method,
+		PaidBy:      req.PaidBy,
+		TotalAmount: total,
+		Shares:      shares,
+		CreatedAt:   time.Now(),
+	}
+	split.ParticipantIDs = participantIDs(split)
+
+	_, err = firestoreClient.Collection("splits").Doc(split.ID).Set(ctx, split)
+	if err != nil {
+		http.Error(w, "Failed to save split", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(split)
+}
+
+// loadSplitSource returns the description, household, total and items of the
+// receipt or third-party bill being split, checking the user may access it.
+func loadSplitSource(ctx context.Context, sourceType, sourceID, userID string) (string, string, float64, []Item, error) {
+	switch sourceType {
+	case SourceReceipt:
+		doc, err := firestoreClient.Collection("receipts").Doc(sourceID).Get(ctx)
+		if err != nil {
+			return "", "", 0, nil, err
+		}
+		var receipt Receipt
+		if err := doc.DataTo(&receipt); err != nil {
+			return "", "", 0, nil, err
+		}
+		if err := checkSourceAccess(ctx, receipt.UserID, receipt.HouseholdID, userID); err != nil {
+			return "", "", 0, nil, err
+		}
+		return receipt.StoreName, receipt.HouseholdID, receipt.TotalAmount, receipt.Items, nil
+
+	case SourceThirdPartyBill:
+		doc, err := firestoreClient.Collection("third_party_bills").Doc(sourceID).Get(ctx)
+		if err != nil {
+			return "", "", 0, nil, err
+		}
+		var bill ThirdPartyBill
+		if err := doc.DataTo(&bill); err != nil {
+			return "", "", 0, nil, err
+		}
+		if err := checkSourceAccess(ctx, bill.UserID, "", userID); err != nil {
+			return "", "", 0, nil, err
+		}
+		return fmt.Sprintf("%s - %s", bill.Service, bill.Restaurant), "", bill.TotalAmount, bill.Items, nil
+	}
+
+	return "", "", 0, nil, fmt.Errorf("%w: source_type must be receipt or third_party_bill", errInvalidSplit)
+}
+
+func checkSourceAccess(ctx context.Context, ownerID, householdID, userID string) error {
+	if ownerID == userID {
+		return nil
+	}
+	if householdID != "" {
+		household, err := getHousehold(ctx, householdID)
+		if err != nil {
+			return err
+		}
+		if household.Role(userID) != "" {
+			return nil
+		}
+	}
+	return errNotHouseholdMember
+}
+
+func getSplits(w http.ResponseWriter, r *http.Request) {
+	splits, ok := fetchSplits(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(splits)
+}
+
+// fetchSplits loads the splits a user takes part in, or every split in a
+// household when household_id is given. It writes the error response on failure.
+func fetchSplits(w http.ResponseWriter, r *http.Request) ([]Split, bool) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	householdID := r.URL.Query().Get("household_id")
+	query := firestoreClient.Collection("splits").Where("participant_ids", "array-contains", userID)
+	if householdID != "" {
+		if authorizeHousehold(w, r, householdID, userID, false) == nil {
+			return nil, false
+		}
+		query = firestoreClient.Collection("splits").Where("household_id", "==", householdID)
+	}
+
+	iter := query.Documents(ctx)
+	var splits []Split
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch splits", http.StatusInternalServerError)
+			return nil, false
+		}
+
+		var split Split
+		if err := doc.DataTo(&split); err != nil {
+			continue
+		}
+		splits = append(splits, split)
+	}
+
+	return splits, true
+}
+
+func splitBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	splits, ok := fetchSplits(w, r)
+	if !ok {
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	net := netBalances(splits)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":     userID,
+		"net_balance": fromCents(net[userID]),
+		"balances":    pairwiseBalances(splits, userID),
+		"settle_up":   simplifyDebts(net),
+	})
+}
+
+func settleUpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	var req struct {
+		UserID      string  `json:"user_id"` // user making the payment
+		ToUserID    string  `json:"to_user_id"`
+		Amount      float64 `json:"amount"`
+		HouseholdID string  `json:"household_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" || req.ToUserID == "" || req.Amount <= 0 {
+		http.Error(w, "user_id, to_user_id and a positive amount are required", http.StatusBadRequest)
+		return
+	}
+	if req.HouseholdID != "" && authorizeHousehold(w, r, req.HouseholdID, req.UserID, false) == nil {
+		return
+	}
+
+	// A payment is a split the payer covers entirely on the recipient's behalf,
+	// which cancels out the same amount of the payer's debt.
+	split := Split{
+		ID:          generateID(),
+		CreatedBy:   req.UserID,
+		HouseholdID: req.HouseholdID,
+		Description: "Settle up",
+		Method:      MethodPayment,
+		PaidBy:      req.UserID,
+		TotalAmount: req.Amount,
+		Shares:      []SplitShare{{UserID: req.ToUserID, Amount: req.Amount}},
+		CreatedAt:   time.Now(),
+	}
+	split.ParticipantIDs = participantIDs(split)
+
+	_, err := firestoreClient.Collection("splits").Doc(split.ID).Set(ctx, split)
+	if err != nil {
+		http.Error(w, "Failed to save payment", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(split)
+}
+
+func splitEvenly(total float64, users []string) ([]SplitShare, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%w: participants are required", errInvalidSplit)
+	}
+
+	weights := make([]float64, len(users))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	amounts := allocate(toCents(total), weights)
+	shares := make([]SplitShare, len(users))
+	for i, userID := range users {
+		shares[i] = SplitShare{UserID: userID, Amount: fromCents(amounts[i])}
+	}
+	return shares, nil
+}
+
+func splitByShare(total float64, byUser map[string]float64) ([]SplitShare, error) {
+	if len(byUser) == 0 {
+		return nil, fmt.Errorf("%w: shares are required", errInvalidSplit)
+	}
+
+	users := make([]string, 0, len(byUser))
+	for userID, share := range byUser {
+		if share < 0 {
+			return nil, fmt.Errorf("%w: shares must not be negative", errInvalidSplit)
+		}
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+
+	weights := make([]float64, len(users))
+	for i, userID := range users {
+		weights[i] = byUser[userID]
+	}
+
+	amounts := allocate(toCents(total), weights)
+	shares := make([]SplitShare, len(users))
+	for i, userID := range users {
+		shares[i] = SplitShare{UserID: userID, Amount: fromCents(amounts[i])}
+	}
+	return shares, nil
+}
+
+// splitByItem charges each item to the users assigned to it. Unassigned items
+// are shared by every participant, and anything on the total not covered by
+// items (tax, delivery, tips) is spread in proportion to each person's items.
+func splitByItem(total float64, items []Item, users []string, assignments []ItemAssignment) ([]SplitShare, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: source has no items to split", errInvalidSplit)
+	}
+
+	assigned := make(map[int][]string)
+	for _, a := range assignments {
+		if a.ItemIndex < 0 || a.ItemIndex >= len(items) {
+			return nil, fmt.Errorf("%w: item_index %d out of range", errInvalidSplit, a.ItemIndex)
+		}
+		if len(a.UserIDs) == 0 {
+			return nil, fmt.Errorf("%w: item %d has no users", errInvalidSplit, a.ItemIndex)
+		}
+		assigned[a.ItemIndex] = a.UserIDs
+	}
+
+	// Keep participants in a stable order: listed participants, then anyone
+	// only mentioned in an assignment
+	var order []string
+	seen := make(map[string]bool)
+	addUser := func(userID string) {
+		if !seen[userID] {
+			seen[userID] = true
+			order = append(order, userID)
+		}
+	}
+	for _, userID := range users {
+		addUser(userID)
+	}
+	for i := range items {
+		for _, userID := range assigned[i] {
+			addUser(userID)
+		}
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("%w: participants are required", errInvalidSplit)
+	}
+
+	subtotals := make(map[string]int64)
+	itemNames := make(map[string][]string)
+	var itemsTotal int64
+	for i, item := range items {
+		cost := toCents(item.Price * float64(item.Quantity))
+		itemsTotal += cost
+
+		sharers := assigned[i]
+		if sharers == nil {
+			if len(users) == 0 {
+				return nil, fmt.Errorf("%w: item %d is unassigned and there are no participants", errInvalidSplit, i)
+			}
+			sharers = users
+		}
+
+		weights := make([]float64, len(sharers))
+		for j := range weights {
+			weights[j] = 1
+		}
+		for j, amount := range allocate(cost, weights) {
+			subtotals[sharers[j]] += amount
+			itemNames[sharers[j]] = append(itemNames[sharers[j]], item.Name)
+		}
+	}
+
+	// Spread the remainder (positive or negative) by item subtotal, or evenly
+	// when nobody had anything with a price
+	weights := make([]float64, len(order))
+	for i, userID := range order {
+		weights[i] = float64(subtotals[userID])
+	}
+	if itemsTotal == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	extra := allocate(toCents(total)-itemsTotal, weights)
+
+	shares := make([]SplitShare, len(order))
+	for i, userID := range order {
+		shares[i] = SplitShare{
+			UserID: userID,
+			Amount: fromCents(subtotals[userID] + extra[i]),
+			Items:  itemNames[userID],
+		}
+	}
+	return shares, nil
+}
+
+// allocate divides total cents in proportion to weights using the largest
+// remainder method, so the parts always add up exactly to the total.
+func allocate(total int64, weights []float64) []int64 {
+	parts := make([]int64, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	if sum == 0 {
+		weights = make([]float64, len(weights))
+		for i := range weights {
+			weights[i] = 1
+		}
+		sum = float64(len(weights))
+	}
+
+	sign := int64(1)
+	if total < 0 {
+		sign, total = -1, -total
+	}
+
+	type remainder struct {
+		index int
+		frac  float64
+	}
+	remainders := make([]remainder, len(weights))
+	var assigned int64
+	for i, w := range weights {
+		exact := float64(total) * w / sum
+		parts[i] = int64(math.Floor(exact))
+		assigned += parts[i]
+		remainders[i] = remainder{index: i, frac: exact - float64(parts[i])}
+	}
+
+	sort.SliceStable(remainders, func(a, b int) bool { return remainders[a].frac > remainders[b].frac })
+	for i := int64(0); i < total-assigned; i++ {
+		parts[remainders[i%int64(len(remainders))].index]++
+	}
+
+	for i := range parts {
+		parts[i] *= sign
+	}
+	return parts
+}
+
+// checkPayer makes sure the payer is the user creating the split or, for a
+// household receipt, one of its members, and that they are one of the sharers
+func checkPayer(paidBy, userID string, household *Household, shares []SplitShare) error {
+	if paidBy != userID && (household == nil || household.Role(paidBy) == "") {
+		return fmt.Errorf("%w: paid_by must be you or a member of the household", errInvalidSplit)
+	}
+	for _, share := range shares {
+		if share.UserID == paidBy {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: paid_by must be one of the participants", errInvalidSplit)
+}
+
+func participantIDs(split Split) []string {
+	ids := []string{split.PaidBy}
+	for _, share := range split.Shares {
+		if share.UserID != split.PaidBy {
+			ids = append(ids, share.UserID)
+		}
+	}
+	return ids
+}
+
+// netBalances returns each user's net position in cents: positive means they
+// are owed money, negative means they owe.
+func netBalances(splits []Split) map[string]int64 {
+	net := make(map[string]int64)
+	for _, split := range splits {
+		for _, share := range split.Shares {
+			if share.UserID == split.PaidBy {
+				continue
+			}
+			amount := toCents(share.Amount)
+			net[split.PaidBy] += amount
+			net[share.UserID] -= amount
+		}
+	}
+	return net
+}
+
+// pairwiseBalances returns what each other user owes userID (negative when
+// userID owes them), before any simplification.
+func pairwiseBalances(splits []Split, userID string) map[string]float64 {
+	cents := make(map[string]int64)
+	for _, split := range splits {
+		for _, share := range split.Shares {
+			if share.UserID == split.PaidBy {
+				continue
+			}
+			amount := toCents(share.Amount)
+			switch userID {
+			case split.PaidBy:
+				cents[share.UserID] += amount
+			case share.UserID:
+				cents[split.PaidBy] -= amount
+			}
+		}
+	}
+
+	balances := make(map[string]float64)
+	for other, amount := range cents {
+		if amount != 0 {
+			balances[other] = fromCents(amount)
+		}
+	}
+	return balances
+}
+
+// simplifyDebts turns net balances into a short list of transfers by repeatedly
+// settling the largest debtor against the largest creditor.
+func simplifyDebts(net map[string]int64) []Transfer {
+	type balance struct {
+		userID string
+		amount int64
+	}
+	var creditors, debtors []balance
+	for userID, amount := range net {
+		if amount > 0 {
+			creditors = append(creditors, balance{userID, amount})
+		} else if amount < 0 {
+			debtors = append(debtors, balance{userID, -amount})
+		}
+	}
+	byAmount := func(list []balance) func(a, b int) bool {
+		return func(a, b int) bool {
+			if list[a].amount != list[b].amount {
+				return list[a].amount > list[b].amount
+			}
+			return list[a].userID < list[b].userID
+		}
+	}
+	sort.Slice(creditors, byAmount(creditors))
+	sort.Slice(debtors, byAmount(debtors))
+
+	transfers := []Transfer{}
+	for len(creditors) > 0 && len(debtors) > 0 {
+		amount := creditors[0].amount
+		if debtors[0].amount < amount {
+			amount = debtors[0].amount
+		}
+		transfers = append(transfers, Transfer{From: debtors[0].userID, To: creditors[0].userID, Amount: fromCents(amount)})
+
+		creditors[0].amount -= amount
+		debtors[0].amount -= amount
+		if creditors[0].amount == 0 {
+			creditors = creditors[1:]
+		}
+		if debtors[0].amount == 0 {
+			debtors = debtors[1:]
+		}
+		sort.Slice(creditors, byAmount(creditors))
+		sort.Slice(debtors, byAmount(debtors))
+	}
+	return transfers
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func sumShares(shares []SplitShare) int64 {
+	var total int64
+	for _, share := range shares {
+		total += toCents(share.Amount)
+	}
+	return total
+}
+
+func TestSplitEvenlyAddsUp(t *testing.T) {
+	shares, err := splitEvenly(100, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sumShares(shares); got != 10000 {
+		t.Errorf("Expected shares to add up to 10000 cents, got %d", got)
+	}
+	if shares[0].Amount != 33.34 || shares[2].Amount != 33.33 {
+		t.Errorf("Unexpected even split %+v", shares)
+	}
+}
+
+func TestSplitByShare(t *testing.T) {
+	shares, err := splitByShare(90, map[string]float64{"a": 2, "b": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shares[0].UserID != "a" || shares[0].Amount != 60 || shares[1].Amount != 30 {
+		t.Errorf("Unexpected share split %+v", shares)
+	}
+}
+
+func TestSplitByItemSpreadsExtrasProportionally(t *testing.T) {
+	items := []Item{
+		{Name: "Pizza", Price: 30, Quantity: 1},
+		{Name: "Coke", Price: 5, Quantity: 2},
+	}
+	assignments := []ItemAssignment{{ItemIndex: 0, UserIDs: []string{"a"}}}
+
+	// Coke is unassigned so a and b share it; the 8.00 of fees follows item spend
+	shares, err := splitByItem(48, items, []string{"a", "b"}, assignments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sumShares(shares); got != 4800 {
+		t.Errorf("Expected shares to add up to 4800 cents, got %d", got)
+	}
+	if shares[0].Amount != 42 || shares[1].Amount != 6 {
+		t.Errorf("Unexpected item split %+v", shares)
+	}
+
+	if _, err := splitByItem(48, items, nil, []ItemAssignment{{ItemIndex: 5, UserIDs: []string{"a"}}}); err == nil {
+		t.Error("Expected out of range item to be rejected")
+	}
+}
+
+func TestCheckPayer(t *testing.T) {
+	household := &Household{Members: []HouseholdMember{{UserID: "a", Role: RoleOwner}, {UserID: "b", Role: RoleMember}}}
+	shares := []SplitShare{{UserID: "a", Amount: 10}, {UserID: "b", Amount: 10}}
+
+	if err := checkPayer("a", "a", nil, shares); err != nil {
+		t.Errorf("Expected the caller to pay, got %v", err)
+	}
+	if err := checkPayer("b", "a", household, shares); err != nil {
+		t.Errorf("Expected a household member to pay, got %v", err)
+	}
+	if err := checkPayer("b", "a", nil, shares); !errors.Is(err, errInvalidSplit) {
+		t.Errorf("Expected another user outside a household to be rejected, got %v", err)
+	}
+	if err := checkPayer("c", "a", household, append(shares, SplitShare{UserID: "c"})); !errors.Is(err, errInvalidSplit) {
+		t.Errorf("Expected a non-member to be rejected, got %v", err)
+	}
+	if err := checkPayer("a", "a", household, shares[1:]); !errors.Is(err, errInvalidSplit) {
+		t.Errorf("Expected a payer outside the participants to be rejected, got %v", err)
+	}
+}
+
+func TestBalancesAndSimplification(t *testing.T) {
+	splits := []Split{
+		{PaidBy: "a", Shares: []SplitShare{{UserID: "a", Amount: 10}, {UserID: "b", Amount: 10}, {UserID: "c", Amount: 10}}},
+		{PaidBy: "b", Shares: []SplitShare{{UserID: "c", Amount: 10}}},
+		{PaidBy: "c", Method: MethodPayment, Shares: []SplitShare{{UserID: "a", Amount: 5}}},
+	}
+
+	net := netBalances(splits)
+	if net["a"] != 1500 || net["b"] != 0 || net["c"] != -1500 {
+		t.Errorf("Unexpected net balances %v", net)
+	}
+
+	transfers := simplifyDebts(net)
+	if len(transfers) != 1 || transfers[0] != (Transfer{From: "c", To: "a", Amount: 15}) {
+		t.Errorf("Expected a single c->a transfer of 15, got %+v", transfers)
+	}
+
+	pairs := pairwiseBalances(splits, "b")
+	if pairs["a"] != -10 || pairs["c"] != 10 {
+		t.Errorf("Unexpected pairwise balances for b: %v", pairs)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatusDepleted marks a stock item that has been used up
+const StatusDepleted = "depleted"
+
+// Consumption reasons
+const (
+	ReasonEaten     = "eaten"
+	ReasonWasted    = "wasted"
+	ReasonGivenAway = "given_away"
+)
+
+var (
+	errStockItemNotFound = errors.New("stock item not found")
+	errNotEnoughStock    = errors.New("amount exceeds the remaining quantity")
+)
+
+// StockConsumption is a ledger entry recording that part of a stock item was used
+type StockConsumption struct {
+	ID             string    `json:"id" firestore:"id"`
+	ItemID         string    `json:"item_id" firestore:"item_id"`
+	UserID         string    `json:"user_id" firestore:"user_id"` // owner of the stock item, so the ledger follows the item's scope
+	RecordedBy     string    `json:"recorded_by" firestore:"recorded_by"`
+	HouseholdID    string    `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	Name           string    `json:"name" firestore:"name"`
+	NormalizedName string    `json:"normalized_name" firestore:"normalized_name"`
+	Category       string    `json:"category" firestore:"category"`
+	Amount         int       `json:"amount" firestore:"amount"`
+	Reason         string    `json:"reason" firestore:"reason"` // eaten, wasted, given_away
+	Remaining      int       `json:"remaining" firestore:"remaining"`
+	CreatedAt      time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// Consume takes amount off the item's quantity, marking it depleted at zero
+func (item *StockItem) Consume(amount int) error {
+	if amount > item.Quantity {
+		return errNotEnoughStock
+	}
+	item.Quantity -= amount
+	if item.Quantity == 0 {
+		item.Status = StatusDepleted
+	}
+	return nil
+}
+
+func isValidReason(reason string) bool {
+	return reason == ReasonEaten || reason == ReasonWasted || reason == ReasonGivenAway
+}
+
+// stockItemRoutesHandler serves the per-item routes under /stock-items/{id}/
+func stockItemRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/stock-items/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	itemID := parts[0]
+
+	switch {
+	case parts[1] == "consume" && r.Method == "POST":
+		consumeStockItem(w, r, itemID)
+	case parts[1] == "consumption" && r.Method == "GET":
+		getStockConsumption(w, r, itemID)
+	case parts[1] == "consume" || parts[1] == "consumption":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorizeStockItem checks that the user owns the item or belongs to its
+// household, writing the error response and returning false otherwise
+func authorizeStockItem(w http.ResponseWriter, r *http.Request, item StockItem, userID string, write bool) bool {
+	if item.UserID == userID {
+		return true
+	}
+	if item.HouseholdID == "" {
+		http.Error(w, "Stock item not found", http.StatusNotFound)
+		return false
+	}
+	return authorizeHousehold(w, r, item.HouseholdID, userID, write) != nil
+}
+
+func getStockItem(ctx context.Context, itemID string) (StockItem, error) {
+	var item StockItem
+	doc, err := firestoreClient.Collection("stock_items").Doc(itemID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return item, errStockItemNotFound
+		}
+		return item, err
+	}
+	err = doc.DataTo(&item)
+	return item, err
+}
+
+func consumeStockItem(w http.ResponseWriter, r *http.Request, itemID string) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID string `json:"user_id"`
+		Amount int    `json:"amount"`
+		Reason string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "amount must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = ReasonEaten
+	}
+	if !isValidReason(req.Reason) {
+		http.Error(w, "reason must be eaten, wasted or given_away", http.StatusBadRequest)
+		return
+	}
+
+	item, err := getStockItem(ctx, itemID)
+	if err == errStockItemNotFound {
+		http.Error(w, "Stock item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch stock item", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeStockItem(w, r, item, req.UserID, true) {
+		return
+	}
+
+	// Decrement inside a transaction so concurrent consumers can't overdraw
+	var entry StockConsumption
+	itemRef := firestoreClient.Collection("stock_items").Doc(itemID)
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(itemRef)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&item); err != nil {
+			return err
+		}
+		if err := item.Consume(req.Amount); err != nil {
+			return err
+		}
+		item.UpdatedAt = time.Now()
+
+		entry = StockConsumption{
+			ID:             generateID(),
+			ItemID:         item.ID,
+			UserID:         item.UserID,
+			RecordedBy:     req.UserID,
+			HouseholdID:    item.HouseholdID,
+			Name:           item.Name,
+			NormalizedName: item.NormalizedName,
+			Category:       item.Category,
+			Amount:         req.Amount,
+			Reason:         req.Reason,
+			Remaining:      item.Quantity,
+			CreatedAt:      item.UpdatedAt,
+		}
+
+		if err := tx.Set(itemRef, item); err != nil {
+			return err
+		}
+		return tx.Create(firestoreClient.Collection("stock_consumption").Doc(entry.ID), entry)
+	})
+	if err == errNotEnoughStock {
+		http.Error(w, fmt.Sprintf("Only %d left", item.Quantity), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to record consumption", http.StatusInternalServerError)
+		return
+	}
+
+	// Publish event to Pub/Sub
+	topic := pubsubClient.Topic("stock-management")
+	msg := &pubsub.Message{
+		Data: []byte(fmt.Sprintf(`{"item_id": "%s", "user_id": "%s", "action": "consumed", "status": "%s"}`, item.ID, item.UserID, item.Status)),
+	}
+	topic.Publish(ctx, msg)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"item":        item,
+		"consumption": entry,
+	})
+}
+
+func getStockConsumption(w http.ResponseWriter, r *http.Request, itemID string) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	item, err := getStockItem(ctx, itemID)
+	if err == errStockItemNotFound {
+		http.Error(w, "Stock item not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch stock item", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeStockItem(w, r, item, userID, false) {
+		return
+	}
+
+	iter := firestoreClient.Collection("stock_consumption").Where("item_id", "==", itemID).
+		OrderBy("created_at", firestore.Desc).Documents(ctx)
+	entries := []StockConsumption{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch consumption", http.StatusInternalServerError)
+			return
+		}
+
+		var entry StockConsumption
+		if err := doc.DataTo(&entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestConsumeStockItem(t *testing.T) {
+	item := StockItem{Quantity: 3, Status: "fresh"}
+
+	if err := item.Consume(2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if item.Quantity != 1 || item.Status != "fresh" {
+		t.Errorf("Expected 1 fresh item left, got %d %s", item.Quantity, item.Status)
+	}
+
+	if err := item.Consume(2); err != errNotEnoughStock {
+		t.Errorf("Expected errNotEnoughStock, got %v", err)
+	}
+	if item.Quantity != 1 {
+		t.Errorf("Expected quantity unchanged after failed consume, got %d", item.Quantity)
+	}
+
+	if err := item.Consume(1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if item.Quantity != 0 || item.Status != StatusDepleted {
+		t.Errorf("Expected item to be depleted, got %d %s", item.Quantity, item.Status)
+	}
+}
+
+func TestIsValidReason(t *testing.T) {
+	for _, reason := range []string{ReasonEaten, ReasonWasted, ReasonGivenAway} {
+		if !isValidReason(reason) {
+			t.Errorf("Expected %s to be valid", reason)
+		}
+	}
+	if isValidReason("lost") {
+		t.Error("Expected lost to be invalid")
+	}
+}
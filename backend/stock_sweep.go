@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/config"
+	"raseed-shared/webhooks"
+)
+
+// statusChange is a stock item whose status moved on since it was last saved
+type statusChange struct {
+	Item StockItem
+	From string
+	To   string
+}
+
+// StockDigest summarizes a user's expiring and expired items for the day
+type StockDigest struct {
+	UserID       string        `json:"user_id"`
+	Date         string        `json:"date"`
+	ExpiringSoon []DigestEntry `json:"expiring_soon"`
+	Expired      []DigestEntry `json:"expired"`
+}
+
+// DigestEntry is one item listed in a stock digest
+type DigestEntry struct {
+	ItemID      string    `json:"item_id"`
+	Name        string    `json:"name"`
+	HouseholdID string    `json:"household_id,omitempty"`
+	ExpiryDate  time.Time `json:"expiry_date"`
+}
+
+// stockSweepHandler recomputes every stock item's status and sends each
+// affected user one digest notification for the day. It is triggered daily by
+// Cloud Scheduler and can be run locally with curl.
+func stockSweepHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+
+	items, err := fetchSweepableItems(ctx)
+	if err != nil {
+		log.Printf("Stock sweep failed to fetch items: %v", err)
+		http.Error(w, "Failed to fetch stock items", http.StatusInternalServerError)
+		return
+	}
+
+	changes := sweepStatuses(items, runtimeConfig.Get(ctx), now)
+
+	// Let owners' webhooks know which items are about to expire
+	for _, change := range changes {
+		if change.To != "expiring_soon" {
+			continue
+		}
+		item := change.Item
+		event := webhooks.StockExpiringEvent(webhooks.ExpiringItem{
+			ID:          item.ID,
+			UserID:      item.UserID,
+			HouseholdID: item.HouseholdID,
+			Name:        item.Name,
+			Category:    item.Category,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			ExpiryDate:  item.ExpiryDate,
+		})
+		if err := webhooks.Publish(ctx, pubsubClient.Topic(webhooks.Topic), event); err != nil {
+			log.Printf("Failed to publish stock.expiring for %s: %v", item.ID, err)
+		}
+	}
+
+	members, err := householdMembersFor(ctx, changes)
+	if err != nil {
+		log.Printf("Stock sweep failed to fetch households: %v", err)
+		http.Error(w, "Failed to fetch households", http.StatusInternalServerError)
+		return
+	}
+
+	// Digests go out before the statuses are saved: if one fails, the next
+	// sweep finds the same changes and sends it, while the day's markers keep
+	// the others from going out twice
+	sent, failed := 0, 0
+	for _, digest := range buildDigests(changes, members, now) {
+		ok, err := sendStockDigest(ctx, digest)
+		if err != nil {
+			log.Printf("Failed to send stock digest to %s: %v", digest.UserID, err)
+			failed++
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	if failed > 0 {
+		http.Error(w, "Failed to send stock digests", http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveStatusChanges(ctx, changes, now); err != nil {
+		log.Printf("Stock sweep failed to save statuses: %v", err)
+		http.Error(w, "Failed to update stock items", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Stock sweep checked %d items, %d changed status, %d digests sent", len(items), len(changes), sent)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"checked":      len(items),
+		"changed":      len(changes),
+		"digests_sent": sent,
+	})
+}
+
+// fetchSweepableItems loads the items whose status can still change on its own
+func fetchSweepableItems(ctx context.Context) ([]StockItem, error) {
+	iter := firestoreClient.Collection("stock_items").Where("status", "in", []string{"fresh", "expiring_soon"}).Documents(ctx)
+	var items []StockItem
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var item StockItem
+		if err := doc.DataTo(&item); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// sweepStatuses returns the items whose status is out of date. Items without
+// an expiry date and used-up items are left alone.
+func sweepStatuses(items []StockItem, cfg config.Config, now time.Time) []statusChange {
+	var changes []statusChange
+	for _, item := range items {
+		if item.ExpiryDate.IsZero() || item.Status == StatusDepleted {
+			continue
+		}
+		status := cfg.ExpiryStatus(item.ExpiryDate, now)
+		if status == item.Status {
+			continue
+		}
+		changes = append(changes, statusChange{Item: item, From: item.Status, To: status})
+	}
+	return changes
+}
+
+func saveStatusChanges(ctx context.Context, changes []statusChange, now time.Time) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	writer := firestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(changes))
+	for _, change := range changes {
+		job, err := writer.Update(firestoreClient.Collection("stock_items").Doc(change.Item.ID), []firestore.Update{
+			{Path: "status", Value: change.To},
+			{Path: "updated_at", Value: now},
+		})
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	writer.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// householdMembersFor maps each household with changed items to its members
+func householdMembersFor(ctx context.Context, changes []statusChange) (map[string][]string, error) {
+	members := make(map[string][]string)
+	for _, change := range changes {
+		householdID := change.Item.HouseholdID
+		if householdID == "" {
+			continue
+		}
+		if _, ok := members[householdID]; ok {
+			continue
+		}
+
+		household, err := getHousehold(ctx, householdID)
+		if err == errHouseholdNotFound {
+			members[householdID] = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		members[householdID] = household.MemberIDs
+	}
+	return members, nil
+}
+
+// buildDigests groups status changes into one digest per user. Household items
+// are reported to every member; personal items to their owner.
+func buildDigests(changes []statusChange, householdMembers map[string][]string, now time.Time) []StockDigest {
+	digests := make(map[string]*StockDigest)
+	for _, change := range changes {
+		recipients := []string{change.Item.UserID}
+		if change.Item.HouseholdID != "" && len(householdMembers[change.Item.HouseholdID]) > 0 {
+			recipients = householdMembers[change.Item.HouseholdID]
+		}
+
+		entry := DigestEntry{
+			ItemID:      change.Item.ID,
+			Name:        change.Item.Name,
+			HouseholdID: change.Item.HouseholdID,
+			ExpiryDate:  change.Item.ExpiryDate,
+		}
+		for _, userID := range recipients {
+			digest := digests[userID]
+			if digest == nil {
+				digest = &StockDigest{UserID: userID, Date: now.Format("2006-01-02")}
+				digests[userID] = digest
+			}
+			if change.To == "expired" {
+				digest.Expired = append(digest.Expired, entry)
+			} else {
+				digest.ExpiringSoon = append(digest.ExpiringSoon, entry)
+			}
+		}
+	}
+
+	result := make([]StockDigest, 0, len(digests))
+	for _, digest := range digests {
+		result = append(result, *digest)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result
+}
+
+// Message returns the digest's notification text
+func (d StockDigest) Message() string {
+	var parts []string
+	if n := len(d.ExpiringSoon); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d expiring soon (%s)", n, digestNames(d.ExpiringSoon)))
+	}
+	if n := len(d.Expired); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d expired (%s)", n, digestNames(d.Expired)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func digestNames(entries []DigestEntry) string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// sendStockDigest publishes the digest once per user and day. A marker
+// document in stock_digests makes repeated sweeps on the same day a no-op.
+func sendStockDigest(ctx context.Context, digest StockDigest) (bool, error) {
+	markerID := fmt.Sprintf("%s_%s", digest.UserID, digest.Date)
+	_, err := firestoreClient.Collection("stock_digests").Doc(markerID).Create(ctx, map[string]interface{}{
+		"user_id":    digest.UserID,
+		"date":       digest.Date,
+		"created_at": time.Now(),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	notification := map[string]interface{}{
+		"id":      "stock_digest_" + markerID,
+		"user_id": digest.UserID,
+		"type":    "stock_digest",
+		"title":   "Pantry Check",
+		"message": digest.Message(),
+		"data":    digest,
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return false, err
+	}
+
+	topic := pubsubClient.Topic("notification-events")
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx); err != nil {
+		// Let the next sweep retry
+		firestoreClient.Collection("stock_digests").Doc(markerID).Delete(ctx)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"raseed-shared/config"
+)
+
+func TestSweepStatuses(t *testing.T) {
+	cfg := config.Defaults()
+	now := time.Date(2024, 3, 10, 6, 0, 0, 0, time.UTC)
+	items := []StockItem{
+		{ID: "milk", Status: "fresh", ExpiryDate: now.Add(2 * 24 * time.Hour)},
+		{ID: "bread", Status: "expiring_soon", ExpiryDate: now.Add(-time.Hour)},
+		{ID: "rice", Status: "fresh", ExpiryDate: now.Add(90 * 24 * time.Hour)},
+		{ID: "salt", Status: "fresh"},
+	}
+
+	changes := sweepStatuses(items, cfg, now)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", changes)
+	}
+	if changes[0].Item.ID != "milk" || changes[0].To != "expiring_soon" {
+		t.Errorf("Expected milk to be expiring soon, got %+v", changes[0])
+	}
+	if changes[1].Item.ID != "bread" || changes[1].From != "expiring_soon" || changes[1].To != "expired" {
+		t.Errorf("Expected bread to expire, got %+v", changes[1])
+	}
+}
+
+func TestBuildDigests(t *testing.T) {
+	now := time.Date(2024, 3, 10, 6, 0, 0, 0, time.UTC)
+	changes := []statusChange{
+		{Item: StockItem{ID: "1", UserID: "alice", Name: "Milk"}, To: "expiring_soon"},
+		{Item: StockItem{ID: "2", UserID: "alice", Name: "Bread"}, To: "expired"},
+		{Item: StockItem{ID: "3", UserID: "bob", HouseholdID: "h1", Name: "Eggs"}, To: "expiring_soon"},
+	}
+	members := map[string][]string{"h1": {"alice", "bob"}}
+
+	digests := buildDigests(changes, members, now)
+	if len(digests) != 2 {
+		t.Fatalf("Expected one digest per user, got %+v", digests)
+	}
+
+	alice := digests[0]
+	if alice.UserID != "alice" || alice.Date != "2024-03-10" {
+		t.Errorf("Unexpected digest %+v", alice)
+	}
+	if want := "2 expiring soon (Milk, Eggs); 1 expired (Bread)"; alice.Message() != want {
+		t.Errorf("Message() = %q, want %q", alice.Message(), want)
+	}
+
+	bob := digests[1]
+	if bob.UserID != "bob" || len(bob.ExpiringSoon) != 1 || len(bob.Expired) != 0 {
+		t.Errorf("Expected bob to get only the household item, got %+v", bob)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+	w := httptest.NewRecorder()
+	if requireAdmin(w, httptest.NewRequest("POST", "/tasks/stock-sweep", nil)) || w.Code != http.StatusForbidden {
+		t.Errorf("Expected admin endpoints to be disabled without a token, got %d", w.Code)
+	}
+
+	t.Setenv("ADMIN_TOKEN", "secret")
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/tasks/stock-sweep", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	if requireAdmin(w, r) || w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected wrong token to be rejected, got %d", w.Code)
+	}
+
+	r.Header.Set("Authorization", "Bearer secret")
+	if !requireAdmin(httptest.NewRecorder(), r) {
+		t.Error("Expected correct token to be accepted")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// annotationUpdate carries user-editable tags, notes and custom fields. Nil
+// fields are left unchanged; empty values clear them.
+type annotationUpdate struct {
+	Tags         *[]string          `json:"tags"`
+	Notes        *string            `json:"notes"`
+	CustomFields *map[string]string `json:"custom_fields"`
+}
+
+func updateReceiptAnnotations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	receiptID := r.URL.Query().Get("id")
+	if receiptID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UserID string `json:"user_id"`
+		annotationUpdate
+		Items []struct {
+			Index int `json:"index"`
+			annotationUpdate
+		} `json:"items"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := firestoreClient.Collection("receipts").Doc(receiptID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "Receipt not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch receipt", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var receipt Receipt
+	if err := doc.DataTo(&receipt); err != nil {
+		http.Error(w, "Failed to parse receipt", http.StatusInternalServerError)
+		return
+	}
+
+	// Owners can always annotate; household members with write access too
+	if receipt.UserID != req.UserID {
+		if receipt.HouseholdID == "" {
+			http.Error(w, "Receipt not found", http.StatusNotFound)
+			return
+		}
+		if authorizeHousehold(w, r, receipt.HouseholdID, req.UserID, true) == nil {
+			return
+		}
+	}
+
+	req.annotationUpdate.apply(&receipt.Tags, &receipt.Notes, &receipt.CustomFields)
+	for _, itemReq := range req.Items {
+		if itemReq.Index < 0 || itemReq.Index >= len(receipt.Items) {
+			http.Error(w, "Item index out of range", http.StatusBadRequest)
+			return
+		}
+		item := &receipt.Items[itemReq.Index]
+		itemReq.annotationUpdate.apply(&item.Tags, &item.Notes, &item.CustomFields)
+	}
+	receipt.UpdatedAt = time.Now()
+
+	_, err = firestoreClient.Collection("receipts").Doc(receiptID).Set(ctx, receipt)
+	if err != nil {
+		http.Error(w, "Failed to update receipt", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(receipt)
+}
+
+func (u annotationUpdate) apply(tags *[]string, notes *string, fields *map[string]string) {
+	if u.Tags != nil {
+		*tags = normalizeTags(*u.Tags)
+	}
+	if u.Notes != nil {
+		*notes = strings.TrimSpace(*u.Notes)
+	}
+	if u.CustomFields != nil {
+		cleaned := make(map[string]string, len(*u.CustomFields))
+		for key, value := range *u.CustomFields {
+			if key = strings.TrimSpace(key); key != "" {
+				cleaned[key] = value
+			}
+		}
+		*fields = cleaned
+	}
+}
+
+// normalizeTags lowercases tags, joins words with dashes and drops duplicates,
+// so "Trip Goa" and "trip-goa" are the same tag.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// receiptHasTag reports whether the receipt or any of its items carries the tag
+func receiptHasTag(receipt Receipt, tag string) bool {
+	if hasTag(receipt.Tags, tag) {
+		return true
+	}
+	for _, item := range receipt.Items {
+		if hasTag(item.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterReceiptsByTag keeps the receipts carrying the tag anywhere
+func filterReceiptsByTag(receipts []Receipt, tag string) []Receipt {
+	var filtered []Receipt
+	for _, receipt := range receipts {
+		if receiptHasTag(receipt, tag) {
+			filtered = append(filtered, receipt)
+		}
+	}
+	return filtered
+}
+
+// taggedPortions narrows receipts down to the spending covered by the tag. A
+// tagged receipt counts in full; otherwise only its tagged items count.
+func taggedPortions(receipts []Receipt, tag string) []Receipt {
+	var portions []Receipt
+	for _, receipt := range receipts {
+		if hasTag(receipt.Tags, tag) {
+			portions = append(portions, receipt)
+			continue
+		}
+
+		var items []Item
+		total := 0.0
+		for _, item := range receipt.Items {
+			if hasTag(item.Tags, tag) {
+				items = append(items, item)
+				total += item.Price * float64(item.Quantity)
+			}
+		}
+		if len(items) > 0 {
+			portion := receipt
+			portion.Items = items
+			portion.TotalAmount = total
+			portion.TaxAmount = 0
+			portions = append(portions, portion)
+		}
+	}
+	return portions
+}
+
+// tagSpending totals spending per tag across receipts and items
+func tagSpending(receipts []Receipt) map[string]float64 {
+	tags := make(map[string]bool)
+	for _, receipt := range receipts {
+		for _, tag := range receipt.Tags {
+			tags[tag] = true
+		}
+		for _, item := range receipt.Items {
+			for _, tag := range item.Tags {
+				tags[tag] = true
+			}
+		}
+	}
+
+	spending := make(map[string]float64, len(tags))
+	for tag := range tags {
+		for _, portion := range taggedPortions(receipts, tag) {
+			spending[tag] += portion.TotalAmount
+		}
+	}
+	return spending
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{"Trip Goa", "trip-goa", " Business ", ""})
+	want := []string{"trip-goa", "business"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeTags = %v, want %v", got, want)
+	}
+}
+
+func TestTaggedPortions(t *testing.T) {
+	receipts := []Receipt{
+		{ID: "r1", TotalAmount: 100, Tags: []string{"trip-goa"}},
+		{ID: "r2", TotalAmount: 50, Items: []Item{
+			{Name: "Sunscreen", Price: 10, Quantity: 2, Tags: []string{"trip-goa"}},
+			{Name: "Milk", Price: 30, Quantity: 1},
+		}},
+		{ID: "r3", TotalAmount: 20},
+	}
+
+	if got := len(filterReceiptsByTag(receipts, "trip-goa")); got != 2 {
+		t.Errorf("Expected 2 tagged receipts, got %d", got)
+	}
+
+	portions := taggedPortions(receipts, "trip-goa")
+	if got := summarizeSpending(portions)["total_spent"]; got != 120.0 {
+		t.Errorf("Expected trip-goa spending of 120, got %v", got)
+	}
+	if len(portions[1].Items) != 1 {
+		t.Errorf("Expected only the tagged item to remain, got %+v", portions[1].Items)
+	}
+
+	if got := tagSpending(receipts)["trip-goa"]; got != 120 {
+		t.Errorf("Expected tag spending of 120, got %v", got)
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// untypedTax is the tax type for tax the receipt doesn't break down
+const untypedTax = "OTHER"
+
+// TaxSettings are a user's choices for tax reports
+type TaxSettings struct {
+	UserID               string    `json:"user_id" firestore:"user_id"`
+	DeductibleCategories []string  `json:"deductible_categories" firestore:"deductible_categories"`
+	BusinessCategories   []string  `json:"business_categories" firestore:"business_categories"`
+	FiscalYearStart      int       `json:"fiscal_year_start" firestore:"fiscal_year_start"` // month the tax year starts, 4 for April
+	UpdatedAt            time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// TaxTotal is spending and the tax paid on it
+type TaxTotal struct {
+	Spent float64 `json:"spent"`
+	Tax   float64 `json:"tax"`
+}
+
+// TaxPeriod totals one month or quarter of a tax report
+type TaxPeriod struct {
+	Period    string             `json:"period"` // 2024-04, or 2024-25-Q1 for the tax year's first quarter
+	Spent     float64            `json:"spent"`
+	Tax       float64            `json:"tax"`
+	TaxByType map[string]float64 `json:"tax_by_type"`
+}
+
+// TaxCategory totals one item category of a tax report
+type TaxCategory struct {
+	Category   string  `json:"category"`
+	Spent      float64 `json:"spent"`
+	Tax        float64 `json:"tax"`
+	Deductible bool    `json:"deductible"`
+	Business   bool    `json:"business"`
+}
+
+// TaxReport summarizes tax paid over a tax year
+type TaxReport struct {
+	Year         string             `json:"year"`
+	From         time.Time          `json:"from"`
+	To           time.Time          `json:"to"`
+	ReceiptCount int                `json:"receipt_count"`
+	TotalSpent   float64            `json:"total_spent"`
+	TotalTax     float64            `json:"total_tax"`
+	TaxByType    map[string]float64 `json:"tax_by_type"`
+	Periods      []TaxPeriod        `json:"periods"`
+	Categories   []TaxCategory      `json:"categories"`
+	Deductible   TaxTotal           `json:"deductible"`
+	Business     TaxTotal           `json:"business"`
+}
+
+// taxYear returns the bounds and label of the tax year starting in year
+func taxYear(year, startMonth int) (from, to time.Time, label string) {
+	from = time.Date(year, time.Month(startMonth), 1, 0, 0, 0, 0, time.UTC)
+	to = from.AddDate(1, 0, 0)
+	label = strconv.Itoa(year)
+	if startMonth != 1 {
+		label = fmt.Sprintf("%d-%02d", year, (year+1)%100)
+	}
+	return from, to, label
+}
+
+// currentTaxYear is the year the tax year containing now started in
+func currentTaxYear(now time.Time, startMonth int) int {
+	if int(now.Month()) < startMonth {
+		return now.Year() - 1
+	}
+	return now.Year()
+}
+
+// taxQuarter labels the quarter of the tax year date falls in, counting from
+// the year's first month, e.g. 2024-25-Q4 for February 2025 when the tax year
+// starts in April
+func taxQuarter(date time.Time, startMonth int) string {
+	if startMonth < 1 || startMonth > 12 {
+		startMonth = 1
+	}
+	_, _, label := taxYear(currentTaxYear(date, startMonth), startMonth)
+	month := (int(date.Month()) - startMonth + 12) % 12
+	return fmt.Sprintf("%s-Q%d", label, month/3+1)
+}
+
+// receiptTaxes splits a receipt's tax by type. Tax the breakdown doesn't
+// account for is reported as OTHER.
+func receiptTaxes(receipt Receipt) map[string]float64 {
+	taxes := make(map[string]float64)
+	itemized := 0.0
+	for taxType, amount := range receipt.TaxBreakdown {
+		taxType = strings.ToUpper(strings.TrimSpace(taxType))
+		if taxType == "" || amount == 0 {
+			continue
+		}
+		taxes[taxType] += amount
+		itemized += amount
+	}
+	if rest := receipt.TaxAmount - itemized; rest > 0.005 {
+		taxes[untypedTax] += rest
+	}
+	return taxes
+}
+
+// categoryShares splits a receipt across its item categories by line total
+func categoryShares(receipt Receipt) map[string]float64 {
+	lines := make(map[string]float64)
+	sum := 0.0
+	for _, item := range receipt.Items {
+		line := item.Price * float64(item.Quantity)
+		if line <= 0 {
+			continue
+		}
+		category := strings.ToLower(strings.TrimSpace(item.Category))
+		if category == "" {
+			category = "uncategorized"
+		}
+		lines[category] += line
+		sum += line
+	}
+	if sum == 0 {
+		return map[string]float64{"uncategorized": 1}
+	}
+	for category := range lines {
+		lines[category] /= sum
+	}
+	return lines
+}
+
+// buildTaxReport totals the receipts dated in [from, to) by period, category
+// and tax type. Receipt totals and tax are shared among item categories in
+// proportion to their line totals.
+func buildTaxReport(receipts []Receipt, settings TaxSettings, from, to time.Time, period string) TaxReport {
+	report := TaxReport{
+		From:       from,
+		To:         to,
+		TaxByType:  make(map[string]float64),
+		Periods:    []TaxPeriod{},
+		Categories: []TaxCategory{},
+	}
+	deductible := make(map[string]bool)
+	for _, category := range settings.DeductibleCategories {
+		deductible[strings.ToLower(category)] = true
+	}
+	business := make(map[string]bool)
+	for _, category := range settings.BusinessCategories {
+		business[strings.ToLower(category)] = true
+	}
+
+	periods := make(map[string]*TaxPeriod)
+	categories := make(map[string]*TaxCategory)
+	for _, receipt := range receipts {
+		if receipt.Date.Before(from) || !receipt.Date.Before(to) {
+			continue
+		}
+		taxes := receiptTaxes(receipt)
+		tax := 0.0
+		for _, amount := range taxes {
+			tax += amount
+		}
+
+		report.ReceiptCount++
+		report.TotalSpent += receipt.TotalAmount
+		report.TotalTax += tax
+
+		key := receipt.Date.Format("2006-01")
+		if period == "quarter" {
+			key = taxQuarter(receipt.Date, settings.FiscalYearStart)
+		}
+		p := periods[key]
+		if p == nil {
+			p = &TaxPeriod{Period: key, TaxByType: make(map[string]float64)}
+			periods[key] = p
+		}
+		p.Spent += receipt.TotalAmount
+		p.Tax += tax
+		for taxType, amount := range taxes {
+			p.TaxByType[taxType] += amount
+			report.TaxByType[taxType] += amount
+		}
+
+		for category, share := range categoryShares(receipt) {
+			c := categories[category]
+			if c == nil {
+				c = &TaxCategory{Category: category, Deductible: deductible[category], Business: business[category]}
+				categories[category] = c
+			}
+			c.Spent += receipt.TotalAmount * share
+			c.Tax += tax * share
+		}
+	}
+
+	for _, p := range periods {
+		p.Spent, p.Tax = roundMoney(p.Spent), roundMoney(p.Tax)
+		for taxType, amount := range p.TaxByType {
+			p.TaxByType[taxType] = roundMoney(amount)
+		}
+		report.Periods = append(report.Periods, *p)
+	}
+	sort.Slice(report.Periods, func(i, j int) bool { return report.Periods[i].Period < report.Periods[j].Period })
+
+	for _, c := range categories {
+		if c.Deductible {
+			report.Deductible.Spent += c.Spent
+			report.Deductible.Tax += c.Tax
+		}
+		if c.Business {
+			report.Business.Spent += c.Spent
+			report.Business.Tax += c.Tax
+		}
+		c.Spent, c.Tax = roundMoney(c.Spent), roundMoney(c.Tax)
+		report.Categories = append(report.Categories, *c)
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		if report.Categories[i].Spent != report.Categories[j].Spent {
+			return report.Categories[i].Spent > report.Categories[j].Spent
+		}
+		return report.Categories[i].Category < report.Categories[j].Category
+	})
+
+	report.TotalSpent, report.TotalTax = roundMoney(report.TotalSpent), roundMoney(report.TotalTax)
+	for taxType, amount := range report.TaxByType {
+		report.TaxByType[taxType] = roundMoney(amount)
+	}
+	report.Deductible = TaxTotal{roundMoney(report.Deductible.Spent), roundMoney(report.Deductible.Tax)}
+	report.Business = TaxTotal{roundMoney(report.Business.Spent), roundMoney(report.Business.Tax)}
+	return report
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// taxTypes lists the report's tax types in order, OTHER last
+func (report TaxReport) taxTypes() []string {
+	types := make([]string, 0, len(report.TaxByType))
+	for taxType := range report.TaxByType {
+		if taxType != untypedTax {
+			types = append(types, taxType)
+		}
+	}
+	sort.Strings(types)
+	if _, ok := report.TaxByType[untypedTax]; ok {
+		types = append(types, untypedTax)
+	}
+	return types
+}
+
+// CSV writes the report as section,name,spent,tax,deductible,business rows
+func (report TaxReport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	out := csv.NewWriter(&buf)
+	money := func(amount float64) string { return strconv.FormatFloat(amount, 'f', 2, 64) }
+
+	out.Write([]string{"section", "name", "spent", "tax", "deductible", "business"})
+	out.Write([]string{"summary", "total " + report.Year, money(report.TotalSpent), money(report.TotalTax), "", ""})
+	out.Write([]string{"summary", "deductible", money(report.Deductible.Spent), money(report.Deductible.Tax), "", ""})
+	out.Write([]string{"summary", "business", money(report.Business.Spent), money(report.Business.Tax), "", ""})
+	for _, taxType := range report.taxTypes() {
+		out.Write([]string{"tax_type", taxType, "", money(report.TaxByType[taxType]), "", ""})
+	}
+	for _, p := range report.Periods {
+		out.Write([]string{"period", p.Period, money(p.Spent), money(p.Tax), "", ""})
+	}
+	for _, c := range report.Categories {
+		out.Write([]string{"category", c.Category, money(c.Spent), money(c.Tax),
+			strconv.FormatBool(c.Deductible), strconv.FormatBool(c.Business)})
+	}
+	out.Flush()
+	return buf.Bytes(), out.Error()
+}
+
+// PDF lays the report out as a printable summary
+func (report TaxReport) PDF() []byte {
+	row := func(name string, spent, tax float64) string {
+		return fmt.Sprintf("    %-32s %14.2f %12.2f", name, spent, tax)
+	}
+	lines := []string{
+		"Tax Summary " + report.Year,
+		fmt.Sprintf("%s to %s, %d receipts", report.From.Format("2 Jan 2006"), report.To.AddDate(0, 0, -1).Format("2 Jan 2006"), report.ReceiptCount),
+		"",
+		fmt.Sprintf("    %-32s %14s %12s", "", "Spent", "Tax"),
+		row("Total", report.TotalSpent, report.TotalTax),
+		row("Deductible", report.Deductible.Spent, report.Deductible.Tax),
+		row("Business", report.Business.Spent, report.Business.Tax),
+		"",
+		"Tax by type",
+	}
+	for _, taxType := range report.taxTypes() {
+		lines = append(lines, fmt.Sprintf("    %-32s %27.2f", taxType, report.TaxByType[taxType]))
+	}
+	lines = append(lines, "", "By period")
+	for _, p := range report.Periods {
+		lines = append(lines, row(p.Period, p.Spent, p.Tax))
+	}
+	lines = append(lines, "", "By category")
+	for _, c := range report.Categories {
+		name := c.Category
+		if c.Deductible {
+			name += " [deductible]"
+		}
+		if c.Business {
+			name += " [business]"
+		}
+		lines = append(lines, row(name, c.Spent, c.Tax))
+	}
+	return textPDF(lines)
+}
+
+// taxReportHandler reports tax paid over a tax year as JSON, CSV or PDF
+func taxReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	householdID := r.URL.Query().Get("household_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "month"
+	}
+	if period != "month" && period != "quarter" {
+		http.Error(w, "period must be month or quarter", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "pdf" {
+		http.Error(w, "format must be json, csv or pdf", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := getTaxSettings(ctx, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tax settings", http.StatusInternalServerError)
+		return
+	}
+	year := currentTaxYear(time.Now(), settings.FiscalYearStart)
+	if v := r.URL.Query().Get("year"); v != "" {
+		if year, err = strconv.Atoi(v); err != nil || year < 1900 || year > 9999 {
+			http.Error(w, "year must be a four-digit year", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+	receipts, err := fetchReceipts(ctx, userID, householdID)
+	if err != nil {
+		http.Error(w, "Failed to fetch receipts", http.StatusInternalServerError)
+		return
+	}
+
+	from, to, label := taxYear(year, settings.FiscalYearStart)
+	report := buildTaxReport(receipts, settings, from, to, period)
+	report.Year = label
+
+	filename := "tax-report-" + label
+	switch format {
+	case "csv":
+		data, err := report.CSV()
+		if err != nil {
+			http.Error(w, "Failed to write report", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+		w.Write(data)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".pdf"))
+		w.Write(report.PDF())
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func taxSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		settings, err := getTaxSettings(ctx, userID)
+		if err != nil {
+			http.Error(w, "Failed to fetch tax settings", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(settings)
+	case "PUT":
+		var settings TaxSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if settings.FiscalYearStart == 0 {
+			settings.FiscalYearStart = 1
+		}
+		if settings.FiscalYearStart < 1 || settings.FiscalYearStart > 12 {
+			http.Error(w, "fiscal_year_start must be a month from 1 to 12", http.StatusBadRequest)
+			return
+		}
+		settings.UserID = userID
+		settings.DeductibleCategories = normalizeCategories(settings.DeductibleCategories)
+		settings.BusinessCategories = normalizeCategories(settings.BusinessCategories)
+		settings.UpdatedAt = time.Now()
+
+		if _, err := firestoreClient.Collection("tax_settings").Doc(userID).Set(ctx, settings); err != nil {
+			http.Error(w, "Failed to save tax settings", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(settings)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getTaxSettings returns the user's tax settings, or calendar-year defaults
+// with nothing deductible if they haven't saved any
+func getTaxSettings(ctx context.Context, userID string) (TaxSettings, error) {
+	settings := TaxSettings{
+		UserID:               userID,
+		DeductibleCategories: []string{},
+		BusinessCategories:   []string{},
+		FiscalYearStart:      1,
+	}
+	doc, err := firestoreClient.Collection("tax_settings").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := doc.DataTo(&settings); err != nil {
+		return settings, err
+	}
+	if settings.FiscalYearStart < 1 || settings.FiscalYearStart > 12 {
+		settings.FiscalYearStart = 1
+	}
+	return settings, nil
+}
+
+// normalizeCategories lowercases and dedupes category names
+func normalizeCategories(categories []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, category := range categories {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if category == "" || seen[category] {
+			continue
+		}
+		seen[category] = true
+		normalized = append(normalized, category)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTaxReport(t *testing.T) {
+	from, to, label := taxYear(2024, 4)
+	if label != "2024-25" || !to.Equal(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the 2024-25 tax year to end on 1 April 2025, got %s ending %v", label, to)
+	}
+	if year := currentTaxYear(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), 4); year != 2024 {
+		t.Errorf("Expected February 2025 to fall in the 2024 tax year, got %d", year)
+	}
+
+	receipts := []Receipt{
+		{
+			Date: time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC), TotalAmount: 118, TaxAmount: 18,
+			TaxBreakdown: map[string]float64{"cgst": 9, "SGST ": 9},
+			Items: []Item{
+				{Name: "Laptop Stand", Price: 75, Quantity: 1, Category: "Electronics"},
+				{Name: "Notebook", Price: 25, Quantity: 1, Category: "stationery"},
+			},
+		},
+		{
+			Date: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), TotalAmount: 55, TaxAmount: 5,
+			TaxBreakdown: map[string]float64{"IGST": 3},
+			Items:        []Item{{Name: "Pens", Price: 10, Quantity: 5, Category: "stationery"}},
+		},
+		// Outside the tax year
+		{Date: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), TotalAmount: 100, TaxAmount: 10},
+	}
+	settings := TaxSettings{DeductibleCategories: []string{"stationery"}, BusinessCategories: []string{"electronics"}, FiscalYearStart: 4}
+
+	report := buildTaxReport(receipts, settings, from, to, "month")
+	if report.ReceiptCount != 2 || report.TotalSpent != 173 || report.TotalTax != 23 {
+		t.Fatalf("Expected 2 receipts, 173 spent and 23 tax, got %+v", report)
+	}
+	want := map[string]float64{"CGST": 9, "SGST": 9, "IGST": 3, "OTHER": 2}
+	for taxType, amount := range want {
+		if report.TaxByType[taxType] != amount {
+			t.Errorf("Expected %s of %.2f, got %v", taxType, amount, report.TaxByType)
+		}
+	}
+	if len(report.Periods) != 2 || report.Periods[0].Period != "2024-04" || report.Periods[1].Tax != 5 {
+		t.Errorf("Expected April and May periods, got %+v", report.Periods)
+	}
+
+	// Laptop stand is 75% of the first receipt
+	if report.Business.Spent != 88.5 || report.Business.Tax != 13.5 {
+		t.Errorf("Expected business spending of 88.50 with 13.50 tax, got %+v", report.Business)
+	}
+	if report.Deductible.Spent != 84.5 || report.Deductible.Tax != 9.5 {
+		t.Errorf("Expected deductible spending of 84.50 with 9.50 tax, got %+v", report.Deductible)
+	}
+
+	quarters := buildTaxReport(receipts, settings, from, to, "quarter")
+	if len(quarters.Periods) != 1 || quarters.Periods[0].Period != "2024-25-Q1" {
+		t.Errorf("Expected one quarter, got %+v", quarters.Periods)
+	}
+
+	for date, want := range map[time.Time]string{
+		time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC): "2024-25-Q1",
+		time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC):  "2024-25-Q2",
+		time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC):  "2024-25-Q4",
+	} {
+		if got := taxQuarter(date, 4); got != want {
+			t.Errorf("taxQuarter(%v) = %s, want %s", date, got, want)
+		}
+	}
+	if got := taxQuarter(time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC), 1); got != "2024-Q4" {
+		t.Errorf("Expected calendar quarters for a January tax year, got %s", got)
+	}
+
+	report.Year = label
+	data, err := report.CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "category,stationery,84.50,9.50,true,false\n") {
+		t.Errorf("Expected a deductible stationery row, got:\n%s", data)
+	}
+
+	pdf := report.PDF()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Errorf("Expected a complete PDF document")
+	}
+	if !bytes.Contains(pdf, []byte("(Tax Summary 2024-25) '")) {
+		t.Errorf("Expected the PDF to contain the title")
+	}
+}
+
+func TestTextPDFPages(t *testing.T) {
+	lines := make([]string, pdfLinesPerPage+1)
+	lines[0] = "Total (incl. tax) \\ ₹"
+	pdf := string(textPDF(lines))
+
+	if !strings.Contains(pdf, "/Count 2") {
+		t.Errorf("Expected two pages")
+	}
+	if !strings.Contains(pdf, `(Total \(incl. tax\) \\ ?) '`) {
+		t.Errorf("Expected escaped text, got %s", pdf)
+	}
+}
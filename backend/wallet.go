@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"raseed-shared/wallet"
+)
+
+// walletSigner signs Save to Google Wallet links. It is nil, and passes are
+// returned without links, unless WALLET_ISSUER_ID and WALLET_KEY_FILE are set.
+var walletSigner *wallet.Signer
+
+// initWalletSigner loads the service account key that Google Wallet trusts
+// for the issuer
+func initWalletSigner() {
+	issuerID := os.Getenv("WALLET_ISSUER_ID")
+	keyPath := os.Getenv("WALLET_KEY_FILE")
+	if issuerID == "" || keyPath == "" {
+		log.Printf("Google Wallet is not configured; passes will have no save links")
+		return
+	}
+
+	keyFile, err := os.ReadFile(keyPath)
+	if err != nil {
+		log.Printf("Failed to read Google Wallet key: %v", err)
+		return
+	}
+	signer, err := wallet.NewSigner(issuerID, keyFile)
+	if err != nil {
+		log.Printf("Failed to load Google Wallet key: %v", err)
+		return
+	}
+	walletSigner = signer
+}
+
+// presentWalletPass reports the pass's state as of now and fills in its Save
+// to Google Wallet link. Revoked passes get no link.
+func presentWalletPass(pass WalletPass, now time.Time) WalletPass {
+	pass.State = sharedPass(pass).CurrentState(now)
+	if pass.State == wallet.StateRevoked {
+		return pass
+	}
+	return withSaveURL(pass)
+}
+
+// withSaveURL fills in the pass's Save to Google Wallet link
+func withSaveURL(pass WalletPass) WalletPass {
+	if walletSigner == nil {
+		return pass
+	}
+	url, err := walletSigner.SaveURL(sharedPass(pass))
+	if err != nil {
+		log.Printf("Failed to sign wallet pass %s: %v", pass.ID, err)
+		return pass
+	}
+	pass.SaveURL = url
+	return pass
+}
+
+// sharedPass is the part of a pass that goes into its Wallet object
+func sharedPass(pass WalletPass) wallet.Pass {
+	return wallet.Pass{
+		ID:            pass.ID,
+		UserID:        pass.UserID,
+		Type:          pass.Type,
+		Title:         pass.Title,
+		Description:   pass.Description,
+		SchemaVersion: pass.SchemaVersion,
+		Payload:       pass.Payload,
+		Data:          pass.Data,
+		State:         pass.State,
+		ExpiresAt:     pass.ExpiresAt,
+		Barcode:       pass.Barcode,
+		CreatedAt:     pass.CreatedAt,
+	}
+}
+
+// publishWalletPassEvent tells the wallet pass creator that a pass was
+// created, updated or deleted, so the issued Wallet object follows it
+func publishWalletPassEvent(ctx context.Context, pass WalletPass, action string) {
+	event := sharedPass(pass).Event(action, pass.WalletObjectID)
+	if err := wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), event); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/barcode"
+	"raseed-shared/wallet"
+)
+
+var (
+	errWalletPassNotFound = errors.New("wallet pass not found")
+	errInvalidPassChange  = errors.New("invalid pass change")
+)
+
+// passChanges is a PATCH to a pass. Fields left out are unchanged.
+type passChanges struct {
+	UserID      string          `json:"user_id"`
+	Title       *string         `json:"title"`
+	Description *string         `json:"description"`
+	Payload     json.RawMessage `json:"payload"`
+	State       string          `json:"state"`
+	ExpiresAt   json.RawMessage `json:"expires_at"` // a time, or null for no expiry
+	Barcode     json.RawMessage `json:"barcode"`    // a barcode, or null for none
+}
+
+// apply makes the changes to the pass and returns the fields to write. Edits
+// come before the state change, so a pass can be given a later expiry and
+// reactivated in one request.
+func (c passChanges) apply(pass *WalletPass, now time.Time) ([]firestore.Update, error) {
+	if sharedPass(*pass).CurrentState(now) == wallet.StateRevoked {
+		return nil, wallet.ErrRevoked
+	}
+
+	updates := []firestore.Update{{Path: "updated_at", Value: now}}
+	if c.Title != nil {
+		title := strings.TrimSpace(*c.Title)
+		if title == "" {
+			return nil, fmt.Errorf("%w: title can't be empty", errInvalidPassChange)
+		}
+		pass.Title = title
+		updates = append(updates, firestore.Update{Path: "title", Value: title})
+	}
+	if c.Description != nil {
+		pass.Description = *c.Description
+		updates = append(updates, firestore.Update{Path: "description", Value: pass.Description})
+	}
+	if len(c.Payload) > 0 {
+		payload, err := wallet.DecodePayload(pass.Type, c.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidPassChange, err)
+		}
+		pass.SchemaVersion, pass.Payload, pass.Data = wallet.SchemaVersion, payload, ""
+		updates = append(updates,
+			firestore.Update{Path: "schema_version", Value: pass.SchemaVersion},
+			firestore.Update{Path: "payload", Value: payload},
+			firestore.Update{Path: "data", Value: firestore.Delete},
+		)
+	}
+	if string(c.Barcode) == "null" {
+		pass.Barcode = nil
+		updates = append(updates, firestore.Update{Path: "barcode", Value: firestore.Delete})
+	} else if len(c.Barcode) > 0 {
+		var in barcode.Barcode
+		if err := json.Unmarshal(c.Barcode, &in); err != nil {
+			return nil, fmt.Errorf("%w: invalid barcode", errInvalidPassChange)
+		}
+		if in.Type == "" && pass.Barcode != nil {
+			in.Type = pass.Barcode.Type
+		}
+		code, err := barcode.New(in.Type, in.Value, in.AlternateText)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidPassChange, err)
+		}
+		pass.Barcode = &code
+		updates = append(updates, firestore.Update{Path: "barcode", Value: code})
+	}
+
+	if len(c.ExpiresAt) == 0 && c.State == "" {
+		return updates, nil
+	}
+	if string(c.ExpiresAt) == "null" {
+		pass.ExpiresAt = nil
+	} else if len(c.ExpiresAt) > 0 {
+		var expiresAt time.Time
+		if err := json.Unmarshal(c.ExpiresAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("%w: expires_at must be an RFC 3339 time", errInvalidPassChange)
+		}
+		pass.ExpiresAt = &expiresAt
+	}
+	if c.State != "" {
+		shared := sharedPass(*pass)
+		if err := shared.Transition(c.State, now); err != nil {
+			return nil, err
+		}
+		pass.State, pass.ExpiresAt = shared.State, shared.ExpiresAt
+	}
+
+	var expiresAt interface{} = firestore.Delete
+	if pass.ExpiresAt != nil {
+		expiresAt = *pass.ExpiresAt
+	}
+	return append(updates,
+		firestore.Update{Path: "state", Value: pass.State},
+		firestore.Update{Path: "expires_at", Value: expiresAt},
+	), nil
+}
+
+func walletPassRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/wallet-passes/"), "/"), "/")
+	if parts[0] == "" || len(parts) > 2 || (len(parts) == 2 && parts[1] != "barcode") {
+		http.NotFound(w, r)
+		return
+	}
+	passID := parts[0]
+
+	switch {
+	case len(parts) == 2 && r.Method == "GET":
+		getWalletPassBarcode(w, r, passID)
+	case len(parts) == 2:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case r.Method == "GET":
+		getWalletPass(w, r, passID)
+	case r.Method == "PATCH":
+		updateWalletPass(w, r, passID)
+	case r.Method == "DELETE":
+		deleteWalletPass(w, r, passID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// loadWalletPass reads a pass, reporting passes of other users as not found
+func loadWalletPass(doc *firestore.DocumentSnapshot, userID string) (WalletPass, error) {
+	var pass WalletPass
+	if doc == nil || !doc.Exists() {
+		return pass, errWalletPassNotFound
+	}
+	if err := doc.DataTo(&pass); err != nil {
+		return pass, err
+	}
+	if pass.UserID != userID {
+		return pass, errWalletPassNotFound
+	}
+	if pass.ID == "" {
+		pass.ID = doc.Ref.ID
+	}
+	return pass, nil
+}
+
+func getWalletPassDoc(ctx context.Context, passID string) (*firestore.DocumentSnapshot, error) {
+	doc, err := firestoreClient.Collection("wallet_passes").Doc(passID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return doc, nil
+	}
+	return doc, err
+}
+
+func getWalletPass(w http.ResponseWriter, r *http.Request, passID string) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getWalletPassDoc(r.Context(), passID)
+	if err != nil {
+		http.Error(w, "Failed to fetch wallet pass", http.StatusInternalServerError)
+		return
+	}
+	pass, err := loadWalletPass(doc, userID)
+	if err == errWalletPassNotFound {
+		http.Error(w, "Wallet pass not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse wallet pass", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(presentWalletPass(pass, time.Now()))
+}
+
+// getWalletPassBarcode renders the pass's barcode as an SVG (the default) or
+// PNG image for the web app
+func getWalletPassBarcode(w http.ResponseWriter, r *http.Request, passID string) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	scale := 4
+	if v := r.URL.Query().Get("scale"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > barcode.MaxScale {
+			http.Error(w, fmt.Sprintf("scale must be between 1 and %d", barcode.MaxScale), http.StatusBadRequest)
+			return
+		}
+		scale = n
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "svg"
+	}
+	if format != "svg" && format != "png" {
+		http.Error(w, "format must be svg or png", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getWalletPassDoc(r.Context(), passID)
+	if err != nil {
+		http.Error(w, "Failed to fetch wallet pass", http.StatusInternalServerError)
+		return
+	}
+	pass, err := loadWalletPass(doc, userID)
+	if err == errWalletPassNotFound {
+		http.Error(w, "Wallet pass not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse wallet pass", http.StatusInternalServerError)
+		return
+	}
+	if pass.Barcode == nil {
+		http.Error(w, "Wallet pass has no barcode", http.StatusNotFound)
+		return
+	}
+
+	var image []byte
+	if format == "png" {
+		image, err = pass.Barcode.PNG(scale)
+		w.Header().Set("Content-Type", "image/png")
+	} else {
+		image, err = pass.Barcode.SVG(scale)
+		w.Header().Set("Content-Type", "image/svg+xml")
+	}
+	if err != nil {
+		http.Error(w, "Failed to render barcode", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(image)
+}
+
+// updateWalletPass edits a pass, changes its expiry or moves it through its
+// lifecycle, then has the wallet pass creator update the issued object
+func updateWalletPass(w http.ResponseWriter, r *http.Request, passID string) {
+	ctx := r.Context()
+
+	var req passChanges
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var pass WalletPass
+	now := time.Now()
+	ref := firestoreClient.Collection("wallet_passes").Doc(passID)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if pass, err = loadWalletPass(doc, req.UserID); err != nil {
+			return err
+		}
+		updates, err := req.apply(&pass, now)
+		if err != nil {
+			return err
+		}
+		pass.UpdatedAt = now
+		return tx.Update(ref, updates)
+	})
+	switch {
+	case err == errWalletPassNotFound:
+		http.Error(w, "Wallet pass not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errInvalidPassChange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, wallet.ErrTransition), errors.Is(err, wallet.ErrRevoked):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update wallet pass", http.StatusInternalServerError)
+		return
+	}
+
+	publishWalletPassEvent(ctx, pass, "updated")
+	json.NewEncoder(w).Encode(presentWalletPass(pass, now))
+}
+
+// deleteWalletPass removes a pass. The issued object can't be deleted from
+// Wallet, so the wallet pass creator makes it inactive.
+func deleteWalletPass(w http.ResponseWriter, r *http.Request, passID string) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getWalletPassDoc(ctx, passID)
+	if err != nil {
+		http.Error(w, "Failed to fetch wallet pass", http.StatusInternalServerError)
+		return
+	}
+	pass, err := loadWalletPass(doc, userID)
+	if err == errWalletPassNotFound {
+		http.Error(w, "Wallet pass not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse wallet pass", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := doc.Ref.Delete(ctx); err != nil {
+		http.Error(w, "Failed to delete wallet pass", http.StatusInternalServerError)
+		return
+	}
+
+	publishWalletPassEvent(ctx, pass, "deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expireShoppingListPasses expires the passes of a list once everything on
+// it has been bought
+func expireShoppingListPasses(ctx context.Context, listID string) {
+	now := time.Now()
+	iter := firestoreClient.Collection("wallet_passes").
+		Where("type", "==", "shopping_list").Where("payload.list_id", "==", listID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to fetch passes for shopping list %s: %v", listID, err)
+			return
+		}
+
+		var pass WalletPass
+		if err := doc.DataTo(&pass); err != nil {
+			continue
+		}
+		if sharedPass(pass).CurrentState(now) != wallet.StateActive {
+			continue
+		}
+		pass.ID = doc.Ref.ID
+		updates, err := passChanges{State: wallet.StateExpired}.apply(&pass, now)
+		if err != nil {
+			log.Printf("Failed to expire wallet pass %s: %v", pass.ID, err)
+			continue
+		}
+		if _, err := doc.Ref.Update(ctx, updates); err != nil {
+			log.Printf("Failed to expire wallet pass %s: %v", pass.ID, err)
+			continue
+		}
+		publishWalletPassEvent(ctx, pass, "updated")
+	}
+}
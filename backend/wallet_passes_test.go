@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"raseed-shared/barcode"
+	"raseed-shared/wallet"
+)
+
+func TestPassChangesApply(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	lapsed := now.Add(-time.Hour)
+	pass := WalletPass{ID: "p1", UserID: "alice", Type: "shopping_list", Title: "Groceries", State: wallet.StateActive, ExpiresAt: &lapsed}
+
+	var changes passChanges
+	if err := json.Unmarshal([]byte(`{"title":" Weekly shop ","payload":{"list_id":"l1","name":"Weekly","items":["Milk"],"item_count":1}}`), &changes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := changes.apply(&pass, now); err != nil {
+		t.Fatal(err)
+	}
+	if pass.Title != "Weekly shop" || pass.SchemaVersion != wallet.SchemaVersion {
+		t.Errorf("Expected the title and payload to change, got %+v", pass)
+	}
+
+	// Reactivating a lapsed pass needs a later expiry in the same request
+	if _, err := (passChanges{State: wallet.StateActive}).apply(&pass, now); !errors.Is(err, wallet.ErrTransition) {
+		t.Errorf("Expected ErrTransition reactivating a lapsed pass, got %v", err)
+	}
+	if _, err := (passChanges{State: wallet.StateActive, ExpiresAt: json.RawMessage("null")}).apply(&pass, now); err != nil {
+		t.Fatal(err)
+	}
+	if pass.ExpiresAt != nil || pass.State != wallet.StateActive {
+		t.Errorf("Expected an active pass without expiry, got %s %v", pass.State, pass.ExpiresAt)
+	}
+
+	if _, err := (passChanges{State: wallet.StateRevoked}).apply(&pass, now); err != nil {
+		t.Fatal(err)
+	}
+	title := "Again"
+	if _, err := (passChanges{Title: &title}).apply(&pass, now); !errors.Is(err, wallet.ErrRevoked) {
+		t.Errorf("Expected ErrRevoked editing a revoked pass, got %v", err)
+	}
+}
+
+func TestPassChangesApplyRejectsBadInput(t *testing.T) {
+	now := time.Now()
+	empty := ""
+	for name, changes := range map[string]passChanges{
+		"empty title": {Title: &empty},
+		"bad payload": {Payload: json.RawMessage(`{"list_id":"l1","colour":"red"}`)},
+		"bad expiry":  {ExpiresAt: json.RawMessage(`"next week"`)},
+		"bad barcode": {Barcode: json.RawMessage(`{"type":"PDF_417","value":"1234"}`)},
+	} {
+		pass := WalletPass{ID: "p1", UserID: "alice", Type: "shopping_list", Title: "Groceries"}
+		if _, err := changes.apply(&pass, now); !errors.Is(err, errInvalidPassChange) {
+			t.Errorf("%s: expected errInvalidPassChange, got %v", name, err)
+		}
+	}
+}
+
+func TestPassChangesApplyBarcode(t *testing.T) {
+	now := time.Now()
+	pass := WalletPass{ID: "p1", UserID: "alice", Type: "shopping_list", Title: "Groceries",
+		Barcode: &barcode.Barcode{Type: barcode.Code128, Value: "L1"}}
+
+	// The type is kept when only the value changes
+	if _, err := (passChanges{Barcode: json.RawMessage(`{"value":"60012345"}`)}).apply(&pass, now); err != nil {
+		t.Fatal(err)
+	}
+	if pass.Barcode.Type != barcode.Code128 || pass.Barcode.Value != "60012345" {
+		t.Errorf("Expected a Code 128 barcode of 60012345, got %+v", pass.Barcode)
+	}
+
+	if _, err := (passChanges{Barcode: json.RawMessage("null")}).apply(&pass, now); err != nil {
+		t.Fatal(err)
+	}
+	if pass.Barcode != nil {
+		t.Errorf("Expected the barcode to be removed, got %+v", pass.Barcode)
+	}
+}
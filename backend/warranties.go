@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/wallet"
+	"raseed-shared/warranty"
+)
+
+var errItemIndex = errors.New("item index out of range")
+
+// UpcomingWarranty is a tracked item with its next deadline
+type UpcomingWarranty struct {
+	warranty.Warranty
+	NextDeadline     time.Time `json:"next_deadline"`
+	NextDeadlineType string    `json:"next_deadline_type"` // return, warranty
+	DaysLeft         int       `json:"days_left"`
+}
+
+func warrantiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		getWarranties(w, r)
+	case "PUT":
+		setWarrantyTerms(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getWarranties lists tracked items whose return window or warranty hasn't
+// lapsed, soonest deadline first. With days, only deadlines within that many
+// days are listed.
+func getWarranties(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("user_id")
+	householdID := r.URL.Query().Get("household_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	days := -1
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "days must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	if householdID != "" && authorizeHousehold(w, r, householdID, userID, false) == nil {
+		return
+	}
+
+	iter := scopedQuery("warranties", userID, householdID).Documents(ctx)
+	var warranties []warranty.Warranty
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch warranties", http.StatusInternalServerError)
+			return
+		}
+
+		var item warranty.Warranty
+		if err := doc.DataTo(&item); err != nil {
+			continue
+		}
+		warranties = append(warranties, item)
+	}
+
+	json.NewEncoder(w).Encode(upcomingWarranties(warranties, time.Now(), days))
+}
+
+// upcomingWarranties keeps the warranties with a deadline ahead, within days
+// unless days is negative, ordered by that deadline
+func upcomingWarranties(warranties []warranty.Warranty, now time.Time, days int) []UpcomingWarranty {
+	upcoming := []UpcomingWarranty{}
+	for _, item := range warranties {
+		deadline, kind, ok := item.NextDeadline(now)
+		if !ok {
+			continue
+		}
+		daysLeft := int(deadline.Sub(now).Hours() / 24)
+		if days >= 0 && daysLeft > days {
+			continue
+		}
+		upcoming = append(upcoming, UpcomingWarranty{
+			Warranty:         item,
+			NextDeadline:     deadline,
+			NextDeadlineType: kind,
+			DaysLeft:         daysLeft,
+		})
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].NextDeadline.Before(upcoming[j].NextDeadline) })
+	return upcoming
+}
+
+// setWarrantyTerms sets a receipt item's warranty and return window, starting
+// or stopping tracking and keeping its proof-of-purchase pass in step
+func setWarrantyTerms(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	receiptID := r.URL.Query().Get("receipt_id")
+	index, err := strconv.Atoi(r.URL.Query().Get("item"))
+	if receiptID == "" || err != nil {
+		http.Error(w, "receipt_id and item are required", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UserID         string `json:"user_id"`
+		WarrantyMonths int    `json:"warranty_months"`
+		ReturnDays     int    `json:"return_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.WarrantyMonths < 0 || req.ReturnDays < 0 {
+		http.Error(w, "warranty_months and return_days can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := firestoreClient.Collection("receipts").Doc(receiptID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "Receipt not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to fetch receipt", http.StatusInternalServerError)
+		}
+		return
+	}
+	var receipt Receipt
+	if err := doc.DataTo(&receipt); err != nil {
+		http.Error(w, "Failed to parse receipt", http.StatusInternalServerError)
+		return
+	}
+	if receipt.UserID != req.UserID {
+		if receipt.HouseholdID == "" {
+			http.Error(w, "Receipt not found", http.StatusNotFound)
+			return
+		}
+		if authorizeHousehold(w, r, receipt.HouseholdID, req.UserID, true) == nil {
+			return
+		}
+	}
+
+	var result warranty.Warranty
+	var passEvent wallet.Event
+	receiptRef := firestoreClient.Collection("receipts").Doc(receiptID)
+	warrantyRef := firestoreClient.Collection("warranties").Doc(warranty.ID(receiptID, index))
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		passEvent = wallet.Event{}
+		doc, err := tx.Get(receiptRef)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&receipt); err != nil {
+			return err
+		}
+		if index < 0 || index >= len(receipt.Items) {
+			return errItemIndex
+		}
+
+		existing, err := tx.Get(warrantyRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now()
+		item := &receipt.Items[index]
+		item.WarrantyMonths, item.ReturnDays = req.WarrantyMonths, req.ReturnDays
+
+		result = warranty.Warranty{
+			ID:           warrantyRef.ID,
+			UserID:       receipt.UserID,
+			HouseholdID:  receipt.HouseholdID,
+			ReceiptID:    receipt.ID,
+			ItemIndex:    index,
+			ItemName:     item.Name,
+			Category:     item.Category,
+			StoreName:    receipt.StoreName,
+			Price:        item.Price,
+			ImageURL:     receipt.ImageURL,
+			PurchaseDate: receipt.Date,
+			CreatedAt:    now,
+		}
+		if existing != nil && existing.Exists() {
+			if err := existing.DataTo(&result); err != nil {
+				return err
+			}
+		}
+		result.SetTerms(warranty.Terms{WarrantyMonths: req.WarrantyMonths, ReturnDays: req.ReturnDays})
+		result.UpdatedAt = now
+
+		passRef := firestoreClient.Collection("wallet_passes").Doc(result.PassID())
+		passDoc, err := tx.Get(passRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		var current wallet.Pass
+		objectID := ""
+		if passDoc.Exists() {
+			if err := passDoc.DataTo(&current); err != nil {
+				return err
+			}
+			if object, err := passDoc.DataAt("wallet_object_id"); err == nil {
+				objectID, _ = object.(string)
+			}
+		}
+
+		if err := tx.Update(receiptRef, []firestore.Update{
+			{Path: "items", Value: receipt.Items},
+			{Path: "updated_at", Value: now},
+		}); err != nil {
+			return err
+		}
+
+		if !result.Tracked() {
+			if err := tx.Delete(warrantyRef); err != nil {
+				return err
+			}
+			if passDoc.Exists() {
+				passEvent = current.Event("deleted", objectID)
+			}
+			return tx.Delete(passRef)
+		}
+		pass, err := result.Pass()
+		if err != nil {
+			return err
+		}
+		cfg := runtimeConfig.Get(ctx)
+		pass.Barcode = wallet.DefaultBarcode(pass, cfg.BarcodeType, cfg.AppLinkBase)
+		if err := tx.Set(warrantyRef, result); err != nil {
+			return err
+		}
+		if passDoc.Exists() {
+			// Only the terms change; the issued Wallet object and the pass's
+			// state stay
+			passEvent = pass.Event("updated", objectID)
+			return tx.Update(passRef, []firestore.Update{
+				{Path: "description", Value: pass.Description},
+				{Path: "payload", Value: pass.Payload},
+				{Path: "updated_at", Value: now},
+			})
+		}
+		passEvent = pass.Event("created", "")
+		return tx.Set(passRef, pass)
+	})
+	if err == errItemIndex {
+		http.Error(w, "Item index out of range", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update warranty", http.StatusInternalServerError)
+		return
+	}
+	if passEvent.PassID != "" {
+		if err := wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), passEvent); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tracked":  result.Tracked(),
+		"warranty": result,
+	})
+}
+
+// warrantyRemindersHandler notifies owners of return windows closing within
+// three days and warranties ending within thirty. It is triggered daily by
+// Cloud Scheduler; each deadline is reminded about once.
+func warrantyRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+
+	due := make(map[string]warranty.Warranty)
+	for _, window := range []struct {
+		field string
+		lead  time.Duration
+	}{
+		{"return_by", warranty.ReturnLead},
+		{"warranty_until", warranty.WarrantyLead},
+	} {
+		iter := firestoreClient.Collection("warranties").
+			Where(window.field, ">=", now).Where(window.field, "<=", now.Add(window.lead)).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				log.Printf("Warranty reminders failed to fetch warranties: %v", err)
+				http.Error(w, "Failed to fetch warranties", http.StatusInternalServerError)
+				return
+			}
+			var item warranty.Warranty
+			if err := doc.DataTo(&item); err != nil {
+				continue
+			}
+			due[item.ID] = item
+		}
+	}
+
+	sent := 0
+	for _, item := range due {
+		for _, kind := range item.DueReminders(now) {
+			if err := sendWarrantyReminder(ctx, item, kind); err != nil {
+				log.Printf("Failed to send %s reminder for %s: %v", kind, item.ID, err)
+				continue
+			}
+			sent++
+		}
+	}
+
+	log.Printf("Warranty reminders checked %d items, %d reminders sent", len(due), sent)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"checked":        len(due),
+		"reminders_sent": sent,
+	})
+}
+
+// sendWarrantyReminder publishes the reminder and marks it sent
+func sendWarrantyReminder(ctx context.Context, item warranty.Warranty, kind string) error {
+	title := "Return Window Closing"
+	flag := "return_reminded"
+	if kind == warranty.Cover {
+		title = "Warranty Ending"
+		flag = "warranty_reminded"
+	}
+
+	notification := map[string]interface{}{
+		"id":      fmt.Sprintf("warranty_reminder_%s_%s", item.ID, kind),
+		"user_id": item.UserID,
+		"type":    "warranty_reminder",
+		"title":   title,
+		"message": item.Message(kind),
+		"data": map[string]interface{}{
+			"warranty_id": item.ID,
+			"receipt_id":  item.ReceiptID,
+			"pass_id":     item.PassID(),
+			"deadline":    kind,
+		},
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	topic := pubsubClient.Topic("notification-events")
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx); err != nil {
+		return err
+	}
+
+	_, err = firestoreClient.Collection("warranties").Doc(item.ID).Update(ctx, []firestore.Update{
+		{Path: flag, Value: true},
+	})
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"raseed-shared/warranty"
+)
+
+func TestUpcomingWarranties(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	item := func(id string, purchase time.Time, terms warranty.Terms) warranty.Warranty {
+		w := warranty.Warranty{ID: id, PurchaseDate: purchase}
+		w.SetTerms(terms)
+		return w
+	}
+	warranties := []warranty.Warranty{
+		item("tv", now.AddDate(0, 0, -5), warranty.Terms{WarrantyMonths: 12, ReturnDays: 7}),
+		item("phone", now.AddDate(0, -11, -20), warranty.Terms{WarrantyMonths: 12}),
+		item("shirt", now.AddDate(0, 0, -40), warranty.Terms{ReturnDays: 30}),
+	}
+
+	upcoming := upcomingWarranties(warranties, now, -1)
+	if len(upcoming) != 2 {
+		t.Fatalf("Expected the lapsed shirt to be dropped, got %+v", upcoming)
+	}
+	if upcoming[0].ID != "tv" || upcoming[0].NextDeadlineType != warranty.Return || upcoming[0].DaysLeft != 2 {
+		t.Errorf("Expected the TV return window first, got %+v", upcoming[0])
+	}
+	if upcoming[1].ID != "phone" || upcoming[1].NextDeadlineType != warranty.Cover {
+		t.Errorf("Expected the phone warranty second, got %+v", upcoming[1])
+	}
+
+	if within := upcomingWarranties(warranties, now, 3); len(within) != 1 || within[0].ID != "tv" {
+		t.Errorf("Expected only the TV within 3 days, got %+v", within)
+	}
+}
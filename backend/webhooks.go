@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/webhooks"
+)
+
+var (
+	errWebhookNotFound  = errors.New("webhook not found")
+	errDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// maxWebhooks bounds the webhooks one user can register
+const maxWebhooks = 10
+
+// webhookClient sends redeliveries, which the user waits on
+var webhookClient = webhooks.NewClient(10 * time.Second)
+
+// webhookChanges is an update to a webhook. Fields left out are unchanged.
+type webhookChanges struct {
+	UserID string    `json:"user_id"`
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// apply makes the changes to the webhook
+func (c webhookChanges) apply(hook *webhooks.Webhook, now time.Time) error {
+	if c.URL != nil {
+		hook.URL = strings.TrimSpace(*c.URL)
+	}
+	if c.Events != nil {
+		hook.Events = dedupeEvents(*c.Events)
+	}
+	if c.Active != nil {
+		hook.Active = *c.Active
+	}
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	hook.UpdatedAt = now
+	return nil
+}
+
+// dedupeEvents drops repeated event types, keeping the first of each
+func dedupeEvents(events []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		if !seen[e] {
+			seen[e] = true
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		getWebhooks(w, r)
+	case "POST":
+		createWebhook(w, r)
+	case "PUT":
+		updateWebhook(w, r)
+	case "DELETE":
+		deleteWebhook(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getWebhooks lists the user's webhooks, without their secrets
+func getWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	hooks, err := userWebhooks(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func userWebhooks(ctx context.Context, userID string) ([]webhooks.Webhook, error) {
+	iter := firestoreClient.Collection("webhooks").Where("user_id", "==", userID).OrderBy("created_at", firestore.Asc).Documents(ctx)
+	hooks := []webhooks.Webhook{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var hook webhooks.Webhook
+		if err := doc.DataTo(&hook); err != nil {
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// createWebhook registers a URL for some event types. The response holds the
+// signing secret, which isn't shown again.
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		UserID string   `json:"user_id"`
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	hook := webhooks.Webhook{
+		ID:        generateID(),
+		UserID:    req.UserID,
+		URL:       strings.TrimSpace(req.URL),
+		Events:    dedupeEvents(req.Events),
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := hook.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := userWebhooks(ctx, hook.UserID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxWebhooks {
+		http.Error(w, fmt.Sprintf("At most %d webhooks can be registered", maxWebhooks), http.StatusBadRequest)
+		return
+	}
+
+	if hook.Secret, err = webhooks.NewSecret(); err != nil {
+		http.Error(w, "Failed to create webhook secret", http.StatusInternalServerError)
+		return
+	}
+	if _, err := firestoreClient.Collection("webhooks").Doc(hook.ID).Set(ctx, hook); err != nil {
+		http.Error(w, "Failed to save webhook", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hook)
+}
+
+// updateWebhook changes a webhook's URL or event types, or pauses it
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	var changes webhookChanges
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if changes.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ref := firestoreClient.Collection("webhooks").Doc(id)
+	var hook webhooks.Webhook
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if hook, err = loadWebhook(doc, err, changes.UserID); err != nil {
+			return err
+		}
+		if err := changes.apply(&hook, time.Now()); err != nil {
+			return err
+		}
+		return tx.Set(ref, hook)
+	})
+	if err == errWebhookNotFound {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, webhooks.ErrInvalidWebhook) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	hook.Secret = ""
+	json.NewEncoder(w).Encode(hook)
+}
+
+// deleteWebhook stops deliveries to a webhook. Its delivery log is kept.
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.URL.Query().Get("id")
+	userID := r.URL.Query().Get("user_id")
+	if id == "" || userID == "" {
+		http.Error(w, "id and user_id are required", http.StatusBadRequest)
+		return
+	}
+
+	ref := firestoreClient.Collection("webhooks").Doc(id)
+	doc, err := ref.Get(ctx)
+	if _, err := loadWebhook(doc, err, userID); err == errWebhookNotFound {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := ref.Delete(ctx); err != nil {
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadWebhook reads a webhook document fetched for the user. Webhooks of
+// other users are reported as not found.
+func loadWebhook(doc *firestore.DocumentSnapshot, err error, userID string) (webhooks.Webhook, error) {
+	var hook webhooks.Webhook
+	if status.Code(err) == codes.NotFound {
+		return hook, errWebhookNotFound
+	}
+	if err != nil {
+		return hook, err
+	}
+	if err := doc.DataTo(&hook); err != nil {
+		return hook, err
+	}
+	if hook.UserID != userID {
+		return hook, errWebhookNotFound
+	}
+	return hook, nil
+}
+
+// webhookDeliveriesHandler returns the user's delivery log, newest first,
+// optionally for one webhook or with one status
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		if limit > 200 {
+			limit = 200
+		}
+	}
+
+	query := firestoreClient.Collection("webhook_deliveries").Where("user_id", "==", userID)
+	if webhookID := r.URL.Query().Get("webhook_id"); webhookID != "" {
+		query = query.Where("webhook_id", "==", webhookID)
+	}
+	if s := r.URL.Query().Get("status"); s != "" {
+		query = query.Where("status", "==", s)
+	}
+	iter := query.OrderBy("created_at", firestore.Desc).Limit(limit).Documents(r.Context())
+	deliveries := []webhooks.Delivery{}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch webhook deliveries", http.StatusInternalServerError)
+			return
+		}
+
+		var d webhooks.Delivery
+		if err := doc.DataTo(&d); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// redeliverHandler sends a logged delivery's event again, right away, to
+// the webhook's current URL and with its current secret. The attempt is
+// logged as a new delivery and returned, whether or not it succeeded.
+func redeliverHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UserID     string `json:"user_id"`
+		DeliveryID string `json:"delivery_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" || req.DeliveryID == "" {
+		http.Error(w, "user_id and delivery_id are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	redelivery, err := redeliver(ctx, req.UserID, req.DeliveryID)
+	switch {
+	case err == errDeliveryNotFound:
+		http.Error(w, "Webhook delivery not found", http.StatusNotFound)
+		return
+	case err == errWebhookNotFound:
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to redeliver %s: %v", req.DeliveryID, err)
+		http.Error(w, "Failed to redeliver webhook event", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(redelivery)
+}
+
+func redeliver(ctx context.Context, userID, deliveryID string) (webhooks.Delivery, error) {
+	var original webhooks.Delivery
+	doc, err := firestoreClient.Collection("webhook_deliveries").Doc(deliveryID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return original, errDeliveryNotFound
+	}
+	if err != nil {
+		return original, err
+	}
+	if err := doc.DataTo(&original); err != nil {
+		return original, err
+	}
+	if original.UserID != userID {
+		return original, errDeliveryNotFound
+	}
+
+	hookDoc, err := firestoreClient.Collection("webhooks").Doc(original.WebhookID).Get(ctx)
+	hook, err := loadWebhook(hookDoc, err, userID)
+	if err != nil {
+		return original, err
+	}
+
+	now := time.Now()
+	redelivery := webhooks.Delivery{
+		ID:           fmt.Sprintf("%s_%s_%s", original.EventID, hook.ID, generateID()),
+		WebhookID:    hook.ID,
+		UserID:       userID,
+		EventID:      original.EventID,
+		EventType:    original.EventType,
+		URL:          hook.URL,
+		Payload:      original.Payload,
+		RedeliveryOf: original.ID,
+		CreatedAt:    now,
+	}
+	// A failed attempt is the outcome to report, not an error of the request
+	webhooks.Send(ctx, webhookClient, hook, &redelivery, now)
+
+	if _, err := firestoreClient.Collection("webhook_deliveries").Doc(redelivery.ID).Set(ctx, redelivery); err != nil {
+		return redelivery, err
+	}
+	return redelivery, nil
+}
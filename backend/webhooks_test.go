@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"raseed-shared/webhooks"
+)
+
+func TestWebhookChanges(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	hook := webhooks.Webhook{
+		ID:     "wh1",
+		UserID: "u1",
+		URL:    "https://example.com/hook",
+		Events: []string{webhooks.ReceiptExtracted},
+		Active: true,
+	}
+
+	url := " https://example.com/v2 "
+	events := []string{webhooks.PassCreated, webhooks.StockExpiring, webhooks.PassCreated}
+	paused := false
+	if err := (webhookChanges{URL: &url, Events: &events, Active: &paused}).apply(&hook, now); err != nil {
+		t.Fatal(err)
+	}
+	if hook.URL != "https://example.com/v2" || hook.Active || !hook.UpdatedAt.Equal(now) {
+		t.Errorf("Unexpected webhook %+v", hook)
+	}
+	if want := []string{webhooks.PassCreated, webhooks.StockExpiring}; !reflect.DeepEqual(hook.Events, want) {
+		t.Errorf("events = %v, want %v", hook.Events, want)
+	}
+
+	insecure := "http://example.com/hook"
+	if err := (webhookChanges{URL: &insecure}).apply(&hook, now); !errors.Is(err, webhooks.ErrInvalidWebhook) {
+		t.Errorf("Expected a plain http URL to be rejected, got %v", err)
+	}
+	none := []string{}
+	if err := (webhookChanges{Events: &none}).apply(&hook, now); !errors.Is(err, webhooks.ErrInvalidWebhook) {
+		t.Errorf("Expected an empty event list to be rejected, got %v", err)
+	}
+}
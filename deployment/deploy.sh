@@ -9,6 +9,8 @@ set -e
 PROJECT_ID=${1:-"your-project-id"}
 REGION="us-central1"
 SERVICE_ACCOUNT="raseed-backend@${PROJECT_ID}.iam.gserviceaccount.com"
+ADMIN_TOKEN=${ADMIN_TOKEN:-$(openssl rand -hex 32)}
+WALLET_ISSUER_ID=${WALLET_ISSUER_ID:-} # Google Wallet issuer; save links need the wallet-key secret too
 
 # Colors for output
 RED='\033[0;31m'
@@ -53,6 +55,7 @@ apis=(
     "maps.googleapis.com"
     "logging.googleapis.com"
     "monitoring.googleapis.com"
+    "cloudscheduler.googleapis.com"
 )
 
 for api in "${apis[@]}"; do
@@ -114,6 +117,8 @@ topics=(
     "spending-analysis"
     "stock-management"
     "notification-events"
+    "notification-sweeps"
+    "webhook-events"
 )
 
 for topic in "${topics[@]}"; do
@@ -130,6 +135,7 @@ subscriptions=(
     "spending-analyzer-sub:spending-analysis"
     "stock-manager-sub:stock-management"
     "notification-processor-sub:notification-events"
+    "webhook-dispatcher-sub:webhook-events"
 )
 
 for subscription in "${subscriptions[@]}"; do
@@ -146,6 +152,9 @@ done
 echo -e "${YELLOW}🐳 Building and deploying backend container...${NC}"
 cd backend
 
+# Vendor the shared packages so the build context is self-contained
+go mod vendor
+
 # Build the container
 echo "Building container image..."
 gcloud builds submit --tag gcr.io/$PROJECT_ID/raseed-backend:latest .
@@ -161,16 +170,77 @@ gcloud run deploy raseed-backend \
     --memory 2Gi \
     --cpu 2 \
     --max-instances 100 \
-    --set-env-vars "GOOGLE_CLOUD_PROJECT=$PROJECT_ID,CLOUD_STORAGE_BUCKET=$BUCKET_NAME,VERTEX_AI_LOCATION=$REGION"
+    --set-env-vars "GOOGLE_CLOUD_PROJECT=$PROJECT_ID,CLOUD_STORAGE_BUCKET=$BUCKET_NAME,VERTEX_AI_LOCATION=$REGION,ADMIN_TOKEN=$ADMIN_TOKEN"
+
+# Sign Save to Google Wallet links with the key in the wallet-key secret
+if [ -n "$WALLET_ISSUER_ID" ]; then
+    gcloud run services update raseed-backend \
+        --region $REGION \
+        --set-secrets /secrets/wallet/key.json=wallet-key:latest \
+        --update-env-vars "WALLET_ISSUER_ID=$WALLET_ISSUER_ID,WALLET_KEY_FILE=/secrets/wallet/key.json"
+fi
 
 cd ..
 
+# Schedule the daily stock expiry sweep
+echo -e "${YELLOW}⏰ Scheduling stock expiry sweep...${NC}"
+BACKEND_URL=$(gcloud run services describe raseed-backend --region $REGION --format 'value(status.url)')
+gcloud scheduler jobs create http stock-expiry-sweep \
+    --location $REGION \
+    --schedule "0 7 * * *" \
+    --uri "$BACKEND_URL/tasks/stock-sweep" \
+    --http-method POST \
+    --headers "Authorization=Bearer $ADMIN_TOKEN" \
+    || gcloud scheduler jobs update http stock-expiry-sweep \
+    --location $REGION \
+    --uri "$BACKEND_URL/tasks/stock-sweep" \
+    --update-headers "Authorization=Bearer $ADMIN_TOKEN"
+
+# Schedule the daily warranty and return window reminders
+echo -e "${YELLOW}⏰ Scheduling warranty reminders...${NC}"
+gcloud scheduler jobs create http warranty-reminders \
+    --location $REGION \
+    --schedule "0 9 * * *" \
+    --uri "$BACKEND_URL/tasks/warranty-reminders" \
+    --http-method POST \
+    --headers "Authorization=Bearer $ADMIN_TOKEN" \
+    || gcloud scheduler jobs update http warranty-reminders \
+    --location $REGION \
+    --uri "$BACKEND_URL/tasks/warranty-reminders" \
+    --update-headers "Authorization=Bearer $ADMIN_TOKEN"
+
+# Schedule the daily cleanup of old inbox notifications
+echo -e "${YELLOW}⏰ Scheduling notification cleanup...${NC}"
+gcloud scheduler jobs create http notification-cleanup \
+    --location $REGION \
+    --schedule "0 3 * * *" \
+    --uri "$BACKEND_URL/tasks/notification-cleanup" \
+    --http-method POST \
+    --headers "Authorization=Bearer $ADMIN_TOKEN" \
+    || gcloud scheduler jobs update http notification-cleanup \
+    --location $REGION \
+    --uri "$BACKEND_URL/tasks/notification-cleanup" \
+    --update-headers "Authorization=Bearer $ADMIN_TOKEN"
+
+# Sweep for pushes held for quiet hours every 15 minutes
+echo -e "${YELLOW}⏰ Scheduling held notification sweep...${NC}"
+gcloud scheduler jobs create pubsub held-notification-sweep \
+    --location $REGION \
+    --schedule "*/15 * * * *" \
+    --topic notification-sweeps \
+    --message-body "{}" \
+    || gcloud scheduler jobs update pubsub held-notification-sweep \
+    --location $REGION \
+    --schedule "*/15 * * * *" \
+    --topic notification-sweeps
+
 # Deploy Cloud Functions
 echo -e "${YELLOW}⚡ Deploying Cloud Functions...${NC}"
 
 # Receipt Processor
 echo "Deploying receipt processor..."
 cd functions/receipt_processor
+go mod vendor
 gcloud functions deploy receipt-processor \
     --runtime go121 \
     --region $REGION \
@@ -186,6 +256,7 @@ cd ../..
 # Query Processor
 echo "Deploying query processor..."
 cd functions/query_processor
+go mod vendor
 gcloud functions deploy query-processor \
     --runtime go121 \
     --region $REGION \
@@ -201,6 +272,7 @@ cd ../..
 # Third Party Integration
 echo "Deploying third-party integration..."
 cd functions/third_party_integration
+go mod vendor
 gcloud functions deploy third-party-integration \
     --runtime go121 \
     --region $REGION \
@@ -213,6 +285,66 @@ gcloud functions deploy third-party-integration \
     --service-account $SERVICE_ACCOUNT
 cd ../..
 
+# Wallet Pass Creator
+echo "Deploying wallet pass creator..."
+cd functions/wallet_pass_creator
+go mod vendor
+gcloud functions deploy wallet-pass-creator \
+    --runtime go121 \
+    --region $REGION \
+    --entry-point ProcessWalletPassCreation \
+    --trigger-topic wallet-pass-creation \
+    --memory 256MB \
+    --timeout 60s \
+    --max-instances 20 \
+    --set-env-vars "GOOGLE_CLOUD_PROJECT=$PROJECT_ID,WALLET_ISSUER_ID=$WALLET_ISSUER_ID" \
+    --service-account $SERVICE_ACCOUNT
+cd ../..
+
+# Notification Processor
+echo "Deploying notification processor..."
+cd functions/notification_processor
+go mod vendor
+gcloud functions deploy notification-processor \
+    --runtime go121 \
+    --region $REGION \
+    --entry-point ProcessNotification \
+    --trigger-topic notification-events \
+    --retry \
+    --memory 512MB \
+    --timeout 300s \
+    --max-instances 20 \
+    --set-env-vars "GOOGLE_CLOUD_PROJECT=$PROJECT_ID,FCM_PROJECT_ID=$PROJECT_ID,SMTP_HOST=${SMTP_HOST:-},SMTP_PORT=${SMTP_PORT:-587},SMTP_USERNAME=${SMTP_USERNAME:-},SMTP_PASSWORD=${SMTP_PASSWORD:-},SMTP_FROM=${SMTP_FROM:-}" \
+    --service-account $SERVICE_ACCOUNT
+gcloud functions deploy held-notification-sender \
+    --runtime go121 \
+    --region $REGION \
+    --entry-point SendHeldNotifications \
+    --trigger-topic notification-sweeps \
+    --memory 256MB \
+    --timeout 300s \
+    --max-instances 1 \
+    --set-env-vars "GOOGLE_CLOUD_PROJECT=$PROJECT_ID,FCM_PROJECT_ID=$PROJECT_ID" \
+    --service-account $SERVICE_ACCOUNT
+cd ../..
+
+# Webhook Dispatcher
+echo "Deploying webhook dispatcher..."
+cd functions/webhook_dispatcher
+go mod vendor
+gcloud functions deploy webhook-dispatcher \
+    --runtime go121 \
+    --region $REGION \
+    --entry-point DispatchWebhookEvent \
+    --trigger-topic webhook-events \
+    --retry \
+    --memory 256MB \
+    --timeout 120s \
+    --max-instances 20 \
+    --set-env-vars "GOOGLE_CLOUD_PROJECT=$PROJECT_ID" \
+    --service-account $SERVICE_ACCOUNT
+cd ../..
+
 # Set up Vertex AI Agent
 echo -e "${YELLOW}🤖 Setting up Vertex AI Agent...${NC}"
 # Note: Vertex AI Agent Builder setup requires manual configuration in the console
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/oauth2/google"
+
+	"raseed-shared/notify"
+	"raseed-shared/webhooks"
+)
+
+// errNoAddress means the user can't be reached on a channel: no email
+// address, no registered device or no webhook URL
+var errNoAddress = errors.New("no address for the channel")
+
+// Channel delivers notifications one way
+type Channel interface {
+	Send(ctx context.Context, to Recipient, n Notification) error
+}
+
+// stubChannel stands in for a channel's service when running locally, or when
+// the service isn't configured. It logs what would have been sent.
+type stubChannel struct {
+	name string
+}
+
+func (c stubChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	log.Printf("[%s stub] to %s: %s - %s", c.name, to.ID, n.Title, n.Message)
+	return nil
+}
+
+// newChannels sets up every channel. NOTIFICATION_STUBS lists channels to
+// stub, or "all"; email and push are stubbed anyway until configured.
+func newChannels(ctx context.Context) map[string]Channel {
+	stubbed := make(map[string]bool)
+	for _, name := range strings.Split(os.Getenv("NOTIFICATION_STUBS"), ",") {
+		stubbed[strings.TrimSpace(name)] = true
+	}
+	stub := func(name string) bool { return stubbed["all"] || stubbed[name] }
+
+	channels := map[string]Channel{
+		notify.Inbox:   inboxChannel{},
+		notify.Email:   stubChannel{notify.Email},
+		notify.Push:    stubChannel{notify.Push},
+		notify.Webhook: webhookChannel{http: webhooks.NewClient(10 * time.Second)},
+	}
+	for _, name := range notify.AllChannels {
+		if stub(name) {
+			channels[name] = stubChannel{name}
+		}
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" && !stub(notify.Email) {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		email := smtpChannel{addr: host + ":" + port, from: os.Getenv("SMTP_FROM")}
+		if user := os.Getenv("SMTP_USERNAME"); user != "" {
+			email.auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		channels[notify.Email] = email
+	} else if host == "" {
+		log.Printf("SMTP_HOST is not set; email notifications go to a stub")
+	}
+
+	if project := os.Getenv("FCM_PROJECT_ID"); project != "" && !stub(notify.Push) {
+		httpClient, err := google.DefaultClient(ctx, fcmScope)
+		if err != nil {
+			log.Printf("Failed to create FCM client, push notifications go to a stub: %v", err)
+		} else {
+			channels[notify.Push] = fcmChannel{http: httpClient, project: project}
+		}
+	} else if project == "" {
+		log.Printf("FCM_PROJECT_ID is not set; push notifications go to a stub")
+	}
+	return channels
+}
+
+// inboxChannel keeps the notification in the user's in-app inbox
+type inboxChannel struct{}
+
+func (inboxChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	_, err := firestoreClient.Collection("notifications").Doc(n.ID).Set(ctx, map[string]interface{}{
+		"id":         n.ID,
+		"user_id":    to.ID,
+		"type":       n.Type,
+		"title":      n.Title,
+		"message":    n.Message,
+		"data":       n.Data,
+		"read":       false,
+		"created_at": n.CreatedAt,
+	})
+	return err
+}
+
+// smtpChannel emails the notification
+type smtpChannel struct {
+	addr string
+	auth smtp.Auth // nil for relays without authentication
+	from string
+}
+
+func (c smtpChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	if to.Email == "" {
+		return errNoAddress
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", c.from, to.Email, headerSafe(n.Title))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", n.Message)
+	return smtp.SendMail(c.addr, c.auth, c.from, []string{to.Email}, msg.Bytes())
+}
+
+// headerSafe keeps line breaks in a title from starting new headers
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// fcmScope is the OAuth scope of the FCM HTTP v1 API
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// fcmChannel pushes the notification to the user's registered devices
+type fcmChannel struct {
+	http    *http.Client // authorized for fcmScope
+	project string
+}
+
+func (c fcmChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	if len(to.FCMTokens) == 0 {
+		return errNoAddress
+	}
+
+	// FCM data values must be strings
+	data := map[string]string{"notification_id": n.ID, "type": n.Type}
+	if len(n.Data) > 0 {
+		encoded, err := json.Marshal(n.Data)
+		if err != nil {
+			return err
+		}
+		data["data"] = string(encoded)
+	}
+
+	var failed []string
+	var stale []interface{}
+	for _, token := range to.FCMTokens {
+		body, err := json.Marshal(map[string]interface{}{
+			"message": map[string]interface{}{
+				"token":        token,
+				"notification": map[string]string{"title": n.Title, "body": n.Message},
+				"data":         data,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			"https://fcm.googleapis.com/v1/projects/"+c.project+"/messages:send", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			// The app was uninstalled or the token rotated
+			stale = append(stale, token)
+		case resp.StatusCode >= 300:
+			failed = append(failed, fmt.Sprintf("FCM returned %s: %s", resp.Status, bytes.TrimSpace(message)))
+		}
+	}
+
+	if len(stale) > 0 {
+		if _, err := firestoreClient.Collection("users").Doc(to.ID).Update(ctx, []firestore.Update{
+			{Path: "fcm_tokens", Value: firestore.ArrayRemove(stale...)},
+		}); err != nil {
+			log.Printf("Failed to remove stale FCM tokens of %s: %v", to.ID, err)
+		}
+	}
+	if len(failed) > 0 && len(failed)+len(stale) == len(to.FCMTokens) {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	if len(stale) == len(to.FCMTokens) {
+		return errNoAddress
+	}
+	return nil
+}
+
+// webhookChannel posts the notification to the user's webhook URL. The URL
+// is the user's to set, so only public HTTPS addresses are posted to.
+type webhookChannel struct {
+	http *http.Client
+}
+
+func (c webhookChannel) Send(ctx context.Context, to Recipient, n Notification) error {
+	if to.Preferences.Notifications.WebhookURL == "" {
+		return errNoAddress
+	}
+	if err := webhooks.CheckURL(to.Preferences.Notifications.WebhookURL); err != nil {
+		return fmt.Errorf("%w: %v", errNoAddress, err)
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.Preferences.Notifications.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/functions/metadata"
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/notify"
+)
+
+// Notification is an event being delivered
+type Notification struct {
+	notify.Event
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Recipient is the user a notification is for, as stored in users
+type Recipient struct {
+	ID          string   `firestore:"-"`
+	Email       string   `firestore:"email"`
+	Name        string   `firestore:"name"`
+	FCMTokens   []string `firestore:"fcm_tokens"` // registered by the app on each device
+	Preferences struct {
+		Notifications notify.Preferences `firestore:"notifications"`
+	} `firestore:"preferences"`
+}
+
+// Delivery is the outcome of a notification on one channel, kept in
+// notification_deliveries as {notification_id}_{channel}
+type Delivery struct {
+	ID             string     `firestore:"id"`
+	NotificationID string     `firestore:"notification_id"`
+	UserID         string     `firestore:"user_id"`
+	Type           string     `firestore:"type"`
+	Channel        string     `firestore:"channel"`
+	Status         string     `firestore:"status"` // sent, stubbed, skipped, held, failed
+	Error          string     `firestore:"error,omitempty"`
+	Attempts       int        `firestore:"attempts"`
+	NextAttemptAt  *time.Time `firestore:"next_attempt_at,omitempty"` // when a held push goes out
+	CreatedAt      time.Time  `firestore:"created_at"`
+	UpdatedAt      time.Time  `firestore:"updated_at"`
+
+	// The notification, kept while a push is held so the sweep can send it
+	Title   string                 `firestore:"title,omitempty"`
+	Message string                 `firestore:"message,omitempty"`
+	Data    map[string]interface{} `firestore:"data,omitempty"`
+}
+
+// hold keeps the notification on the delivery for SendHeldNotifications to
+// send at until
+func (d *Delivery) hold(n Notification, until time.Time) {
+	d.Status, d.NextAttemptAt = notify.StatusHeld, &until
+	d.Title, d.Message, d.Data = n.Title, n.Message, n.Data
+}
+
+// notification rebuilds the held notification
+func (d Delivery) notification() Notification {
+	return Notification{
+		Event: notify.Event{
+			ID:      d.NotificationID,
+			UserID:  d.UserID,
+			Type:    d.Type,
+			Title:   d.Title,
+			Message: d.Message,
+			Data:    d.Data,
+		},
+		CreatedAt: d.CreatedAt,
+	}
+}
+
+// maxAttempts bounds the deliveries of a failing channel; after that the
+// event is acknowledged so a dead address doesn't hold up Pub/Sub
+const maxAttempts = 5
+
+var (
+	firestoreClient *firestore.Client
+	channels        map[string]Channel
+)
+
+func init() {
+	ctx := context.Background()
+
+	// Initialize Firestore client
+	var err error
+	firestoreClient, err = firestore.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Firestore client: %v", err)
+	}
+
+	channels = newChannels(ctx)
+}
+
+// ProcessNotification is the Cloud Function entry point. It delivers the
+// notification on each channel the user's preferences choose, holding push
+// back during quiet hours, and records every outcome in
+// notification_deliveries. Channels already delivered or held are skipped
+// when the event is redelivered; failures are returned so Pub/Sub retries.
+func ProcessNotification(ctx context.Context, msg pubsub.Message) error {
+	var event notify.Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("Dropping malformed notification event: %v", err)
+		return nil
+	}
+	if err := event.Validate(); err != nil {
+		log.Printf("Dropping notification event: %v", err)
+		return nil
+	}
+
+	n := Notification{Event: event, CreatedAt: time.Now()}
+	if n.ID == "" {
+		// Pub/Sub keeps the event ID across redeliveries
+		if meta, err := metadata.FromContext(ctx); err == nil {
+			n.ID = meta.EventID
+		}
+	}
+	if n.ID == "" {
+		n.ID = fmt.Sprintf("%d", n.CreatedAt.UnixNano())
+	}
+
+	to, err := loadRecipient(ctx, event.UserID)
+	if err != nil {
+		log.Printf("Failed to load user %s: %v", event.UserID, err)
+		return err
+	}
+
+	var failed []string
+	for _, channel := range to.Preferences.Notifications.ChannelsFor(event.Type) {
+		if err := deliver(ctx, to, n, channel); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", channel, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("notification %s not delivered on %s", n.ID, strings.Join(failed, "; "))
+	}
+
+	log.Printf("Processed notification %s (%s) for user %s", n.ID, event.Type, event.UserID)
+	return nil
+}
+
+// loadRecipient reads the user's contact details and preferences. Users
+// without a profile get the default preferences.
+func loadRecipient(ctx context.Context, userID string) (Recipient, error) {
+	to := Recipient{ID: userID}
+	doc, err := firestoreClient.Collection("users").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return to, nil
+	}
+	if err != nil {
+		return to, err
+	}
+	if err := doc.DataTo(&to); err != nil {
+		log.Printf("Unreadable profile for user %s, using default preferences: %v", userID, err)
+		to = Recipient{}
+	}
+	to.ID = userID
+	return to, nil
+}
+
+// deliver sends the notification on one channel, or holds it for quiet
+// hours, and records the outcome. It returns an error when the channel
+// failed and is worth retrying.
+func deliver(ctx context.Context, to Recipient, n Notification, channel string) error {
+	ref := firestoreClient.Collection("notification_deliveries").Doc(n.ID + "_" + channel)
+	now := time.Now()
+	delivery := Delivery{
+		ID:             ref.ID,
+		NotificationID: n.ID,
+		UserID:         to.ID,
+		Type:           n.Type,
+		Channel:        channel,
+		CreatedAt:      now,
+	}
+
+	doc, err := ref.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	if err == nil {
+		if err := doc.DataTo(&delivery); err != nil {
+			return err
+		}
+		// Held pushes are sent by SendHeldNotifications
+		if delivery.Status != notify.StatusFailed || delivery.Attempts >= maxAttempts {
+			return nil
+		}
+	}
+
+	var sendErr error
+	if until, held := to.Preferences.Notifications.Held(channel, now); held {
+		delivery.hold(n, until)
+		delivery.Error = ""
+	} else {
+		sendErr = send(ctx, to, n, &delivery)
+	}
+	delivery.UpdatedAt = now
+
+	if _, err := ref.Set(ctx, delivery); err != nil {
+		log.Printf("Failed to record %s delivery of notification %s: %v", channel, n.ID, err)
+		if delivery.Status == notify.StatusHeld {
+			// Unrecorded, the held push would never go out
+			return err
+		}
+	}
+	if sendErr != nil && delivery.Attempts >= maxAttempts {
+		log.Printf("Giving up on %s delivery of notification %s after %d attempts: %v", channel, n.ID, delivery.Attempts, sendErr)
+		return nil
+	}
+	return sendErr
+}
+
+// send sends the notification on the delivery's channel and sets the
+// delivery's status. It returns the channel's error when it failed.
+func send(ctx context.Context, to Recipient, n Notification, delivery *Delivery) error {
+	delivery.NextAttemptAt = nil
+	delivery.Title, delivery.Message, delivery.Data = "", "", nil
+	ch := channels[delivery.Channel]
+	delivery.Attempts++
+	err := ch.Send(ctx, to, n)
+	switch _, stub := ch.(stubChannel); {
+	case errors.Is(err, errNoAddress):
+		delivery.Status, delivery.Error = notify.StatusSkipped, err.Error()
+		return nil
+	case err != nil:
+		delivery.Status, delivery.Error = notify.StatusFailed, err.Error()
+	case stub:
+		delivery.Status, delivery.Error = notify.StatusStubbed, ""
+	default:
+		delivery.Status, delivery.Error = notify.StatusSent, ""
+	}
+	return err
+}
+
+// SendHeldNotifications is the entry point for the sweep Cloud Scheduler
+// publishes to notification-sweeps. It sends the pushes held for quiet hours
+// that are due. A push that fails stays held for the next sweep, up to
+// maxAttempts.
+func SendHeldNotifications(ctx context.Context, _ pubsub.Message) error {
+	now := time.Now()
+	docs, err := firestoreClient.Collection("notification_deliveries").
+		Where("status", "==", notify.StatusHeld).
+		Where("next_attempt_at", "<=", now).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query held notifications: %v", err)
+	}
+
+	sent := 0
+	for _, doc := range docs {
+		var delivery Delivery
+		if err := doc.DataTo(&delivery); err != nil {
+			log.Printf("Skipping unreadable delivery %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		to, err := loadRecipient(ctx, delivery.UserID)
+		if err != nil {
+			log.Printf("Failed to load user %s: %v", delivery.UserID, err)
+			continue
+		}
+
+		n := delivery.notification()
+		if until, held := to.Preferences.Notifications.Held(delivery.Channel, now); held {
+			// The user's quiet hours changed since the push was held
+			delivery.NextAttemptAt = &until
+		} else if err := send(ctx, to, n, &delivery); err == nil {
+			sent++
+		} else if delivery.Attempts < maxAttempts {
+			delivery.hold(n, now)
+		} else {
+			log.Printf("Giving up on %s delivery of notification %s after %d attempts: %v", delivery.Channel, n.ID, delivery.Attempts, err)
+		}
+		delivery.UpdatedAt = now
+
+		if _, err := doc.Ref.Set(ctx, delivery); err != nil {
+			log.Printf("Failed to record %s delivery of notification %s: %v", delivery.Channel, n.ID, err)
+		}
+	}
+
+	log.Printf("Sent %d of %d held notifications", sent, len(docs))
+	return nil
+}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/vertexai/genai"
 	"google.golang.org/api/option"
+
+	"raseed-shared/config"
+	"raseed-shared/wallet"
+	"raseed-shared/webhooks"
 )
 
 // QueryProcessingEvent represents the event data from Pub/Sub
@@ -33,7 +39,9 @@ type QueryResponse struct {
 
 var (
 	firestoreClient *firestore.Client
+	pubsubClient    *pubsub.Client
 	vertexClient    *genai.Client
+	runtimeConfig   *config.Store
 )
 
 func init() {
@@ -46,11 +54,20 @@ func init() {
 		log.Fatalf("Failed to create Firestore client: %v", err)
 	}
 
+	// Initialize Pub/Sub client
+	pubsubClient, err = pubsub.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Pub/Sub client: %v", err)
+	}
+
 	// Initialize Vertex AI client
 	vertexClient, err = genai.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"), option.WithLocation("us-central1"))
 	if err != nil {
 		log.Fatalf("Failed to create Vertex AI client: %v", err)
 	}
+
+	// Load runtime configuration from system_config
+	runtimeConfig = config.NewFirestore(ctx, firestoreClient)
 }
 
 // ProcessQuery is the Cloud Function entry point
@@ -69,8 +86,15 @@ func ProcessQuery(ctx context.Context, msg pubsub.Message) error {
 		return err
 	}
 
+	// Get price history so the AI can compare stores
+	userPrices, err := getUserPrices(ctx, event.UserID)
+	if err != nil {
+		log.Printf("Failed to get user prices: %v", err)
+		return err
+	}
+
 	// Process query with AI
-	response, err := processQueryWithAI(ctx, event.Query, event.Language, userReceipts)
+	response, err := processQueryWithAI(ctx, event.Query, event.Language, userReceipts, createPriceContext(userPrices, time.Now()))
 	if err != nil {
 		log.Printf("Failed to process query with AI: %v", err)
 		return err
@@ -83,13 +107,37 @@ func ProcessQuery(ctx context.Context, msg pubsub.Message) error {
 		return err
 	}
 
-	// Create wallet pass if needed
-	if shouldCreateWalletPass(response.Intent) {
-		err = createQueryWalletPass(ctx, event.UserID, event.QueryID, response)
+	// Save suggested items as a real shopping list
+	var list *ShoppingList
+	if response.Intent == "shopping_list" {
+		list, err = createShoppingList(ctx, event.UserID, event.QueryID, response)
 		if err != nil {
-			log.Printf("Failed to create wallet pass: %v", err)
+			log.Printf("Failed to create shopping list: %v", err)
 			return err
 		}
+		if list != nil {
+			if response.Data == nil {
+				response.Data = map[string]interface{}{}
+			}
+			response.Data["list_id"] = list.ID
+		}
+	}
+
+	// Let the user's webhooks know the query has been answered
+	if err := webhooks.Publish(ctx, pubsubClient.Topic(webhooks.Topic), queryAnsweredEvent(event, response)); err != nil {
+		log.Printf("Failed to publish query.answered: %v", err)
+	}
+
+	// Create wallet pass if needed; a saved shopping list gets its own
+	switch {
+	case list != nil:
+		err = createShoppingListWalletPass(ctx, *list)
+	case shouldCreateWalletPass(response.Intent):
+		err = createQueryWalletPass(ctx, event.UserID, event.QueryID, response)
+	}
+	if err != nil {
+		log.Printf("Failed to create wallet pass: %v", err)
+		return err
 	}
 
 	log.Printf("Successfully processed query %s", event.QueryID)
@@ -111,8 +159,8 @@ func getUserReceipts(ctx context.Context, userID string) ([]map[string]interface
 	return receipts, nil
 }
 
-func processQueryWithAI(ctx context.Context, query, language string, receipts []map[string]interface{}) (*QueryResponse, error) {
-	model := vertexClient.GenerativeModel("gemini-pro")
+func processQueryWithAI(ctx context.Context, query, language string, receipts []map[string]interface{}, priceContext string) (*QueryResponse, error) {
+	model := vertexClient.GenerativeModel(runtimeConfig.Get(ctx).QueryModel)
 	
 	// Create context from user's receipts
 	receiptContext := createReceiptContext(receipts)
@@ -121,6 +169,10 @@ func processQueryWithAI(ctx context.Context, query, language string, receipts []
 
 User's Receipt History:
 %s
+%s
+Tags are labels the user attached to receipts or items (e.g. trip-goa, business). Use the tag totals to answer questions about a trip, project or other tagged spending.
+
+Prices by store are unit prices per kg, litre, piece or item. Use them to answer which store is cheapest for an item and how its price has changed.
 
 User Query (Language: %s): %s
 
@@ -135,11 +187,13 @@ Respond in JSON format:
 	"data": {
 		"relevant_items": ["item1", "item2"],
 		"total_spent": 0.00,
-		"category_breakdown": {"category": "amount"}
+		"category_breakdown": {"category": "amount"},
+		"list_name": "Name for the shopping list (shopping_list intent only)",
+		"shopping_items": [{"name": "item", "quantity": 1, "unit": "", "category": "category"}]
 	}
 }
 
-Focus on being helpful, actionable, and personalized based on the user's receipt history.`, receiptContext, language, query)
+Focus on being helpful, actionable, and personalized based on the user's receipt history.`, receiptContext, priceContext, language, query)
 
 	// Generate content
 	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
@@ -181,9 +235,15 @@ func createReceiptContext(receipts []map[string]interface{}) string {
 		
 		storeName, _ := receipt["store_name"].(string)
 		totalAmount, _ := receipt["total_amount"].(float64)
-		date, _ := receipt["date"].(string)
+		date := receiptDate(receipt["date"])
 		
 		context += fmt.Sprintf("- %s: $%.2f on %s\n", storeName, totalAmount, date)
+		if tags := stringList(receipt["tags"]); len(tags) > 0 {
+			context += fmt.Sprintf("  Tags: %s\n", strings.Join(tags, ", "))
+		}
+		if notes, _ := receipt["notes"].(string); notes != "" {
+			context += fmt.Sprintf("  Notes: %s\n", notes)
+		}
 		
 		// Add items if available
 		if items, ok := receipt["items"].([]interface{}); ok {
@@ -191,15 +251,87 @@ func createReceiptContext(receipts []map[string]interface{}) string {
 				if itemMap, ok := item.(map[string]interface{}); ok {
 					name, _ := itemMap["name"].(string)
 					category, _ := itemMap["category"].(string)
-					context += fmt.Sprintf("  * %s (%s)\n", name, category)
+					context += fmt.Sprintf("  * %s (%s)", name, category)
+					if tags := stringList(itemMap["tags"]); len(tags) > 0 {
+						context += fmt.Sprintf(" [%s]", strings.Join(tags, ", "))
+					}
+					context += "\n"
 				}
 			}
 		}
 	}
+
+	// Tag totals cover the full history so questions like "how much did
+	// trip-goa cost?" can be answered beyond the receipts listed above
+	if totals := tagTotals(receipts); len(totals) > 0 {
+		tags := make([]string, 0, len(totals))
+		for tag := range totals {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+
+		context += "\nSpending by Tag:\n"
+		for _, tag := range tags {
+			context += fmt.Sprintf("- %s: $%.2f\n", tag, totals[tag])
+		}
+	}
 	
 	return context
 }
 
+// receiptDate formats a receipt's date. Processed receipts store it as a
+// timestamp; older documents hold the date as read, a string.
+func receiptDate(v interface{}) string {
+	switch date := v.(type) {
+	case time.Time:
+		return date.Format("2006-01-02")
+	case string:
+		return date
+	}
+	return ""
+}
+
+// tagTotals sums spending per tag. A tagged receipt counts in full; otherwise
+// only its tagged items count towards the tag.
+func tagTotals(receipts []map[string]interface{}) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, receipt := range receipts {
+		receiptTags := make(map[string]bool)
+		for _, tag := range stringList(receipt["tags"]) {
+			receiptTags[tag] = true
+			totalAmount, _ := receipt["total_amount"].(float64)
+			totals[tag] += totalAmount
+		}
+
+		items, _ := receipt["items"].([]interface{})
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			price, _ := itemMap["price"].(float64)
+			quantity, _ := itemMap["quantity"].(int64)
+			for _, tag := range stringList(itemMap["tags"]) {
+				if !receiptTags[tag] {
+					totals[tag] += price * float64(quantity)
+				}
+			}
+		}
+	}
+	return totals
+}
+
+func stringList(v interface{}) []string {
+	raw, _ := v.([]interface{})
+	list := make([]string, 0, len(raw))
+	for _, entry := range raw {
+		if s, ok := entry.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
 func updateQueryDocument(ctx context.Context, queryID string, response *QueryResponse) error {
 	_, err := firestoreClient.Collection("queries").Doc(queryID).Update(ctx, []firestore.Update{
 		{Path: "response", Value: response.Response},
@@ -209,6 +341,24 @@ func updateQueryDocument(ctx context.Context, queryID string, response *QueryRes
 	return err
 }
 
+// queryAnsweredEvent is the webhook event for an answered query
+func queryAnsweredEvent(event QueryProcessingEvent, response *QueryResponse) webhooks.Event {
+	return webhooks.Event{
+		ID:     webhooks.EventID(webhooks.QueryAnswered, event.QueryID),
+		Type:   webhooks.QueryAnswered,
+		UserID: event.UserID,
+		Data: map[string]interface{}{
+			"query_id":    event.QueryID,
+			"query":       event.Query,
+			"language":    event.Language,
+			"response":    response.Response,
+			"intent":      response.Intent,
+			"suggestions": response.Suggestions,
+			"data":        response.Data,
+		},
+	}
+}
+
 func shouldCreateWalletPass(intent string) bool {
 	walletPassIntents := []string{"cooking_suggestion", "shopping_list", "financial_insight"}
 	for _, validIntent := range walletPassIntents {
@@ -220,19 +370,6 @@ func shouldCreateWalletPass(intent string) bool {
 }
 
 func createQueryWalletPass(ctx context.Context, userID, queryID string, response *QueryResponse) error {
-	// Create wallet pass data
-	passData := map[string]interface{}{
-		"query_id":    queryID,
-		"intent":      response.Intent,
-		"suggestions": response.Suggestions,
-		"data":        response.Data,
-	}
-
-	passDataJSON, err := json.Marshal(passData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal pass data: %v", err)
-	}
-
 	// Determine pass type and title based on intent
 	passType := "insight"
 	title := "Financial Insight"
@@ -249,17 +386,24 @@ func createQueryWalletPass(ctx context.Context, userID, queryID string, response
 		title = "Financial Insight"
 	}
 
-	// Create wallet pass document
-	pass := map[string]interface{}{
-		"id":          fmt.Sprintf("query_%s", queryID),
-		"user_id":     userID,
-		"type":        passType,
-		"title":       title,
-		"description": response.Response[:100] + "...", // Truncate if too long
-		"data":        string(passDataJSON),
-		"created_at":  firestore.ServerTimestamp,
+	description := response.Response
+	if len(description) > 100 {
+		description = description[:100] + "..."
 	}
 
-	_, err = firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("query_%s", queryID)).Set(ctx, pass)
-	return err
+	pass, err := wallet.NewPass(fmt.Sprintf("query_%s", queryID), userID, passType, title, description,
+		&wallet.InsightPayload{
+			QueryID:     queryID,
+			Intent:      response.Intent,
+			Suggestions: response.Suggestions,
+			Details:     response.Data,
+		})
+	if err != nil {
+		return fmt.Errorf("invalid query pass: %v", err)
+	}
+
+	if _, err := firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass); err != nil {
+		return err
+	}
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("created", ""))
 } 
\ No newline at end of file
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"raseed-shared/prices"
+)
+
+// recentPriceWindow is how recently a store must have been visited to be
+// suggested as the cheapest
+const recentPriceWindow = 90 * 24 * time.Hour
+
+// maxPricedItems limits how many products are described in the prompt
+const maxPricedItems = 20
+
+func getUserPrices(ctx context.Context, userID string) ([]prices.Point, error) {
+	iter := firestoreClient.Collection("price_points").Where("user_id", "==", userID).Documents(ctx)
+	var points []prices.Point
+
+	for {
+		doc, err := iter.Next()
+		if err != nil {
+			break
+		}
+		var point prices.Point
+		if err := doc.DataTo(&point); err != nil {
+			continue
+		}
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// createPriceContext describes the cheapest recent store and price trend of
+// products bought at more than one store, most frequently bought first, so
+// questions like "where is milk cheapest?" can be answered
+func createPriceContext(points []prices.Point, now time.Time) string {
+	byItem := make(map[string][]prices.Point)
+	for _, point := range points {
+		byItem[point.NormalizedName] = append(byItem[point.NormalizedName], point)
+	}
+
+	var summaries []prices.Summary
+	for _, itemPoints := range byItem {
+		summary := prices.Summarize(itemPoints, now, recentPriceWindow)
+		if len(summary.Stores) > 1 && summary.Cheapest != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+	if len(summaries) == 0 {
+		return ""
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		ni, nj := len(byItem[summaries[i].NormalizedName]), len(byItem[summaries[j].NormalizedName])
+		if ni != nj {
+			return ni > nj
+		}
+		return summaries[i].NormalizedName < summaries[j].NormalizedName
+	})
+	if len(summaries) > maxPricedItems {
+		summaries = summaries[:maxPricedItems]
+	}
+
+	context := "Prices by Store:\n"
+	for _, s := range summaries {
+		others := make([]string, 0, len(s.Stores))
+		for _, store := range s.Stores {
+			if store.Store != s.Cheapest.Store {
+				others = append(others, fmt.Sprintf("%s $%.2f", store.Store, store.LatestUnitPrice))
+			}
+		}
+		context += fmt.Sprintf("- %s: cheapest recently at %s ($%.2f/%s on %s); also %s; %+.1f%% since first bought\n",
+			s.Item, s.Cheapest.Store, s.Cheapest.LatestUnitPrice, s.Unit,
+			s.Cheapest.LatestDate.Format("2006-01-02"), strings.Join(others, ", "), s.ChangePercent)
+	}
+	return context
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/catalog"
+	"raseed-shared/wallet"
+)
+
+// ShoppingList mirrors the backend's shopping_lists documents
+type ShoppingList struct {
+	ID         string             `firestore:"id"`
+	Name       string             `firestore:"name"`
+	OwnerID    string             `firestore:"owner_id"`
+	SharedWith []string           `firestore:"shared_with"`
+	MemberIDs  []string           `firestore:"member_ids"`
+	Items      []ShoppingListItem `firestore:"items"`
+	Source     string             `firestore:"source"`
+	QueryID    string             `firestore:"query_id"`
+	CreatedAt  time.Time          `firestore:"created_at"`
+	UpdatedAt  time.Time          `firestore:"updated_at"`
+}
+
+// ShoppingListItem is one entry on a shopping list
+type ShoppingListItem struct {
+	ID             string    `firestore:"id"`
+	Name           string    `firestore:"name"`
+	NormalizedName string    `firestore:"normalized_name"`
+	Quantity       int       `firestore:"quantity"`
+	Unit           string    `firestore:"unit"`
+	Category       string    `firestore:"category"`
+	Checked        bool      `firestore:"checked"`
+	CheckedAt      time.Time `firestore:"checked_at"`
+	AddedBy        string    `firestore:"added_by"`
+	AddedAt        time.Time `firestore:"added_at"`
+}
+
+// createShoppingList saves the items suggested for a shopping_list query as a
+// list the user can check off in the app, and returns it, or nil when the
+// response has no items. The list ID is derived from the query, so a
+// redelivered message finds the list already there and leaves the user's
+// check-offs alone.
+func createShoppingList(ctx context.Context, userID, queryID string, response *QueryResponse) (*ShoppingList, error) {
+	now := time.Now()
+	items := suggestedItems(response.Data, now)
+	if len(items) == 0 {
+		log.Printf("Query %s had a shopping_list intent but no items", queryID)
+		return nil, nil
+	}
+
+	name, _ := response.Data["list_name"].(string)
+	if strings.TrimSpace(name) == "" {
+		name = "Shopping List"
+	}
+
+	list := ShoppingList{
+		ID:         fmt.Sprintf("query_%s", queryID),
+		Name:       name,
+		OwnerID:    userID,
+		SharedWith: []string{},
+		MemberIDs:  []string{userID},
+		Items:      items,
+		Source:     "query",
+		QueryID:    queryID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	_, err := firestoreClient.Collection("shopping_lists").Doc(list.ID).Create(ctx, list)
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// createShoppingListWalletPass issues the list's pass. It carries the list ID,
+// so the backend expires it once everything on the list has been bought.
+func createShoppingListWalletPass(ctx context.Context, list ShoppingList) error {
+	names := make([]string, len(list.Items))
+	for i, item := range list.Items {
+		names[i] = item.Name
+	}
+
+	pass, err := wallet.NewPass(fmt.Sprintf("shopping_list_%s", list.ID), list.OwnerID, "shopping_list",
+		list.Name, fmt.Sprintf("%d items to buy", len(names)),
+		&wallet.ShoppingListPayload{
+			ListID:    list.ID,
+			Name:      list.Name,
+			Items:     names,
+			ItemCount: len(names),
+		})
+	if err != nil {
+		return fmt.Errorf("invalid shopping list pass: %v", err)
+	}
+
+	_, err = firestoreClient.Collection("wallet_passes").Doc(pass.ID).Create(ctx, pass)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("created", ""))
+}
+
+// suggestedItems reads the AI's shopping_items, falling back to the plain
+// relevant_items names, and merges duplicates by normalized name
+func suggestedItems(data map[string]interface{}, now time.Time) []ShoppingListItem {
+	var items []ShoppingListItem
+	index := make(map[string]int)
+
+	add := func(name, unit, category string, quantity int) {
+		key := catalog.NormalizeName(name)
+		if key == "" {
+			return
+		}
+		if quantity <= 0 {
+			quantity = 1
+		}
+		if i, ok := index[key]; ok {
+			items[i].Quantity += quantity
+			return
+		}
+		index[key] = len(items)
+		items = append(items, ShoppingListItem{
+			ID:             fmt.Sprintf("%d_%d", now.UnixNano(), len(items)),
+			Name:           strings.TrimSpace(name),
+			NormalizedName: key,
+			Quantity:       quantity,
+			Unit:           unit,
+			Category:       category,
+			AddedBy:        "raseed",
+			AddedAt:        now,
+		})
+	}
+
+	if raw, ok := data["shopping_items"].([]interface{}); ok {
+		for _, entry := range raw {
+			switch v := entry.(type) {
+			case map[string]interface{}:
+				name, _ := v["name"].(string)
+				unit, _ := v["unit"].(string)
+				category, _ := v["category"].(string)
+				quantity, _ := v["quantity"].(float64)
+				add(name, unit, category, int(quantity))
+			case string:
+				add(v, "", "", 1)
+			}
+		}
+	}
+	if len(items) == 0 {
+		for _, name := range stringList(data["relevant_items"]) {
+			add(name, "", "", 1)
+		}
+	}
+	return items
+}
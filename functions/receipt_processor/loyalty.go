@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/loyalty"
+	"raseed-shared/wallet"
+)
+
+// LoyaltyMembership is a loyalty program membership printed on a receipt
+type LoyaltyMembership struct {
+	Program       string   `json:"program"`
+	MemberID      string   `json:"member_id"`      // as printed, possibly masked
+	PointsBalance *float64 `json:"points_balance"` // after the purchase; null when not printed
+}
+
+// recordLoyaltyCard saves the loyalty card printed on a receipt and issues
+// its pass, or updates the points balance of a card the user already has.
+// Receipts often mask the member number; those only update a saved card of
+// the same merchant whose number ends with the digits shown.
+func recordLoyaltyCard(ctx context.Context, userID, receiptID string, data *ExtractedReceiptData) error {
+	membership := data.Loyalty
+	if membership == nil || strings.TrimSpace(membership.MemberID) == "" {
+		return nil
+	}
+	if data.MerchantID == "" {
+		// Cards belong to a merchant; without one there's nothing to link to
+		return nil
+	}
+
+	memberID := loyalty.NormalizeMemberID(membership.MemberID)
+	masked := loyalty.Masked(memberID)
+	asOf, err := time.Parse("2006-01-02", data.Date)
+	if err != nil {
+		asOf = time.Now()
+	}
+
+	cards := firestoreClient.Collection("loyalty_cards")
+	query := cards.Where("user_id", "==", userID).Where("merchant_id", "==", data.MerchantID)
+	if !masked {
+		query = query.Where("member_id", "==", memberID).Limit(1)
+	}
+
+	var pass wallet.Pass
+	var action, objectID string
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		action, objectID = "", ""
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var matches []loyalty.Card
+		for _, doc := range docs {
+			var card loyalty.Card
+			if err := doc.DataTo(&card); err != nil {
+				return err
+			}
+			if !masked || loyalty.MatchesMasked(memberID, card.MemberID) {
+				matches = append(matches, card)
+			}
+		}
+
+		if len(matches) == 0 {
+			if masked {
+				return nil
+			}
+			card := loyalty.Card{
+				ID:           fmt.Sprintf("%d", now.UnixNano()),
+				UserID:       userID,
+				Program:      strings.TrimSpace(membership.Program),
+				MemberID:     memberID,
+				MerchantID:   data.MerchantID,
+				MerchantName: data.StoreName,
+				Source:       loyalty.Receipt,
+				ReceiptID:    receiptID,
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			}
+			if card.Program == "" {
+				card.Program = data.StoreName
+			}
+			if membership.PointsBalance != nil {
+				card.RecordPoints(*membership.PointsBalance, asOf)
+			}
+			if pass, err = loyaltyPass(ctx, card); err != nil {
+				return err
+			}
+			if err := tx.Create(cards.Doc(card.ID), card); err != nil {
+				return err
+			}
+			action = "created"
+			return tx.Set(firestoreClient.Collection("wallet_passes").Doc(pass.ID), pass)
+		}
+		if len(matches) > 1 {
+			// A masked number that fits several cards can't say which
+			return nil
+		}
+
+		card := matches[0]
+		if membership.PointsBalance == nil || !card.RecordPoints(*membership.PointsBalance, asOf) {
+			return nil
+		}
+		card.ReceiptID, card.UpdatedAt = receiptID, now
+		if pass, err = loyaltyPass(ctx, card); err != nil {
+			return err
+		}
+
+		passRef := firestoreClient.Collection("wallet_passes").Doc(pass.ID)
+		passDoc, err := tx.Get(passRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err := tx.Set(cards.Doc(card.ID), card); err != nil {
+			return err
+		}
+		if passDoc == nil || !passDoc.Exists() {
+			// The pass was deleted; the user can add it again from the card
+			return nil
+		}
+		if object, err := passDoc.DataAt("wallet_object_id"); err == nil {
+			objectID, _ = object.(string)
+		}
+		action = "updated"
+		return tx.Update(passRef, []firestore.Update{
+			{Path: "description", Value: pass.Description},
+			{Path: "payload", Value: pass.Payload},
+			{Path: "updated_at", Value: now},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if action != "" {
+		log.Printf("Loyalty card %s from receipt %s: %s", pass.ID, receiptID, action)
+		return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event(action, objectID))
+	}
+	return nil
+}
+
+// loyaltyPass is the card's pass, with the member number as its barcode
+func loyaltyPass(ctx context.Context, card loyalty.Card) (wallet.Pass, error) {
+	pass, err := card.Pass()
+	if err != nil {
+		return pass, fmt.Errorf("invalid loyalty card: %v", err)
+	}
+	cfg := runtimeConfig.Get(ctx)
+	pass.Barcode = wallet.DefaultBarcode(pass, cfg.BarcodeType, cfg.AppLinkBase)
+	return pass, nil
+}
@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/vertexai/genai"
 	"google.golang.org/api/option"
+
+	"raseed-shared/config"
+	"raseed-shared/merchants"
+	"raseed-shared/wallet"
+	"raseed-shared/webhooks"
 )
 
 // ReceiptProcessingEvent represents the event data from Pub/Sub
@@ -22,24 +28,36 @@ type ReceiptProcessingEvent struct {
 
 // ExtractedReceiptData represents the data extracted from receipt
 type ExtractedReceiptData struct {
-	StoreName   string  `json:"store_name"`
-	TotalAmount float64 `json:"total_amount"`
-	TaxAmount   float64 `json:"tax_amount"`
-	Items       []Item  `json:"items"`
-	Date        string  `json:"date"`
+	StoreName    string             `json:"store_name"`
+	TotalAmount  float64            `json:"total_amount"`
+	TaxAmount    float64            `json:"tax_amount"`
+	TaxBreakdown map[string]float64 `json:"tax_breakdown"`
+	Items        []Item             `json:"items"`
+	Date         string             `json:"date"`
+	Loyalty      *LoyaltyMembership `json:"loyalty"` // null when the receipt shows none
+
+	// Set from the merchant directory, not by the model
+	MerchantID   string `json:"-"`
+	RawStoreName string `json:"-"`
 }
 
 // Item represents an item in a receipt
 type Item struct {
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
-	Category string  `json:"category"`
+	Name     string  `json:"name" firestore:"name"`
+	Price    float64 `json:"price" firestore:"price"`
+	Quantity int     `json:"quantity" firestore:"quantity"`
+	Category string  `json:"category" firestore:"category"`
+
+	WarrantyMonths int `json:"warranty_months,omitempty" firestore:"warranty_months,omitempty"`
+	ReturnDays     int `json:"return_days,omitempty" firestore:"return_days,omitempty"`
 }
 
 var (
 	firestoreClient *firestore.Client
+	pubsubClient    *pubsub.Client
 	vertexClient    *genai.Client
+	runtimeConfig   *config.Store
+	merchantDir     *merchants.Directory
 )
 
 func init() {
@@ -52,11 +70,22 @@ func init() {
 		log.Fatalf("Failed to create Firestore client: %v", err)
 	}
 
+	// Initialize Pub/Sub client
+	pubsubClient, err = pubsub.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Pub/Sub client: %v", err)
+	}
+
 	// Initialize Vertex AI client
 	vertexClient, err = genai.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"), option.WithLocation("us-central1"))
 	if err != nil {
 		log.Fatalf("Failed to create Vertex AI client: %v", err)
 	}
+
+	// Load runtime configuration from system_config
+	runtimeConfig = config.NewFirestore(ctx, firestoreClient)
+
+	merchantDir = merchants.NewDirectory(firestoreClient)
 }
 
 // ProcessReceipt is the Cloud Function entry point
@@ -75,6 +104,12 @@ func ProcessReceipt(ctx context.Context, msg pubsub.Message) error {
 		return err
 	}
 
+	// Replace the store name as read with the canonical merchant name
+	resolveMerchant(ctx, extractedData)
+
+	// Fill in usual warranty and return terms the model didn't suggest
+	suggestTerms(extractedData)
+
 	// Update receipt document in Firestore
 	err = updateReceiptDocument(ctx, event.ReceiptID, extractedData)
 	if err != nil {
@@ -82,6 +117,33 @@ func ProcessReceipt(ctx context.Context, msg pubsub.Message) error {
 		return err
 	}
 
+	// Let the user's webhooks know the receipt has been read
+	if err := webhooks.Publish(ctx, pubsubClient.Topic(webhooks.Topic), receiptExtractedEvent(event.UserID, event.ReceiptID, extractedData)); err != nil {
+		log.Printf("Failed to publish receipt.extracted: %v", err)
+	}
+
+	// Add grocery items to the pantry unless the receipt opted out
+	err = stockPantry(ctx, event.ReceiptID, extractedData)
+	if err != nil {
+		log.Printf("Failed to stock pantry: %v", err)
+		return err
+	}
+
+	// Track return windows and warranties, with a proof-of-purchase pass each
+	if err := trackWarranties(ctx, event.ReceiptID, extractedData); err != nil {
+		log.Printf("Failed to track warranties: %v", err)
+	}
+
+	// Save the loyalty card printed on the receipt, or its new points balance
+	if err := recordLoyaltyCard(ctx, event.UserID, event.ReceiptID, extractedData); err != nil {
+		log.Printf("Failed to record loyalty card: %v", err)
+	}
+
+	// Record item prices for price history and store comparison
+	if err := indexPrices(ctx, event.ReceiptID, extractedData); err != nil {
+		log.Printf("Failed to index prices: %v", err)
+	}
+
 	// Create wallet pass for the receipt
 	err = createReceiptWalletPass(ctx, event.UserID, event.ReceiptID, extractedData)
 	if err != nil {
@@ -94,25 +156,36 @@ func ProcessReceipt(ctx context.Context, msg pubsub.Message) error {
 }
 
 func extractReceiptData(ctx context.Context, imageURL string) (*ExtractedReceiptData, error) {
-	model := vertexClient.GenerativeModel("gemini-pro-vision")
+	model := vertexClient.GenerativeModel(runtimeConfig.Get(ctx).ReceiptModel)
 	
 	prompt := `Analyze this receipt image and extract the following information in JSON format:
 	{
 		"store_name": "Store name",
 		"total_amount": 0.00,
 		"tax_amount": 0.00,
+		"tax_breakdown": {"CGST": 0.00, "SGST": 0.00},
 		"items": [
 			{
 				"name": "Item name",
 				"price": 0.00,
 				"quantity": 1,
-				"category": "Category (e.g., groceries, electronics, etc.)"
+				"category": "Category (e.g., groceries, electronics, etc.)",
+				"warranty_months": 0,
+				"return_days": 0
 			}
 		],
-		"date": "YYYY-MM-DD"
+		"date": "YYYY-MM-DD",
+		"loyalty": {
+			"program": "Loyalty program name",
+			"member_id": "Member number",
+			"points_balance": 0
+		}
 	}
 	
-	Please ensure all monetary values are numbers, quantities are integers, and categorize items appropriately.`
+	Please ensure all monetary values are numbers, quantities are integers, and categorize items appropriately.
+	List each tax printed on the receipt in tax_breakdown by its name (CGST, SGST, IGST or CESS on Indian GST receipts; VAT or Sales Tax elsewhere), or leave it empty when only a total is printed.
+	For electronics, appliances and other durable goods, set warranty_months to the usual manufacturer warranty and return_days to the store's return window if it is printed on the receipt or commonly known; otherwise use 0.
+	If the receipt shows a loyalty or membership program, set loyalty.program to its name, loyalty.member_id to the member or card number exactly as printed, including any masking like XXXX1234, and loyalty.points_balance to the points balance after this purchase, or null when no balance is printed. Set loyalty to null when no membership is shown.`
 
 	// Create image part
 	img := genai.ImageData{
@@ -144,54 +217,95 @@ func extractReceiptData(ctx context.Context, imageURL string) (*ExtractedReceipt
 }
 
 func updateReceiptDocument(ctx context.Context, receiptID string, data *ExtractedReceiptData) error {
-	// Convert ExtractedReceiptData to Firestore document
-	docData := map[string]interface{}{
-		"store_name":   data.StoreName,
-		"total_amount": data.TotalAmount,
-		"tax_amount":   data.TaxAmount,
-		"items":        data.Items,
-		"date":         data.Date,
-		"updated_at":   firestore.ServerTimestamp,
-	}
-
-	_, err := firestoreClient.Collection("receipts").Doc(receiptID).Update(ctx, []firestore.Update{
+	updates := []firestore.Update{
 		{Path: "store_name", Value: data.StoreName},
 		{Path: "total_amount", Value: data.TotalAmount},
 		{Path: "tax_amount", Value: data.TaxAmount},
 		{Path: "items", Value: data.Items},
-		{Path: "date", Value: data.Date},
 		{Path: "updated_at", Value: firestore.ServerTimestamp},
-	})
+	}
+	if len(data.TaxBreakdown) > 0 {
+		updates = append(updates, firestore.Update{Path: "tax_breakdown", Value: data.TaxBreakdown})
+	}
+	if data.MerchantID != "" {
+		updates = append(updates,
+			firestore.Update{Path: "merchant_id", Value: data.MerchantID},
+			firestore.Update{Path: "raw_store_name", Value: data.RawStoreName},
+		)
+	}
+
+	// Store the date as a timestamp; keep the upload time if it can't be read
+	if date, err := time.Parse("2006-01-02", data.Date); err == nil {
+		updates = append(updates, firestore.Update{Path: "date", Value: date})
+	}
+
+	_, err := firestoreClient.Collection("receipts").Doc(receiptID).Update(ctx, updates)
 
 	return err
 }
 
 func createReceiptWalletPass(ctx context.Context, userID, receiptID string, data *ExtractedReceiptData) error {
-	// Create wallet pass data
-	passData := map[string]interface{}{
-		"receipt_id":   receiptID,
-		"store_name":   data.StoreName,
-		"total_amount": data.TotalAmount,
-		"items_count":  len(data.Items),
-		"date":         data.Date,
-	}
+	// The date stays unset if it couldn't be read
+	date, _ := time.Parse("2006-01-02", data.Date)
 
-	passDataJSON, err := json.Marshal(passData)
+	pass, err := wallet.NewPass(fmt.Sprintf("receipt_%s", receiptID), userID, "receipt",
+		fmt.Sprintf("Receipt - %s", data.StoreName),
+		fmt.Sprintf("Total: $%.2f, Items: %d", data.TotalAmount, len(data.Items)),
+		&wallet.ReceiptPayload{
+			ReceiptID:   receiptID,
+			StoreName:   data.StoreName,
+			TotalAmount: data.TotalAmount,
+			ItemCount:   len(data.Items),
+			Date:        date,
+		})
 	if err != nil {
-		return fmt.Errorf("failed to marshal pass data: %v", err)
+		return fmt.Errorf("invalid receipt pass: %v", err)
 	}
+	cfg := runtimeConfig.Get(ctx)
+	pass.Barcode = wallet.DefaultBarcode(pass, cfg.BarcodeType, cfg.AppLinkBase)
 
-	// Create wallet pass document
-	pass := map[string]interface{}{
-		"id":          fmt.Sprintf("receipt_%s", receiptID),
-		"user_id":     userID,
-		"type":        "receipt",
-		"title":       fmt.Sprintf("Receipt - %s", data.StoreName),
-		"description": fmt.Sprintf("Total: $%.2f, Items: %d", data.TotalAmount, len(data.Items)),
-		"data":        string(passDataJSON),
-		"created_at":  firestore.ServerTimestamp,
+	if _, err := firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass); err != nil {
+		return err
 	}
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("created", ""))
+} 
 
-	_, err = firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("receipt_%s", receiptID)).Set(ctx, pass)
-	return err
-} 
\ No newline at end of file
+// resolveMerchant looks the store up in the merchant directory. Lookup
+// failures keep the store name as read rather than failing the receipt.
+func resolveMerchant(ctx context.Context, data *ExtractedReceiptData) {
+	categories := make([]string, len(data.Items))
+	for i, item := range data.Items {
+		categories[i] = item.Category
+	}
+
+	merchant, err := merchantDir.Resolve(ctx, data.StoreName, categories)
+	if err != nil {
+		log.Printf("Failed to resolve merchant %q: %v", data.StoreName, err)
+		return
+	}
+	if merchant.ID == "" {
+		return
+	}
+	data.RawStoreName = data.StoreName
+	data.StoreName = merchant.Name
+	data.MerchantID = merchant.ID
+}
+
+// receiptExtractedEvent is the webhook event for a receipt that has been read
+func receiptExtractedEvent(userID, receiptID string, data *ExtractedReceiptData) webhooks.Event {
+	return webhooks.Event{
+		ID:     webhooks.EventID(webhooks.ReceiptExtracted, receiptID),
+		Type:   webhooks.ReceiptExtracted,
+		UserID: userID,
+		Data: map[string]interface{}{
+			"receipt_id":    receiptID,
+			"store_name":    data.StoreName,
+			"merchant_id":   data.MerchantID,
+			"date":          data.Date,
+			"total_amount":  data.TotalAmount,
+			"tax_amount":    data.TaxAmount,
+			"tax_breakdown": data.TaxBreakdown,
+			"items":         data.Items,
+		},
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+
+	"raseed-shared/catalog"
+	"raseed-shared/config"
+	"raseed-shared/shelflife"
+)
+
+// StockItem represents a stock item in inventory
+type StockItem struct {
+	ID              string    `json:"id" firestore:"id"`
+	UserID          string    `json:"user_id" firestore:"user_id"`
+	HouseholdID     string    `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	Name            string    `json:"name" firestore:"name"`
+	NormalizedName  string    `json:"normalized_name" firestore:"normalized_name"`
+	ReceiptID       string    `json:"receipt_id,omitempty" firestore:"receipt_id,omitempty"`
+	Category        string    `json:"category" firestore:"category"`
+	Quantity        int       `json:"quantity" firestore:"quantity"`
+	Unit            string    `json:"unit" firestore:"unit"`
+	Storage         string    `json:"storage" firestore:"storage"`
+	PurchaseDate    time.Time `json:"purchase_date" firestore:"purchase_date"`
+	ExpiryDate      time.Time `json:"expiry_date" firestore:"expiry_date"`
+	ExpiryEstimated bool      `json:"expiry_estimated" firestore:"expiry_estimated"`
+	Status          string    `json:"status" firestore:"status"`
+	CreatedAt       time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// StockManagementEvent is published for every stock item created or updated
+type StockManagementEvent struct {
+	ItemID string `json:"item_id"`
+	UserID string `json:"user_id"`
+	Action string `json:"action"`
+	Status string `json:"status"`
+}
+
+// pantryLine is a grocery item from the receipt, merged by normalized name
+type pantryLine struct {
+	key      string
+	item     Item
+	quantity int
+}
+
+// stockPantry adds the receipt's grocery items to the owner's (or household's)
+// pantry, incrementing existing stock items with the same normalized name. It
+// runs in a transaction and marks the receipt as stocked, so redelivered
+// messages and receipts that opted out are skipped.
+func stockPantry(ctx context.Context, receiptID string, data *ExtractedReceiptData) error {
+	cfg := runtimeConfig.Get(ctx)
+	lines := pantryLines(cfg, data.Items)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	purchaseDate, err := time.Parse("2006-01-02", data.Date)
+	if err != nil {
+		purchaseDate = time.Now()
+	}
+
+	var events []StockManagementEvent
+	receiptRef := firestoreClient.Collection("receipts").Doc(receiptID)
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		events = nil
+
+		doc, err := tx.Get(receiptRef)
+		if err != nil {
+			return err
+		}
+		var receipt struct {
+			UserID      string `firestore:"user_id"`
+			HouseholdID string `firestore:"household_id"`
+			SkipStock   bool   `firestore:"skip_stock"`
+			Stocked     bool   `firestore:"stocked"`
+		}
+		if err := doc.DataTo(&receipt); err != nil {
+			return err
+		}
+		if receipt.SkipStock || receipt.Stocked {
+			return nil
+		}
+
+		// Firestore transactions need every read before the first write
+		existing := make([]*firestore.DocumentSnapshot, len(lines))
+		for i, line := range lines {
+			query := firestoreClient.Collection("stock_items").Where("user_id", "==", receipt.UserID)
+			if receipt.HouseholdID != "" {
+				query = firestoreClient.Collection("stock_items").Where("household_id", "==", receipt.HouseholdID)
+			}
+			docs, err := tx.Documents(query.Where("normalized_name", "==", line.key).Limit(1)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(docs) > 0 {
+				existing[i] = docs[0]
+			}
+		}
+
+		now := time.Now()
+		for i, line := range lines {
+			if existing[i] != nil {
+				var item StockItem
+				if err := existing[i].DataTo(&item); err != nil {
+					return err
+				}
+				item.Quantity += line.quantity
+				if purchaseDate.After(item.PurchaseDate) {
+					item.PurchaseDate = purchaseDate
+				}
+				item.ReceiptID = receiptID
+				item.UpdatedAt = now
+				// A used-up item restocked from this receipt is a fresh batch
+				if item.Status == "depleted" {
+					if item.ExpiryEstimated {
+						if expiry, ok := shelflife.ExpiryDate(purchaseDate, item.Name, item.Category, item.Storage); ok {
+							item.ExpiryDate = expiry
+						}
+					}
+					item.Status = cfg.ExpiryStatus(item.ExpiryDate, now)
+				}
+				if err := tx.Set(existing[i].Ref, item); err != nil {
+					return err
+				}
+				events = append(events, StockManagementEvent{item.ID, item.UserID, "updated", item.Status})
+				continue
+			}
+
+			item := StockItem{
+				ID:             fmt.Sprintf("%d", now.UnixNano()+int64(i)),
+				UserID:         receipt.UserID,
+				HouseholdID:    receipt.HouseholdID,
+				Name:           line.item.Name,
+				NormalizedName: line.key,
+				ReceiptID:      receiptID,
+				Category:       line.item.Category,
+				Quantity:       line.quantity,
+				Storage:        shelflife.DefaultStorage(line.item.Category),
+				PurchaseDate:   purchaseDate,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
+			item.ExpiryDate, item.ExpiryEstimated = shelflife.ExpiryDate(purchaseDate, item.Name, item.Category, item.Storage)
+			item.Status = cfg.ExpiryStatus(item.ExpiryDate, now)
+			if err := tx.Create(firestoreClient.Collection("stock_items").Doc(item.ID), item); err != nil {
+				return err
+			}
+			events = append(events, StockManagementEvent{item.ID, item.UserID, "created", item.Status})
+		}
+
+		return tx.Update(receiptRef, []firestore.Update{{Path: "stocked", Value: true}})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stock pantry: %v", err)
+	}
+
+	// Let the stock manager create wallet passes and notifications as it does
+	// for manually added items
+	topic := pubsubClient.Topic("stock-management")
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx); err != nil {
+			log.Printf("Failed to publish stock event for item %s: %v", event.ItemID, err)
+		}
+	}
+
+	log.Printf("Stocked %d pantry items from receipt %s", len(events), receiptID)
+	return nil
+}
+
+// pantryLines keeps the stockable items and merges duplicates by normalized
+// name, so two lines of "Milk 1L" become one stock increment of two
+func pantryLines(cfg config.Config, items []Item) []pantryLine {
+	var lines []pantryLine
+	index := make(map[string]int)
+	for _, item := range items {
+		key := catalog.NormalizeName(item.Name)
+		if key == "" || !cfg.IsStockable(item.Category) {
+			continue
+		}
+
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+
+		if i, ok := index[key]; ok {
+			lines[i].quantity += quantity
+			continue
+		}
+		index[key] = len(lines)
+		lines = append(lines, pantryLine{key: key, item: item, quantity: quantity})
+	}
+	return lines
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"raseed-shared/prices"
+)
+
+// indexPrices records a price point for every priced line of the receipt so
+// items can be compared over time and across stores. Points are keyed by
+// receipt and line, so reprocessing a receipt overwrites its points.
+func indexPrices(ctx context.Context, receiptID string, data *ExtractedReceiptData) error {
+	doc, err := firestoreClient.Collection("receipts").Doc(receiptID).Get(ctx)
+	if err != nil {
+		return err
+	}
+	var receipt struct {
+		UserID      string    `firestore:"user_id"`
+		HouseholdID string    `firestore:"household_id"`
+		Date        time.Time `firestore:"date"`
+	}
+	if err := doc.DataTo(&receipt); err != nil {
+		return err
+	}
+
+	batch := firestoreClient.Batch()
+	count := 0
+	for i, item := range data.Items {
+		if item.Price <= 0 || item.Name == "" {
+			continue
+		}
+		point := prices.NewPoint(item.Name, item.Category, data.StoreName, item.Price, receipt.Date)
+		if point.NormalizedName == "" {
+			continue
+		}
+		point.ID = fmt.Sprintf("%s_%d", receiptID, i)
+		point.UserID = receipt.UserID
+		point.HouseholdID = receipt.HouseholdID
+		point.ReceiptID = receiptID
+		if data.MerchantID != "" {
+			point.StoreKey = data.MerchantID
+		}
+
+		batch.Set(firestoreClient.Collection("price_points").Doc(point.ID), point)
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	if _, err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to index prices: %v", err)
+	}
+	log.Printf("Indexed %d prices from receipt %s", count, receiptID)
+	return nil
+}
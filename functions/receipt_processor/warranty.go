@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/wallet"
+	"raseed-shared/warranty"
+)
+
+// suggestTerms gives items without a suggested warranty or return window the
+// usual terms for their category
+func suggestTerms(data *ExtractedReceiptData) {
+	for i := range data.Items {
+		item := &data.Items[i]
+		if item.WarrantyMonths > 0 || item.ReturnDays > 0 {
+			continue
+		}
+		terms := warranty.Suggest(item.Category)
+		item.WarrantyMonths, item.ReturnDays = terms.WarrantyMonths, terms.ReturnDays
+	}
+}
+
+// trackWarranties records a warranty for every item with a return window or
+// warranty, along with its proof-of-purchase wallet pass. Warranties that
+// already exist are left alone, so edits survive reprocessing.
+func trackWarranties(ctx context.Context, receiptID string, data *ExtractedReceiptData) error {
+	doc, err := firestoreClient.Collection("receipts").Doc(receiptID).Get(ctx)
+	if err != nil {
+		return err
+	}
+	var receipt struct {
+		UserID      string    `firestore:"user_id"`
+		HouseholdID string    `firestore:"household_id"`
+		ImageURL    string    `firestore:"image_url"`
+		Date        time.Time `firestore:"date"`
+	}
+	if err := doc.DataTo(&receipt); err != nil {
+		return err
+	}
+
+	cfg := runtimeConfig.Get(ctx)
+	now := time.Now()
+	tracked := 0
+	for i, item := range data.Items {
+		if item.WarrantyMonths <= 0 && item.ReturnDays <= 0 {
+			continue
+		}
+
+		w := warranty.Warranty{
+			ID:           warranty.ID(receiptID, i),
+			UserID:       receipt.UserID,
+			HouseholdID:  receipt.HouseholdID,
+			ReceiptID:    receiptID,
+			ItemIndex:    i,
+			ItemName:     item.Name,
+			Category:     item.Category,
+			StoreName:    data.StoreName,
+			Price:        item.Price,
+			ImageURL:     receipt.ImageURL,
+			PurchaseDate: receipt.Date,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		w.SetTerms(warranty.Terms{WarrantyMonths: item.WarrantyMonths, ReturnDays: item.ReturnDays})
+
+		_, err := firestoreClient.Collection("warranties").Doc(w.ID).Create(ctx, w)
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save warranty %s: %v", w.ID, err)
+		}
+
+		pass, err := w.Pass()
+		if err != nil {
+			return err
+		}
+		pass.Barcode = wallet.DefaultBarcode(pass, cfg.BarcodeType, cfg.AppLinkBase)
+		passRef := firestoreClient.Collection("wallet_passes").Doc(w.PassID())
+		action := "created"
+		_, err = passRef.Create(ctx, pass)
+		if status.Code(err) == codes.AlreadyExists {
+			// Keep the issued Wallet object and the pass's state
+			action = "updated"
+			_, err = passRef.Update(ctx, []firestore.Update{
+				{Path: "description", Value: pass.Description},
+				{Path: "payload", Value: pass.Payload},
+				{Path: "updated_at", Value: now},
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save warranty pass %s: %v", w.ID, err)
+		}
+		// A redelivered receipt skips warranties already saved, so a failed
+		// publish isn't retried; the pass is still in the app
+		if err := wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event(action, "")); err != nil {
+			log.Printf("%v", err)
+		}
+		tracked++
+	}
+
+	if tracked > 0 {
+		log.Printf("Tracking %d warranties from receipt %s", tracked, receiptID)
+	}
+	return nil
+}
@@ -10,20 +10,27 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/config"
+	"raseed-shared/wallet"
+	"raseed-shared/webhooks"
 )
 
 // StockManagementEvent represents the event data from Pub/Sub
 type StockManagementEvent struct {
 	ItemID string `json:"item_id"`
 	UserID string `json:"user_id"`
-	Action string `json:"action"` // created, updated, deleted
-	Status string `json:"status"` // fresh, expiring_soon, expired
+	Action string `json:"action"` // created, updated, consumed, deleted
+	Status string `json:"status"` // fresh, expiring_soon, expired, depleted
 }
 
 // StockItem represents a stock item in inventory
 type StockItem struct {
 	ID           string    `json:"id" firestore:"id"`
 	UserID       string    `json:"user_id" firestore:"user_id"`
+	HouseholdID  string    `json:"household_id,omitempty" firestore:"household_id,omitempty"`
 	Name         string    `json:"name" firestore:"name"`
 	Category     string    `json:"category" firestore:"category"`
 	Quantity     int       `json:"quantity" firestore:"quantity"`
@@ -38,6 +45,7 @@ type StockItem struct {
 var (
 	firestoreClient *firestore.Client
 	pubsubClient    *pubsub.Client
+	runtimeConfig   *config.Store
 )
 
 func init() {
@@ -55,6 +63,9 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to create Pub/Sub client: %v", err)
 	}
+
+	// Load runtime configuration from system_config
+	runtimeConfig = config.NewFirestore(ctx, firestoreClient)
 }
 
 // ProcessStockManagement is the Cloud Function entry point
@@ -71,6 +82,8 @@ func ProcessStockManagement(ctx context.Context, msg pubsub.Message) error {
 		return handleItemCreated(ctx, event)
 	case "updated":
 		return handleItemUpdated(ctx, event)
+	case "consumed":
+		return handleItemConsumed(ctx, event)
 	case "deleted":
 		return handleItemDeleted(ctx, event)
 	default:
@@ -101,9 +114,14 @@ func handleItemCreated(ctx context.Context, event StockManagementEvent) error {
 			return err
 		}
 	}
+	if item.Status == "expiring_soon" {
+		if err := webhooks.Publish(ctx, pubsubClient.Topic(webhooks.Topic), webhooks.StockExpiringEvent(expiringItem(item))); err != nil {
+			log.Printf("Failed to publish stock.expiring: %v", err)
+		}
+	}
 
 	// Create wallet pass for the item if it's perishable
-	if isPerishable(item.Category) {
+	if runtimeConfig.Get(ctx).IsPerishable(item.Category) {
 		err = createStockItemWalletPass(ctx, item)
 		if err != nil {
 			log.Printf("Failed to create wallet pass: %v", err)
@@ -137,9 +155,14 @@ func handleItemUpdated(ctx context.Context, event StockManagementEvent) error {
 			return err
 		}
 	}
+	if event.Status == "expiring_soon" {
+		if err := webhooks.Publish(ctx, pubsubClient.Topic(webhooks.Topic), webhooks.StockExpiringEvent(expiringItem(item))); err != nil {
+			log.Printf("Failed to publish stock.expiring: %v", err)
+		}
+	}
 
 	// Update wallet pass if needed
-	if isPerishable(item.Category) {
+	if runtimeConfig.Get(ctx).IsPerishable(item.Category) {
 		err = updateStockItemWalletPass(ctx, item)
 		if err != nil {
 			log.Printf("Failed to update wallet pass: %v", err)
@@ -151,6 +174,38 @@ func handleItemUpdated(ctx context.Context, event StockManagementEvent) error {
 	return nil
 }
 
+func handleItemConsumed(ctx context.Context, event StockManagementEvent) error {
+	// Used-up items no longer need a wallet pass
+	if event.Status == "depleted" {
+		return handleItemDeleted(ctx, event)
+	}
+
+	// Get the consumed item
+	doc, err := firestoreClient.Collection("stock_items").Doc(event.ItemID).Get(ctx)
+	if err != nil {
+		log.Printf("Failed to get item %s: %v", event.ItemID, err)
+		return err
+	}
+
+	var item StockItem
+	if err := doc.DataTo(&item); err != nil {
+		log.Printf("Failed to parse item %s: %v", event.ItemID, err)
+		return err
+	}
+
+	// Refresh the remaining quantity on the wallet pass
+	if runtimeConfig.Get(ctx).IsPerishable(item.Category) {
+		err = updateStockItemWalletPass(ctx, item)
+		if err != nil {
+			log.Printf("Failed to update wallet pass: %v", err)
+			return err
+		}
+	}
+
+	log.Printf("Successfully processed item consumption for %s", event.ItemID)
+	return nil
+}
+
 func handleItemDeleted(ctx context.Context, event StockManagementEvent) error {
 	// Delete associated wallet pass if exists
 	err := deleteStockItemWalletPass(ctx, event.ItemID)
@@ -164,12 +219,14 @@ func handleItemDeleted(ctx context.Context, event StockManagementEvent) error {
 }
 
 func sendExpiryNotification(ctx context.Context, item StockItem) error {
-	// Create notification event
+	// Create notification event. Its ID is the same for every update and
+	// redelivery of one alert, so the alert is sent once.
 	notificationData := map[string]interface{}{
-		"user_id":  item.UserID,
-		"type":     "stock_expiry",
-		"title":    "Item Expiry Alert",
-		"message":  fmt.Sprintf("%s is %s", item.Name, item.Status),
+		"id":      fmt.Sprintf("stock_expiry_%s_%s_%s", item.ID, item.Status, item.ExpiryDate.Format("2006-01-02")),
+		"user_id": item.UserID,
+		"type":    "stock_expiry",
+		"title":   "Item Expiry Alert",
+		"message": fmt.Sprintf("%s is %s", item.Name, item.Status),
 		"data": map[string]interface{}{
 			"item_id":     item.ID,
 			"item_name":   item.Name,
@@ -191,84 +248,110 @@ func sendExpiryNotification(ctx context.Context, item StockItem) error {
 	return nil
 }
 
+// expiringItem is what the stock.expiring webhook event tells about the item
+func expiringItem(item StockItem) webhooks.ExpiringItem {
+	return webhooks.ExpiringItem{
+		ID:          item.ID,
+		UserID:      item.UserID,
+		HouseholdID: item.HouseholdID,
+		Name:        item.Name,
+		Category:    item.Category,
+		Quantity:    item.Quantity,
+		Unit:        item.Unit,
+		ExpiryDate:  item.ExpiryDate,
+	}
+}
+
 func createStockItemWalletPass(ctx context.Context, item StockItem) error {
-	// Create wallet pass data
-	passData := map[string]interface{}{
-		"item_id":      item.ID,
-		"name":         item.Name,
-		"category":     item.Category,
-		"quantity":     item.Quantity,
-		"unit":         item.Unit,
-		"expiry_date":  item.ExpiryDate,
-		"status":       item.Status,
-	}
-
-	passDataJSON, err := json.Marshal(passData)
+	pass, err := stockItemPass(item, runtimeConfig.Get(ctx))
 	if err != nil {
-		return fmt.Errorf("failed to marshal pass data: %v", err)
+		return err
 	}
-
-	// Create wallet pass document
-	pass := map[string]interface{}{
-		"id":          fmt.Sprintf("stock_%s", item.ID),
-		"user_id":     item.UserID,
-		"type":        "stock_item",
-		"title":       fmt.Sprintf("Stock - %s", item.Name),
-		"description": fmt.Sprintf("Quantity: %d %s, Expires: %s", item.Quantity, item.Unit, item.ExpiryDate.Format("2006-01-02")),
-		"data":        string(passDataJSON),
-		"created_at":  firestore.ServerTimestamp,
+	_, err = firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass)
+	if err != nil {
+		return err
 	}
-
-	_, err = firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("stock_%s", item.ID)).Set(ctx, pass)
-	return err
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("created", ""))
 }
 
 func updateStockItemWalletPass(ctx context.Context, item StockItem) error {
-	// Update existing wallet pass
-	passData := map[string]interface{}{
-		"item_id":      item.ID,
-		"name":         item.Name,
-		"category":     item.Category,
-		"quantity":     item.Quantity,
-		"unit":         item.Unit,
-		"expiry_date":  item.ExpiryDate,
-		"status":       item.Status,
+	// Used-up items have no wallet pass, however they got there
+	if item.Status == "depleted" {
+		return deleteStockItemWalletPass(ctx, item.ID)
 	}
 
-	passDataJSON, err := json.Marshal(passData)
+	// Update existing wallet pass
+	pass, err := stockItemPass(item, runtimeConfig.Get(ctx))
 	if err != nil {
-		return fmt.Errorf("failed to marshal pass data: %v", err)
+		return err
 	}
 
-	_, err = firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("stock_%s", item.ID)).Update(ctx, []firestore.Update{
-		{Path: "title", Value: fmt.Sprintf("Stock - %s", item.Name)},
-		{Path: "description", Value: fmt.Sprintf("Quantity: %d %s, Expires: %s", item.Quantity, item.Unit, item.ExpiryDate.Format("2006-01-02"))},
-		{Path: "data", Value: string(passDataJSON)},
+	var expiresAt interface{} = firestore.Delete
+	if pass.ExpiresAt != nil {
+		expiresAt = *pass.ExpiresAt
+	}
+	_, err = firestoreClient.Collection("wallet_passes").Doc(pass.ID).Update(ctx, []firestore.Update{
+		{Path: "title", Value: pass.Title},
+		{Path: "description", Value: pass.Description},
+		{Path: "schema_version", Value: pass.SchemaVersion},
+		{Path: "payload", Value: pass.Payload},
+		{Path: "data", Value: firestore.Delete},
+		{Path: "expires_at", Value: expiresAt},
+		{Path: "barcode", Value: pass.Barcode},
+		{Path: "updated_at", Value: time.Now()},
 	})
+	if status.Code(err) == codes.NotFound {
+		// The pass was deleted when the item ran out; a restock brings it back
+		return createStockItemWalletPass(ctx, item)
+	}
+	if err != nil {
+		return err
+	}
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("updated", ""))
+}
 
-	return err
+// stockItemPass builds the wallet pass showing a pantry item. It expires
+// with the item, and its barcode links to the item in the app.
+func stockItemPass(item StockItem, cfg config.Config) (wallet.Pass, error) {
+	pass, err := wallet.NewPass(fmt.Sprintf("stock_%s", item.ID), item.UserID, "stock_item",
+		fmt.Sprintf("Stock - %s", item.Name),
+		fmt.Sprintf("Quantity: %d %s, Expires: %s", item.Quantity, item.Unit, item.ExpiryDate.Format("2006-01-02")),
+		&wallet.StockItemPayload{
+			ItemID:     item.ID,
+			Name:       item.Name,
+			Category:   item.Category,
+			Quantity:   item.Quantity,
+			Unit:       item.Unit,
+			ExpiryDate: item.ExpiryDate,
+			Status:     item.Status,
+		})
+	if err != nil {
+		return pass, fmt.Errorf("invalid stock item pass: %v", err)
+	}
+	if !item.ExpiryDate.IsZero() {
+		expiresAt := item.ExpiryDate
+		pass.ExpiresAt = &expiresAt
+	}
+	pass.Barcode = wallet.DefaultBarcode(pass, cfg.BarcodeType, cfg.AppLinkBase)
+	return pass, nil
 }
 
 func deleteStockItemWalletPass(ctx context.Context, itemID string) error {
 	// Delete wallet pass if exists
-	_, err := firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("stock_%s", itemID)).Delete(ctx)
+	doc, err := firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("stock_%s", itemID)).Get(ctx)
 	if err != nil {
 		// Ignore not found errors
 		return nil
 	}
-	return nil
-}
-
-func isPerishable(category string) bool {
-	perishableCategories := []string{
-		"dairy", "produce", "meat", "seafood", "bakery", "frozen", "beverages",
-		"dairy_products", "fruits", "vegetables", "meat_products", "fish",
+	var pass wallet.Pass
+	if err := doc.DataTo(&pass); err != nil {
+		return err
 	}
-	
-	for _, cat := range perishableCategories {
-		if category == cat {
-			return true
-		}
+	issued, _ := doc.DataAt("wallet_object_id")
+	objectID, _ := issued.(string)
+
+	if _, err := doc.Ref.Delete(ctx); err != nil {
+		return err
 	}
-	return false
-} 
\ No newline at end of file
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("deleted", objectID))
+}
@@ -10,6 +10,9 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
+
+	"raseed-shared/merchants"
+	"raseed-shared/wallet"
 )
 
 // ThirdPartyIntegrationEvent represents the event data from Pub/Sub
@@ -23,27 +26,33 @@ type ThirdPartyIntegrationEvent struct {
 
 // ThirdPartyBill represents a bill from third-party service
 type ThirdPartyBill struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"user_id"`
-	Service     string    `json:"service"`
-	OrderID     string    `json:"order_id"`
-	Restaurant  string    `json:"restaurant"`
-	TotalAmount float64   `json:"total_amount"`
-	Items       []BillItem `json:"items"`
-	OrderDate   time.Time `json:"order_date"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID           string     `json:"id" firestore:"id"`
+	UserID       string     `json:"user_id" firestore:"user_id"`
+	Service      string     `json:"service" firestore:"service"`
+	OrderID      string     `json:"order_id" firestore:"order_id"`
+	Restaurant   string     `json:"restaurant" firestore:"restaurant"`
+	MerchantID   string     `json:"merchant_id,omitempty" firestore:"merchant_id,omitempty"`
+	MerchantName string     `json:"merchant_name,omitempty" firestore:"merchant_name,omitempty"`
+	TotalAmount  float64    `json:"total_amount" firestore:"total_amount"`
+	Items        []BillItem `json:"items" firestore:"items"`
+	OrderDate    time.Time  `json:"order_date" firestore:"order_date"`
+	Status       string     `json:"status" firestore:"status"`
+	CreatedAt    time.Time  `json:"created_at" firestore:"created_at"`
 }
 
 // BillItem represents an item in a third-party bill
 type BillItem struct {
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
-	Category string  `json:"category"`
+	Name     string  `json:"name" firestore:"name"`
+	Price    float64 `json:"price" firestore:"price"`
+	Quantity int     `json:"quantity" firestore:"quantity"`
+	Category string  `json:"category" firestore:"category"`
 }
 
-var firestoreClient *firestore.Client
+var (
+	firestoreClient *firestore.Client
+	pubsubClient    *pubsub.Client
+	merchantDir     *merchants.Directory
+)
 
 func init() {
 	ctx := context.Background()
@@ -54,6 +63,14 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to create Firestore client: %v", err)
 	}
+
+	// Initialize Pub/Sub client
+	pubsubClient, err = pubsub.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Pub/Sub client: %v", err)
+	}
+
+	merchantDir = merchants.NewDirectory(firestoreClient)
 }
 
 // ProcessThirdPartyIntegration is the Cloud Function entry point
@@ -90,6 +107,8 @@ func fetchThirdPartyBills(ctx context.Context, event ThirdPartyIntegrationEvent)
 
 	// Save bills to Firestore
 	for _, bill := range bills {
+		resolveBillMerchant(ctx, &bill)
+
 		err := saveThirdPartyBill(ctx, bill)
 		if err != nil {
 			log.Printf("Failed to save bill %s: %v", bill.ID, err)
@@ -184,42 +203,55 @@ func fetchBlinkitBills(ctx context.Context, userID string) []ThirdPartyBill {
 	return bills
 }
 
+// resolveBillMerchant links the bill to its merchant in the directory. Lookup
+// failures leave the bill unlinked.
+func resolveBillMerchant(ctx context.Context, bill *ThirdPartyBill) {
+	categories := make([]string, len(bill.Items))
+	for i, item := range bill.Items {
+		categories[i] = item.Category
+	}
+
+	merchant, err := merchantDir.Resolve(ctx, bill.Restaurant, categories)
+	if err != nil {
+		log.Printf("Failed to resolve merchant %q: %v", bill.Restaurant, err)
+		return
+	}
+	bill.MerchantID = merchant.ID
+	bill.MerchantName = merchant.Name
+}
+
 func saveThirdPartyBill(ctx context.Context, bill ThirdPartyBill) error {
 	_, err := firestoreClient.Collection("third_party_bills").Doc(bill.ID).Set(ctx, bill)
 	return err
 }
 
 func createThirdPartyBillWalletPass(ctx context.Context, bill ThirdPartyBill) error {
-	// Create wallet pass data
-	passData := map[string]interface{}{
-		"bill_id":      bill.ID,
-		"service":      bill.Service,
-		"order_id":     bill.OrderID,
-		"restaurant":   bill.Restaurant,
-		"total_amount": bill.TotalAmount,
-		"items_count":  len(bill.Items),
-		"order_date":   bill.OrderDate.Format("2006-01-02"),
-		"status":       bill.Status,
+	merchant := bill.MerchantName
+	if merchant == "" {
+		merchant = bill.Restaurant
 	}
 
-	passDataJSON, err := json.Marshal(passData)
+	pass, err := wallet.NewPass(fmt.Sprintf("bill_%s", bill.ID), bill.UserID, "third_party_bill",
+		fmt.Sprintf("%s - %s", bill.Service, bill.Restaurant),
+		fmt.Sprintf("Order: %s, Total: $%.2f", bill.OrderID, bill.TotalAmount),
+		&wallet.BillPayload{
+			BillID:      bill.ID,
+			Service:     bill.Service,
+			OrderID:     bill.OrderID,
+			Merchant:    merchant,
+			TotalAmount: bill.TotalAmount,
+			ItemCount:   len(bill.Items),
+			OrderDate:   bill.OrderDate,
+			Status:      bill.Status,
+		})
 	if err != nil {
-		return fmt.Errorf("failed to marshal pass data: %v", err)
+		return fmt.Errorf("invalid bill pass: %v", err)
 	}
 
-	// Create wallet pass document
-	pass := map[string]interface{}{
-		"id":          fmt.Sprintf("bill_%s", bill.ID),
-		"user_id":     bill.UserID,
-		"type":        "third_party_bill",
-		"title":       fmt.Sprintf("%s - %s", bill.Service, bill.Restaurant),
-		"description": fmt.Sprintf("Order: %s, Total: $%.2f", bill.OrderID, bill.TotalAmount),
-		"data":        string(passDataJSON),
-		"created_at":  firestore.ServerTimestamp,
+	if _, err := firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass); err != nil {
+		return err
 	}
-
-	_, err = firestoreClient.Collection("wallet_passes").Doc(fmt.Sprintf("bill_%s", bill.ID)).Set(ctx, pass)
-	return err
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("created", ""))
 }
 
 func createThirdPartyWalletPass(ctx context.Context, event ThirdPartyIntegrationEvent) error {
@@ -229,30 +261,22 @@ func createThirdPartyWalletPass(ctx context.Context, event ThirdPartyIntegration
 		return fmt.Errorf("failed to parse service data: %v", err)
 	}
 
-	// Create wallet pass data
-	passData := map[string]interface{}{
-		"service":     event.Service,
-		"action":      event.Action,
-		"service_data": serviceData,
-		"requested_at": event.RequestedAt,
-	}
-
-	passDataJSON, err := json.Marshal(passData)
+	pass, err := wallet.NewPass(fmt.Sprintf("integration_%s_%d", event.Service, time.Now().Unix()), event.UserID,
+		"third_party_integration",
+		fmt.Sprintf("%s Integration", event.Service),
+		fmt.Sprintf("Action: %s", event.Action),
+		&wallet.IntegrationPayload{
+			Service:     event.Service,
+			Action:      event.Action,
+			ServiceData: serviceData,
+			RequestedAt: event.RequestedAt,
+		})
 	if err != nil {
-		return fmt.Errorf("failed to marshal pass data: %v", err)
+		return fmt.Errorf("invalid integration pass: %v", err)
 	}
 
-	// Create wallet pass document
-	pass := map[string]interface{}{
-		"id":          fmt.Sprintf("integration_%s_%d", event.Service, time.Now().Unix()),
-		"user_id":     event.UserID,
-		"type":        "third_party_integration",
-		"title":       fmt.Sprintf("%s Integration", event.Service),
-		"description": fmt.Sprintf("Action: %s", event.Action),
-		"data":        string(passDataJSON),
-		"created_at":  firestore.ServerTimestamp,
+	if _, err := firestoreClient.Collection("wallet_passes").Doc(pass.ID).Set(ctx, pass); err != nil {
+		return err
 	}
-
-	_, err = firestoreClient.Collection("wallet_passes").Doc(pass["id"].(string)).Set(ctx, pass)
-	return err
+	return wallet.Publish(ctx, pubsubClient.Topic(wallet.EventTopic), pass.Event("created", ""))
 } 
\ No newline at end of file
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/wallet"
+	"raseed-shared/webhooks"
+)
+
+// errSkip ends processing of an event that needs no further work
+var errSkip = errors.New("nothing to do")
+
+var (
+	firestoreClient *firestore.Client
+	pubsubClient    *pubsub.Client
+	walletClient    *wallet.Client // nil when no issuer is configured
+	issuerID        string
+)
+
+func init() {
+	ctx := context.Background()
+
+	// Initialize Firestore client
+	var err error
+	firestoreClient, err = firestore.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Firestore client: %v", err)
+	}
+
+	// Initialize Pub/Sub client
+	pubsubClient, err = pubsub.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Pub/Sub client: %v", err)
+	}
+
+	issuerID = os.Getenv("WALLET_ISSUER_ID")
+	if issuerID != "" {
+		httpClient, err := google.DefaultClient(ctx, wallet.Scope)
+		if err != nil {
+			log.Printf("Failed to create Google Wallet client, issued objects won't be updated: %v", err)
+		} else {
+			walletClient = wallet.NewClient(httpClient)
+		}
+	}
+}
+
+// ProcessWalletPassCreation is the Cloud Function entry point. It renders the
+// pass as a Google Wallet Generic object and records the outcome on the
+// wallet_passes document. Objects already issued are updated in Wallet, and
+// those of deleted passes made inactive. Passes that can't be issued are
+// marked invalid or failed and acknowledged; Firestore and Wallet API errors
+// are returned so Pub/Sub retries.
+func ProcessWalletPassCreation(ctx context.Context, msg pubsub.Message) error {
+	var event wallet.Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("Dropping malformed wallet pass event: %v", err)
+		return nil
+	}
+	if event.PassID == "" {
+		log.Printf("Dropping wallet pass event without pass_id")
+		return nil
+	}
+
+	if event.Action == "deleted" {
+		return revokeObject(ctx, event)
+	}
+
+	log.Printf("Creating wallet object for pass %s, user %s", event.PassID, event.UserID)
+
+	var outcome, previous string
+	var rendered map[string]interface{}
+	var pass wallet.Pass
+	ref := firestoreClient.Collection("wallet_passes").Doc(event.PassID)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			outcome = "deleted before it was issued"
+			return errSkip
+		}
+		if err != nil {
+			return err
+		}
+
+		pass = wallet.Pass{}
+		if err := doc.DataTo(&pass); err != nil {
+			outcome = "unreadable"
+			return tx.Update(ref, issuance(wallet.StatusInvalid, fmt.Sprintf("unreadable pass: %v", err)))
+		}
+		if pass.ID == "" {
+			pass.ID = doc.Ref.ID
+		}
+
+		if err := validate(pass, event); err != nil {
+			outcome = wallet.StatusInvalid
+			return tx.Update(ref, issuance(wallet.StatusInvalid, err.Error()))
+		}
+		if issuerID == "" {
+			outcome = wallet.StatusFailed
+			return tx.Update(ref, issuance(wallet.StatusFailed, "WALLET_ISSUER_ID is not configured"))
+		}
+
+		rendered = wallet.GenericObject(issuerID, pass)
+		object, err := json.Marshal(rendered)
+		if err != nil {
+			outcome = wallet.StatusFailed
+			return tx.Update(ref, issuance(wallet.StatusFailed, fmt.Sprintf("failed to render object: %v", err)))
+		}
+
+		// Redelivered events for a pass that hasn't changed are a no-op
+		if issued(doc, string(object)) {
+			outcome = "already issued"
+			return errSkip
+		}
+		current, _ := doc.DataAt("wallet_object")
+		previous, _ = current.(string)
+
+		outcome = wallet.StatusIssued
+		updates := issuance(wallet.StatusIssued, "")
+		updates = append(updates,
+			firestore.Update{Path: "wallet_object_id", Value: wallet.ObjectID(issuerID, pass.ID)},
+			firestore.Update{Path: "wallet_class_id", Value: wallet.ClassID(issuerID, wallet.KindOf(pass.Type))},
+			firestore.Update{Path: "wallet_object", Value: string(object)},
+			firestore.Update{Path: "wallet_issued_at", Value: time.Now()},
+		)
+		return tx.Update(ref, updates)
+	})
+	if err != nil && err != errSkip {
+		log.Printf("Failed to create wallet object for pass %s: %v", event.PassID, err)
+		return err
+	}
+
+	// Wallet creates objects from save links, which always carry the latest
+	// pass, so only objects issued before need updating there
+	if outcome == wallet.StatusIssued && previous != "" {
+		if err := patchObject(ctx, ref, rendered); err != nil {
+			log.Printf("Failed to update wallet object for pass %s: %v", event.PassID, err)
+			return err
+		}
+	}
+
+	// Let the user's webhooks know about new passes, whether or not Wallet
+	// could issue them
+	created := event.Action == "" || event.Action == "created"
+	if created && (outcome == wallet.StatusIssued || outcome == wallet.StatusFailed) {
+		if err := webhooks.Publish(ctx, pubsubClient.Topic(webhooks.Topic), passCreatedEvent(pass, outcome)); err != nil {
+			log.Printf("Failed to publish pass.created for %s: %v", pass.ID, err)
+		}
+	}
+
+	log.Printf("Wallet pass %s: %s", event.PassID, outcome)
+	return nil
+}
+
+// passCreatedEvent is the webhook event for a new pass
+func passCreatedEvent(pass wallet.Pass, walletStatus string) webhooks.Event {
+	data := map[string]interface{}{
+		"pass_id":       pass.ID,
+		"type":          pass.Type,
+		"title":         pass.Title,
+		"description":   pass.Description,
+		"wallet_status": walletStatus,
+	}
+	if walletStatus == wallet.StatusIssued {
+		data["wallet_object_id"] = wallet.ObjectID(issuerID, pass.ID)
+	}
+	return webhooks.Event{
+		ID:     webhooks.EventID(webhooks.PassCreated, pass.ID),
+		Type:   webhooks.PassCreated,
+		UserID: pass.UserID,
+		Data:   data,
+	}
+}
+
+// patchObject brings the object in users' wallets in line with the pass. A
+// failure is recorded on the pass, so the retried event isn't skipped as
+// already issued.
+func patchObject(ctx context.Context, ref *firestore.DocumentRef, object map[string]interface{}) error {
+	if walletClient == nil {
+		return nil
+	}
+	objectID, _ := object["id"].(string)
+	err := walletClient.PatchObject(ctx, objectID, object)
+	if err == nil || errors.Is(err, wallet.ErrNotSaved) {
+		return nil
+	}
+	if _, updateErr := ref.Update(ctx, issuance(wallet.StatusFailed, fmt.Sprintf("failed to update Wallet object: %v", err))); updateErr != nil {
+		log.Printf("Failed to record wallet error for pass %s: %v", ref.ID, updateErr)
+	}
+	return err
+}
+
+// revokeObject makes a deleted pass's object inactive in users' wallets
+func revokeObject(ctx context.Context, event wallet.Event) error {
+	if event.WalletObjectID == "" {
+		log.Printf("Wallet pass %s: deleted before it was issued", event.PassID)
+		return nil
+	}
+	if walletClient == nil {
+		log.Printf("Wallet pass %s: deleted, but no Wallet client to revoke %s", event.PassID, event.WalletObjectID)
+		return nil
+	}
+
+	err := walletClient.Revoke(ctx, event.WalletObjectID)
+	if errors.Is(err, wallet.ErrNotSaved) {
+		log.Printf("Wallet pass %s: deleted, never saved to a wallet", event.PassID)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to revoke wallet object %s: %v", event.WalletObjectID, err)
+		return err
+	}
+
+	log.Printf("Wallet pass %s: deleted, object %s revoked", event.PassID, event.WalletObjectID)
+	return nil
+}
+
+// validate checks the pass itself and that it belongs to the event's user
+func validate(pass wallet.Pass, event wallet.Event) error {
+	if err := pass.Validate(); err != nil {
+		return err
+	}
+	if event.UserID != "" && event.UserID != pass.UserID {
+		return fmt.Errorf("pass belongs to %s, not %s", pass.UserID, event.UserID)
+	}
+	return nil
+}
+
+// issued reports whether the document already holds this object, issued
+func issued(doc *firestore.DocumentSnapshot, object string) bool {
+	state, _ := doc.DataAt("wallet_status")
+	current, _ := doc.DataAt("wallet_object")
+	return state == wallet.StatusIssued && current == object
+}
+
+// issuance records the issuance status and error on the pass
+func issuance(state, message string) []firestore.Update {
+	return []firestore.Update{
+		{Path: "wallet_status", Value: state},
+		{Path: "wallet_error", Value: message},
+		{Path: "wallet_updated_at", Value: time.Now()},
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"raseed-shared/webhooks"
+)
+
+// errNotDue holds back an event whose delivery is waiting out its backoff
+var errNotDue = errors.New("waiting to retry")
+
+var (
+	firestoreClient *firestore.Client
+	httpClient      = webhooks.NewClient(10 * time.Second)
+)
+
+func init() {
+	ctx := context.Background()
+
+	// Initialize Firestore client
+	var err error
+	firestoreClient, err = firestore.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("Failed to create Firestore client: %v", err)
+	}
+}
+
+// DispatchWebhookEvent is the Cloud Function entry point. It delivers the
+// event to each of the user's active webhooks subscribed to its type and logs
+// every attempt in webhook_deliveries. Failed deliveries wait out their
+// backoff; the event is returned to Pub/Sub until every webhook has
+// succeeded or been given up on.
+func DispatchWebhookEvent(ctx context.Context, msg pubsub.Message) error {
+	var event webhooks.Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("Dropping malformed webhook event: %v", err)
+		return nil
+	}
+	if event.ID == "" {
+		event.ID = msg.ID
+	}
+	if err := event.Validate(); err != nil {
+		log.Printf("Dropping webhook event: %v", err)
+		return nil
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = msg.PublishTime
+	}
+
+	hooks, err := subscribedWebhooks(ctx, event)
+	if err != nil {
+		log.Printf("Failed to fetch webhooks of user %s: %v", event.UserID, err)
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Dropping unencodable webhook event %s: %v", event.ID, err)
+		return nil
+	}
+
+	var pending []string
+	for _, hook := range hooks {
+		if err := deliver(ctx, hook, event, payload); err != nil {
+			pending = append(pending, fmt.Sprintf("%s: %v", hook.ID, err))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("webhook event %s pending on %s", event.ID, strings.Join(pending, "; "))
+	}
+
+	log.Printf("Delivered %s event %s to %d webhooks", event.Type, event.ID, len(hooks))
+	return nil
+}
+
+// subscribedWebhooks returns the user's active webhooks for the event's type
+func subscribedWebhooks(ctx context.Context, event webhooks.Event) ([]webhooks.Webhook, error) {
+	iter := firestoreClient.Collection("webhooks").
+		Where("user_id", "==", event.UserID).
+		Where("active", "==", true).
+		Where("events", "array-contains", event.Type).
+		Documents(ctx)
+
+	var hooks []webhooks.Webhook
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var hook webhooks.Webhook
+		if err := doc.DataTo(&hook); err != nil {
+			log.Printf("Skipping unreadable webhook %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// deliver makes the next attempt at delivering the event to one webhook, if
+// one is due, and logs it. It returns an error while the delivery is still
+// to be retried.
+func deliver(ctx context.Context, hook webhooks.Webhook, event webhooks.Event, payload []byte) error {
+	ref := firestoreClient.Collection("webhook_deliveries").Doc(event.ID + "_" + hook.ID)
+	now := time.Now()
+	d := webhooks.Delivery{
+		ID:        ref.ID,
+		WebhookID: hook.ID,
+		UserID:    hook.UserID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Payload:   string(payload),
+		CreatedAt: now,
+	}
+
+	doc, err := ref.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	if err == nil {
+		if err := doc.DataTo(&d); err != nil {
+			return err
+		}
+		if !d.Due(now) {
+			if d.Status == webhooks.StatusRetrying {
+				return errNotDue
+			}
+			return nil
+		}
+	}
+
+	// Retries go to the webhook's current URL, in case the user fixed it
+	d.URL = hook.URL
+	sendErr := webhooks.Send(ctx, httpClient, hook, &d, now)
+	if _, err := ref.Set(ctx, d); err != nil {
+		log.Printf("Failed to log delivery %s: %v", d.ID, err)
+		return err
+	}
+
+	switch d.Status {
+	case webhooks.StatusRetrying:
+		log.Printf("Delivery %s attempt %d failed, retrying after %s: %v", d.ID, d.Attempts, d.NextAttemptAt.Format(time.RFC3339), sendErr)
+		return sendErr
+	case webhooks.StatusFailed:
+		log.Printf("Giving up on delivery %s after %d attempts: %v", d.ID, d.Attempts, sendErr)
+	}
+	return nil
+}
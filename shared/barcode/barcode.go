@@ -0,0 +1,90 @@
+// Package barcode encodes the scannable codes shown on wallet passes and
+// renders them as PNG or SVG for the web app. QR codes and Code 128 are
+// supported, the two symbologies Google Wallet and store scanners share.
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Symbologies, named as Google Wallet names them
+const (
+	QRCode  = "QR_CODE"
+	Code128 = "CODE_128"
+)
+
+// Barcode is a code shown on a pass
+type Barcode struct {
+	Type          string `json:"type" firestore:"type"` // QR_CODE or CODE_128
+	Value         string `json:"value" firestore:"value"`
+	AlternateText string `json:"alternate_text,omitempty" firestore:"alternate_text,omitempty"` // printed under the code
+}
+
+// Symbology normalizes a symbology name: qr, qr_code, code128 and code_128
+// are accepted in any case
+func Symbology(name string) (string, error) {
+	switch strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(name), "-", "_")) {
+	case "QR", QRCode:
+		return QRCode, nil
+	case "CODE128", Code128:
+		return Code128, nil
+	}
+	return "", fmt.Errorf("unsupported barcode type %q, use %s or %s", name, QRCode, Code128)
+}
+
+// New builds a barcode, checking the value can be encoded in the symbology
+func New(symbology, value, alternateText string) (Barcode, error) {
+	symbology, err := Symbology(symbology)
+	if err != nil {
+		return Barcode{}, err
+	}
+	code := Barcode{Type: symbology, Value: value, AlternateText: alternateText}
+	return code, code.Validate()
+}
+
+// Validate checks the barcode can be encoded
+func (b Barcode) Validate() error {
+	_, err := b.symbol()
+	return err
+}
+
+// Link is the app deep link for a path, like https://raseed.app/receipts/123
+func Link(base string, path ...string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.Join(path, "/")
+}
+
+// symbol is an encoded barcode: rows of modules, dark when true. Linear codes
+// have a single row drawn rowHeight modules tall.
+type symbol struct {
+	rows      [][]bool
+	rowHeight int
+	quietX    int // light modules left and right
+	quietY    int // light modules above and below
+}
+
+func (b Barcode) symbol() (symbol, error) {
+	if b.Value == "" {
+		return symbol{}, errors.New("barcode has no value")
+	}
+	switch b.Type {
+	case QRCode:
+		modules, err := encodeQR([]byte(b.Value))
+		if err != nil {
+			return symbol{}, err
+		}
+		return symbol{rows: modules, rowHeight: 1, quietX: 4, quietY: 4}, nil
+	case Code128:
+		bars, err := encodeCode128(b.Value)
+		if err != nil {
+			return symbol{}, err
+		}
+		height := len(bars) * 15 / 100 // at least 15% of the width
+		if height < 40 {
+			height = 40
+		}
+		return symbol{rows: [][]bool{bars}, rowHeight: height, quietX: 10, quietY: 2}, nil
+	}
+	return symbol{}, fmt.Errorf("unsupported barcode type %q", b.Type)
+}
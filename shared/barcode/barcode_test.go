@@ -0,0 +1,301 @@
+package barcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, the worked example of the QR specification
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, 10); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder = %v, want %v", got, want)
+	}
+}
+
+func TestQRInformationBits(t *testing.T) {
+	if got := qrFormatBits(0); got != 0x5412 {
+		t.Errorf("format bits for M, mask 0 = %#x, want 0x5412", got)
+	}
+	if got := qrFormatBits(5); got != 0x40CE {
+		t.Errorf("format bits for M, mask 5 = %#x, want 0x40ce", got)
+	}
+
+	g := newQRGrid(7)
+	g.drawVersion(7)
+	bits := 0
+	for i := 17; i >= 0; i-- {
+		bits <<= 1
+		if g.dark[i/3][g.size-11+i%3] {
+			bits |= 1
+		}
+	}
+	if bits != 0x07C94 {
+		t.Errorf("version 7 information = %#x, want 0x7c94", bits)
+	}
+}
+
+// readQR decodes a symbol made by encodeQR: it reads the format, removes
+// the mask, collects the codewords, checks every block's error correction
+// and returns the byte mode data
+func readQR(t *testing.T, modules [][]bool) []byte {
+	t.Helper()
+	version := (len(modules) - 17) / 4
+	g := newQRGrid(version)
+	g.drawFunctionPatterns(version)
+
+	format := 0
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | bit(modules[8][14-i])
+	}
+	format = format<<1 | bit(modules[8][7])
+	format = format<<1 | bit(modules[8][8])
+	format = format<<1 | bit(modules[7][8])
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | bit(modules[i][8])
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if qrFormatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("format bits %#x are not level M with a valid mask", format)
+	}
+
+	var codewords []byte
+	var word byte
+	n := 0
+	for right := g.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < g.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = g.size - 1 - vert
+				}
+				if g.function[y][x] {
+					continue
+				}
+				dark := modules[y][x] != qrMask(mask, x, y)
+				word = word<<1 | byte(bit(dark))
+				if n++; n%8 == 0 {
+					codewords = append(codewords, word)
+				}
+			}
+		}
+	}
+
+	v := qrVersions[version]
+	blocks := make([][]byte, len(v.blocks))
+	i := 0
+	for k := 0; k < v.blocks[len(v.blocks)-1]; k++ {
+		for b, size := range v.blocks {
+			if k < size {
+				blocks[b] = append(blocks[b], codewords[i])
+				i++
+			}
+		}
+	}
+	var data []byte
+	for b, block := range blocks {
+		ecc := make([]byte, v.eccPerBlock)
+		for k := range ecc {
+			ecc[k] = codewords[i+k*len(blocks)+b]
+		}
+		if !bytes.Equal(rsRemainder(block, v.eccPerBlock), ecc) {
+			t.Fatalf("block %d fails its error correction", b)
+		}
+		data = append(data, block...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode = %#x, want byte mode", data[0]>>4)
+	}
+	countBits := qrCountBits(version)
+	bitAt := func(p int) int { return int(data[p/8]>>uint(7-p%8)) & 1 }
+	read := func(p, n int) int {
+		value := 0
+		for k := 0; k < n; k++ {
+			value = value<<1 | bitAt(p+k)
+		}
+		return value
+	}
+	length := read(4, countBits)
+	out := make([]byte, length)
+	for k := range out {
+		out[k] = byte(read(4+countBits+8*k, 8))
+	}
+	return out
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		version int
+	}{
+		{"https://raseed.app/r/1", 2},
+		{"https://raseed.app/receipts/1703123456789", 3},
+		{"https://raseed.app/stock-items/" + strings.Repeat("x", 70), 6},
+		{strings.Repeat("RA-1703123456789_2 ", 6), 7},
+		{strings.Repeat("z", 213), 10},
+	} {
+		modules, err := encodeQR([]byte(tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (len(modules) - 17) / 4; got != tt.version {
+			t.Errorf("%d bytes: version %d, want %d", len(tt.value), got, tt.version)
+		}
+		if got := string(readQR(t, modules)); got != tt.value {
+			t.Errorf("read back %q, want %q", got, tt.value)
+		}
+	}
+
+	if _, err := encodeQR(bytes.Repeat([]byte("z"), 214)); err == nil {
+		t.Error("Expected values over 213 bytes to be rejected")
+	}
+}
+
+func TestCode128Patterns(t *testing.T) {
+	seen := map[string]bool{}
+	for v, pattern := range code128Patterns {
+		sum := 0
+		for _, width := range pattern {
+			sum += int(width - '0')
+		}
+		want := 11
+		if v == code128Stop {
+			want = 13
+		}
+		if sum != want {
+			t.Errorf("pattern %d is %d modules wide, want %d", v, sum, want)
+		}
+		if seen[pattern] {
+			t.Errorf("pattern %d is a duplicate", v)
+		}
+		seen[pattern] = true
+	}
+}
+
+// readCode128 turns bars back into symbol values
+func readCode128(t *testing.T, bars []bool) []int {
+	t.Helper()
+	values := map[string]int{}
+	for v, pattern := range code128Patterns {
+		values[pattern] = v
+	}
+
+	var widths []byte
+	for i := 0; i < len(bars); {
+		run := 1
+		for i+run < len(bars) && bars[i+run] == bars[i] {
+			run++
+		}
+		widths = append(widths, byte('0'+run))
+		i += run
+	}
+
+	var out []int
+	for len(widths) > 7 {
+		v, ok := values[string(widths[:6])]
+		if !ok {
+			t.Fatalf("unknown pattern %s", widths[:6])
+		}
+		out = append(out, v)
+		widths = widths[6:]
+	}
+	if string(widths) != code128Patterns[code128Stop] {
+		t.Fatalf("symbol ends with %s, not the stop pattern", widths)
+	}
+	return append(out, code128Stop)
+}
+
+func TestCode128(t *testing.T) {
+	bars, err := encodeCode128("PJJ123C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readCode128(t, bars)
+	want := []int{code128StartB, 48, 42, 42, 17, 18, 19, 35, 55, code128Stop}
+	if len(got) != len(want) {
+		t.Fatalf("values = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("values = %v, want %v", got, want)
+		}
+	}
+
+	// Even-length digits use code set C, two digits per symbol
+	bars, err = encodeCode128("60012345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readCode128(t, bars); got[0] != code128StartC || got[1] != 60 || got[4] != 45 || len(got) != 7 {
+		t.Errorf("values = %v, want start C, 60, 1, 23, 45, checksum, stop", got)
+	}
+
+	if _, err := encodeCode128("café"); err == nil {
+		t.Error("Expected non-ASCII values to be rejected")
+	}
+}
+
+func TestSymbology(t *testing.T) {
+	for name, want := range map[string]string{"qr": QRCode, "QR_CODE": QRCode, "code128": Code128, "code-128": Code128} {
+		if got, err := Symbology(name); err != nil || got != want {
+			t.Errorf("Symbology(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := Symbology("pdf_417"); err == nil {
+		t.Error("Expected PDF 417 to be unsupported")
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := New("qr", "https://raseed.app/receipts/1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := code.PNG(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Version 3 is 29 modules, plus 4 of quiet zone on each side
+	if size := img.Bounds().Dx(); size != 37*3 || img.Bounds().Dy() != size {
+		t.Errorf("PNG is %v, want 111x111", img.Bounds())
+	}
+	if r, _, _, _ := img.At(4*3, 4*3).RGBA(); r != 0 {
+		t.Error("Expected the finder's corner to be dark")
+	}
+
+	code, err = New(Code128, "60012345", "6001 2345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg, err := code.SVG(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Start, 4 values, checksum and stop: 6*11+13 modules, plus 10 each side
+	if !bytes.HasPrefix(svg, []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="198" height="88" viewBox="0 0 99 44"`)) {
+		t.Errorf("unexpected SVG header: %.120s", svg)
+	}
+}
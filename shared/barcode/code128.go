@@ -0,0 +1,72 @@
+package barcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// code128Patterns are the bar and space widths of each Code 128 symbol
+// value, starting with a bar. 103-105 are the start codes, 106 the stop.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+
+	// maxCode128Length keeps codes short enough for a phone screen to scan
+	maxCode128Length = 48
+)
+
+// encodeCode128 lays out value as Code 128 bars, without the quiet zone.
+// Even-length runs of digits, like membership numbers, use the denser code
+// set C; anything else printable ASCII uses code set B.
+func encodeCode128(value string) ([]bool, error) {
+	if len(value) > maxCode128Length {
+		return nil, fmt.Errorf("value is too long for Code 128: %d characters, at most %d", len(value), maxCode128Length)
+	}
+
+	var values []int
+	if len(value)%2 == 0 && strings.Trim(value, "0123456789") == "" {
+		values = append(values, code128StartC)
+		for i := 0; i < len(value); i += 2 {
+			values = append(values, int(value[i]-'0')*10+int(value[i+1]-'0'))
+		}
+	} else {
+		values = append(values, code128StartB)
+		for _, r := range value {
+			if r < 32 || r > 126 {
+				return nil, fmt.Errorf("Code 128 can't encode %q", r)
+			}
+			values = append(values, int(r)-32)
+		}
+	}
+
+	checksum := values[0]
+	for i, v := range values[1:] {
+		checksum += (i + 1) * v
+	}
+	values = append(values, checksum%103, code128Stop)
+
+	var bars []bool
+	for _, v := range values {
+		for i, width := range code128Patterns[v] {
+			for n := 0; n < int(width-'0'); n++ {
+				bars = append(bars, i%2 == 0)
+			}
+		}
+	}
+	return bars, nil
+}
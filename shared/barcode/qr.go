@@ -0,0 +1,429 @@
+package barcode
+
+import "fmt"
+
+// QR codes are encoded in byte mode at error correction level M, in the
+// smallest of versions 1-10 that fits. That holds up to 213 bytes, plenty for
+// deep links and membership numbers.
+
+// qrVersion is the layout of one QR version at level M
+type qrVersion struct {
+	eccPerBlock int
+	blocks      []int // data codewords of each block
+	alignment   []int // alignment pattern centres, on both axes
+}
+
+var qrVersions = []qrVersion{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+// qrFormatM is level M in the format information
+const qrFormatM = 0
+
+func (v qrVersion) dataCodewords() int {
+	total := 0
+	for _, n := range v.blocks {
+		total += n
+	}
+	return total
+}
+
+// encodeQR lays out data as a QR code, without the quiet zone
+func encodeQR(data []byte) ([][]bool, error) {
+	for version := 1; version < len(qrVersions); version++ {
+		if 4+qrCountBits(version)+8*len(data) <= 8*qrVersions[version].dataCodewords() {
+			return buildQR(version, qrCodewords(version, data)), nil
+		}
+	}
+	return nil, fmt.Errorf("value is too long for a QR code: %d bytes", len(data))
+}
+
+func qrCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrCodewords encodes data in byte mode, pads it to the version's capacity
+// and interleaves the blocks with their error correction
+func qrCodewords(version int, data []byte) []byte {
+	v := qrVersions[version]
+	capacity := 8 * v.dataCodewords()
+
+	var bits []bool
+	appendBits := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>uint(i)&1 == 1)
+		}
+	}
+	appendBits(0x4, 4) // byte mode
+	appendBits(len(data), qrCountBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false) // terminator
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	words := make([]byte, 0, v.dataCodewords())
+	for i := 0; i < len(bits); i += 8 {
+		var word byte
+		for _, bit := range bits[i : i+8] {
+			word <<= 1
+			if bit {
+				word |= 1
+			}
+		}
+		words = append(words, word)
+	}
+	for pad := byte(0xEC); len(words) < cap(words); pad ^= 0xEC ^ 0x11 {
+		words = append(words, pad)
+	}
+
+	var blocks, eccs [][]byte
+	for _, n := range v.blocks {
+		blocks = append(blocks, words[:n])
+		eccs = append(eccs, rsRemainder(words[:n], v.eccPerBlock))
+		words = words[n:]
+	}
+
+	var out []byte
+	longest := v.blocks[len(v.blocks)-1]
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.eccPerBlock; i++ {
+		for _, ecc := range eccs {
+			out = append(out, ecc[i])
+		}
+	}
+	return out
+}
+
+// qrGrid is a QR code being built. Function modules (finders, timing,
+// alignment, format and version information) are never masked.
+type qrGrid struct {
+	size     int
+	dark     [][]bool
+	function [][]bool
+}
+
+func newQRGrid(version int) *qrGrid {
+	size := 17 + 4*version
+	g := &qrGrid{size: size, dark: make([][]bool, size), function: make([][]bool, size)}
+	for y := range g.dark {
+		g.dark[y] = make([]bool, size)
+		g.function[y] = make([]bool, size)
+	}
+	return g
+}
+
+func (g *qrGrid) set(x, y int, dark bool) {
+	g.dark[y][x] = dark
+	g.function[y][x] = true
+}
+
+func buildQR(version int, codewords []byte) [][]bool {
+	g := newQRGrid(version)
+	g.drawFunctionPatterns(version)
+	g.drawCodewords(codewords)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		g.applyMask(mask)
+		g.drawFormat(mask)
+		if penalty := g.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		g.applyMask(mask) // masks are their own inverse
+	}
+	g.applyMask(best)
+	g.drawFormat(best)
+	return g.dark
+}
+
+func (g *qrGrid) drawFunctionPatterns(version int) {
+	for i := 0; i < g.size; i++ {
+		g.set(6, i, i%2 == 0)
+		g.set(i, 6, i%2 == 0)
+	}
+
+	g.drawFinder(3, 3)
+	g.drawFinder(g.size-4, 3)
+	g.drawFinder(3, g.size-4)
+
+	positions := qrVersions[version].alignment
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			// Skip the three corners taken by finders
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			g.drawAlignment(x, y)
+		}
+	}
+
+	g.drawFormat(0) // reserve the area; redrawn once the mask is chosen
+	g.drawVersion(version)
+}
+
+func (g *qrGrid) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= g.size || y < 0 || y >= g.size {
+				continue
+			}
+			distance := max(abs(dx), abs(dy))
+			g.set(x, y, distance != 2 && distance != 4)
+		}
+	}
+}
+
+func (g *qrGrid) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			g.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat writes the error correction level and mask, with their BCH
+// check bits, in both copies
+func (g *qrGrid) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		g.set(8, i, bit(i))
+	}
+	g.set(8, 7, bit(6))
+	g.set(8, 8, bit(7))
+	g.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		g.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		g.set(g.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		g.set(8, g.size-15+i, bit(i))
+	}
+	g.set(8, g.size-8, true) // the dark module
+}
+
+func qrFormatBits(mask int) int {
+	data := qrFormatM<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawVersion writes the version information of versions 7 and up
+func (g *qrGrid) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := bits>>uint(i)&1 == 1
+		a, b := g.size-11+i%3, i/3
+		g.set(a, b, dark)
+		g.set(b, a, dark)
+	}
+}
+
+// drawCodewords fills the data area in the zigzag order: two-module columns
+// from the right, alternately upwards and downwards, stepping over the
+// vertical timing pattern
+func (g *qrGrid) drawCodewords(codewords []byte) {
+	i := 0
+	for right := g.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < g.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = g.size - 1 - vert
+				}
+				if g.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				g.dark[y][x] = codewords[i>>3]>>uint(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (g *qrGrid) applyMask(mask int) {
+	for y := 0; y < g.size; y++ {
+		for x := 0; x < g.size; x++ {
+			if !g.function[y][x] && qrMask(mask, x, y) {
+				g.dark[y][x] = !g.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the masked symbol is to read, following the four
+// rules of the QR specification. The mask with the lowest score is used.
+func (g *qrGrid) penalty() int {
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return g.dark[x][y]
+		}
+		return g.dark[y][x]
+	}
+
+	// Finder-like 1:1:3:1:1 patterns with four light modules on one side
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	score := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < g.size; y++ {
+			run := 1
+			for x := 1; x <= g.size; x++ {
+				if x < g.size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for x := 0; x+11 <= g.size; x++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if at(x+k, y, vertical) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						score += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < g.size; y++ {
+		for x := 0; x < g.size; x++ {
+			if g.dark[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := g.dark[y][x]
+				if g.dark[y-1][x] == c && g.dark[y][x-1] == c && g.dark[y-1][x-1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (g.size * g.size)
+	return score + abs(percent-50)/5*10
+}
+
+// gfMul multiplies in GF(2^8) with the QR polynomial x^8+x^4+x^3+x^2+1
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsRemainder computes the Reed-Solomon error correction codewords of data
+func rsRemainder(data []byte, degree int) []byte {
+	// Generator polynomial (x - a^0)(x - a^1)...(x - a^(degree-1)),
+	// coefficients from highest to lowest, the leading 1 left out
+	generator := make([]byte, degree)
+	generator[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			generator[j] = gfMul(generator[j], root)
+			if j+1 < degree {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+
+	remainder := make([]byte, degree)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[degree-1] = 0
+		for i, coefficient := range generator {
+			remainder[i] ^= gfMul(coefficient, factor)
+		}
+	}
+	return remainder
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
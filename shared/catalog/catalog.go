@@ -0,0 +1,123 @@
+// Package catalog normalizes product names so the same product bought at
+// different times, or typed in by hand, can be matched across receipts and
+// pantry stock.
+package catalog
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sizeUnits are pack-size units dropped from names, so "Milk 1L" and "milk"
+// are the same product
+var sizeUnits = map[string]bool{
+	"g": true, "gm": true, "gms": true, "gram": true, "grams": true,
+	"kg": true, "kgs": true, "mg": true,
+	"l": true, "ltr": true, "litre": true, "liter": true, "ml": true,
+	"oz": true, "lb": true, "lbs": true,
+	"pc": true, "pcs": true, "pack": true, "pk": true, "x": true,
+}
+
+// NormalizeName lowercases a product name, strips punctuation and pack sizes
+// and collapses whitespace, e.g. "Amul Taaza Milk (1 L)" -> "amul taaza milk".
+func NormalizeName(name string) string {
+	words := splitWords(name)
+
+	kept := make([]string, 0, len(words))
+	for _, word := range words {
+		if isSize(word) {
+			continue
+		}
+		kept = append(kept, word)
+	}
+	return strings.Join(kept, " ")
+}
+
+// packUnit converts a pack-size unit to kilograms, litres or pieces
+type packUnit struct {
+	base   string
+	factor float64
+}
+
+var packUnits = map[string]packUnit{
+	"g": {"kg", 0.001}, "gm": {"kg", 0.001}, "gms": {"kg", 0.001}, "gram": {"kg", 0.001}, "grams": {"kg", 0.001},
+	"kg": {"kg", 1}, "kgs": {"kg", 1}, "mg": {"kg", 0.000001},
+	"oz": {"kg", 0.0283495}, "lb": {"kg", 0.453592}, "lbs": {"kg", 0.453592},
+	"l": {"l", 1}, "ltr": {"l", 1}, "litre": {"l", 1}, "liter": {"l", 1}, "ml": {"l", 0.001},
+	"pc": {"pc", 1}, "pcs": {"pc", 1}, "pack": {"pc", 1}, "pk": {"pc", 1},
+}
+
+// PackSize reads the pack size from a product name and returns it in
+// kilograms ("kg"), litres ("l") or pieces ("pc"), e.g. "Rice 5kg" -> 5 kg,
+// "Juice 2 x 200ml" -> 0.4 l and "Eggs - 12 pcs" -> 12 pc. ok is false when
+// the name has no recognizable size.
+func PackSize(name string) (amount float64, unit string, ok bool) {
+	words := splitWords(name)
+	multiplier := 1.0
+	for i := 0; i < len(words); i++ {
+		j := strings.IndexFunc(words[i], func(r rune) bool {
+			return !unicode.IsDigit(r) && r != '.'
+		})
+		if j == 0 {
+			continue
+		}
+		number, suffix := words[i], ""
+		if j > 0 {
+			number, suffix = words[i][:j], words[i][j:]
+		}
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		if suffix == "" && i+1 < len(words) {
+			if _, known := packUnits[words[i+1]]; known || words[i+1] == "x" {
+				suffix = words[i+1]
+				i++
+			}
+		}
+
+		if suffix == "x" {
+			multiplier *= n
+			continue
+		}
+		if u, known := packUnits[suffix]; known && unit == "" {
+			amount, unit = n*u.factor, u.base
+		}
+	}
+
+	if unit == "" {
+		if multiplier > 1 {
+			return multiplier, "pc", true
+		}
+		return 0, "", false
+	}
+	return amount * multiplier, unit, true
+}
+
+// splitWords lowercases a name and splits it on anything but letters, digits
+// and decimal points
+func splitWords(name string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.'
+	})
+	words := fields[:0]
+	for _, word := range fields {
+		if word = strings.Trim(word, "."); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// isSize reports whether a word is a bare number, a unit, or a number
+// followed by a unit such as "500g", "1.5l" or "2x"
+func isSize(word string) bool {
+	i := strings.IndexFunc(word, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if i < 0 {
+		return true
+	}
+	return sizeUnits[word[i:]]
+}
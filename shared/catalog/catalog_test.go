@@ -0,0 +1,47 @@
+package catalog
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"Amul Taaza Milk (1 L)": "amul taaza milk",
+		"  BASMATI   Rice 5kg ": "basmati rice",
+		"Eggs - 12 pcs":         "eggs",
+		"Coca-Cola 1.25L":       "coca cola",
+		"Dr. Oetker Ketchup 2x": "dr oetker ketchup",
+		"Vitamin B12 Tablets":   "vitamin b12 tablets",
+		"":                      "",
+	}
+	for name, want := range tests {
+		if got := NormalizeName(name); got != want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPackSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+		unit   string
+		ok     bool
+	}{
+		{"Amul Taaza Milk (1 L)", 1, "l", true},
+		{"BASMATI Rice 5kg", 5, "kg", true},
+		{"Butter 500 g", 0.5, "kg", true},
+		{"Juice 2 x 200ml", 0.4, "l", true},
+		{"Eggs - 12 pcs", 12, "pc", true},
+		{"Dr. Oetker Ketchup 2x", 2, "pc", true},
+		{"Vitamin B12 Tablets", 0, "", false},
+		{"Bread", 0, "", false},
+	}
+	for _, tt := range tests {
+		amount, unit, ok := PackSize(tt.name)
+		if ok != tt.ok || unit != tt.unit || math.Abs(amount-tt.amount) > 1e-9 {
+			t.Errorf("PackSize(%q) = %v, %q, %v; want %v, %q, %v", tt.name, amount, unit, ok, tt.amount, tt.unit, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// Package config provides the runtime settings shared by the backend and the
+// Cloud Functions. Values are read from the system_config Firestore collection,
+// falling back to a local JSON file, environment variables and built-in defaults.
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+
+	"raseed-shared/barcode"
+)
+
+// Collection is the Firestore collection holding configuration documents
+const Collection = "system_config"
+
+// Config holds the tunable runtime settings
+type Config struct {
+	ExpiringSoonWindow    time.Duration `json:"expiring_soon_window"`
+	MaxUploadBytes        int64         `json:"max_upload_bytes"`
+	PerishableCategories  []string      `json:"perishable_categories"`
+	PantryCategories      []string      `json:"pantry_categories"`
+	ReceiptModel          string        `json:"receipt_model"`
+	QueryModel            string        `json:"query_model"`
+	BarcodeType           string        `json:"barcode_type"`           // symbology of pass barcodes, QR_CODE or CODE_128
+	AppLinkBase           string        `json:"app_link_base"`          // base URL of deep links into the app
+	NotificationRetention time.Duration `json:"notification_retention"` // age at which inbox notifications are deleted
+}
+
+// Defaults returns the settings used when nothing else is configured
+func Defaults() Config {
+	return Config{
+		ExpiringSoonWindow: 7 * 24 * time.Hour,
+		MaxUploadBytes:     32 << 20,
+		PerishableCategories: []string{
+			"dairy", "produce", "meat", "seafood", "bakery", "frozen", "beverages",
+			"dairy_products", "fruits", "vegetables", "meat_products", "fish",
+		},
+		PantryCategories: []string{
+			"groceries", "grocery", "pantry", "staples", "snacks", "household",
+		},
+		ReceiptModel: "gemini-pro-vision",
+		QueryModel:   "gemini-pro",
+		BarcodeType:  barcode.QRCode,
+		AppLinkBase:  "https://raseed.app",
+
+		NotificationRetention: 90 * 24 * time.Hour,
+	}
+}
+
+// IsPerishable reports whether items in the category spoil and should be tracked
+func (c Config) IsPerishable(category string) bool {
+	for _, cat := range c.PerishableCategories {
+		if strings.EqualFold(category, cat) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStockable reports whether receipt items in the category belong in the
+// pantry, either because they spoil or because they are everyday groceries
+func (c Config) IsStockable(category string) bool {
+	if c.IsPerishable(category) {
+		return true
+	}
+	for _, cat := range c.PantryCategories {
+		if strings.EqualFold(category, cat) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiryStatus classifies an expiry date as fresh, expiring_soon or expired.
+// Items without an expiry date, such as pantry staples or stock added without
+// one, are always fresh.
+func (c Config) ExpiryStatus(expiry, now time.Time) string {
+	if expiry.IsZero() {
+		return "fresh"
+	}
+	if expiry.Before(now) {
+		return "expired"
+	}
+	if expiry.Sub(now) < c.ExpiringSoonWindow {
+		return "expiring_soon"
+	}
+	return "fresh"
+}
+
+// Documents maps a system_config document ID to its value map. The same shape
+// is used for the Firestore collection and the CONFIG_FILE fallback.
+type Documents map[string]map[string]interface{}
+
+// Source loads configuration documents
+type Source interface {
+	Load(ctx context.Context) (Documents, error)
+}
+
+// FirestoreSource reads the system_config collection
+type FirestoreSource struct {
+	Client *firestore.Client
+}
+
+// Load reads the value map of every document in the collection
+func (s FirestoreSource) Load(ctx context.Context) (Documents, error) {
+	docs := Documents{}
+	iter := s.Client.Collection(Collection).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", Collection, err)
+		}
+
+		var entry struct {
+			Value map[string]interface{} `firestore:"value"`
+		}
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("Skipping malformed config document %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		docs[doc.Ref.ID] = entry.Value
+	}
+	return docs, nil
+}
+
+// Store caches the current configuration and refreshes it from its source at
+// most once per refresh interval. It is safe for concurrent use.
+type Store struct {
+	source   Source
+	fallback Config
+	interval time.Duration
+
+	mu       sync.RWMutex
+	current  Config
+	loadedAt time.Time
+}
+
+// New builds a store from defaults, CONFIG_FILE and environment overrides, then
+// performs an initial load from source. A nil source uses the fallback only.
+func New(ctx context.Context, source Source) *Store {
+	fallback := Defaults()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := applyFile(&fallback, path); err != nil {
+			log.Printf("Failed to read config file %s: %v", path, err)
+		}
+	}
+	applyEnv(&fallback)
+
+	interval := 5 * time.Minute
+	if v := os.Getenv("CONFIG_REFRESH_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+
+	s := &Store{source: source, fallback: fallback, interval: interval, current: fallback}
+	if err := s.Refresh(ctx); err != nil {
+		log.Printf("Using fallback configuration: %v", err)
+	}
+	return s
+}
+
+// NewFirestore is a convenience wrapper around New for a Firestore client
+func NewFirestore(ctx context.Context, client *firestore.Client) *Store {
+	return New(ctx, FirestoreSource{Client: client})
+}
+
+// Refresh reloads the configuration from the source. On failure the previously
+// loaded configuration is kept.
+func (s *Store) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	if s.source == nil {
+		return nil
+	}
+
+	docs, err := s.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg := s.fallback
+	cfg.PerishableCategories = append([]string(nil), s.fallback.PerishableCategories...)
+	cfg.PantryCategories = append([]string(nil), s.fallback.PantryCategories...)
+	apply(&cfg, docs)
+
+	s.mu.Lock()
+	s.current = cfg
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns the current configuration, refreshing it first if it is stale
+func (s *Store) Get(ctx context.Context) Config {
+	s.mu.RLock()
+	stale := time.Since(s.loadedAt) >= s.interval
+	s.mu.RUnlock()
+
+	if stale {
+		if err := s.Refresh(ctx); err != nil {
+			log.Printf("Failed to refresh configuration: %v", err)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Watch refreshes the configuration every interval until ctx is cancelled.
+// Long-running processes use it so requests never block on a reload.
+func (s *Store) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				log.Printf("Failed to refresh configuration: %v", err)
+			}
+		}
+	}
+}
+
+func applyFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var docs Documents
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	apply(cfg, docs)
+	return nil
+}
+
+func applyEnv(cfg *Config) {
+	if v, err := strconv.ParseFloat(os.Getenv("EXPIRING_SOON_DAYS"), 64); err == nil && v > 0 {
+		cfg.ExpiringSoonWindow = time.Duration(v * float64(24*time.Hour))
+	}
+	if v, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_MB"), 10, 64); err == nil && v > 0 {
+		cfg.MaxUploadBytes = v << 20
+	}
+	if v := os.Getenv("PERISHABLE_CATEGORIES"); v != "" {
+		cfg.PerishableCategories = splitList(v)
+	}
+	if v := os.Getenv("PANTRY_CATEGORIES"); v != "" {
+		cfg.PantryCategories = splitList(v)
+	}
+	if v := os.Getenv("RECEIPT_MODEL"); v != "" {
+		cfg.ReceiptModel = v
+	}
+	if v := os.Getenv("QUERY_MODEL"); v != "" {
+		cfg.QueryModel = v
+	}
+	if v, err := barcode.Symbology(os.Getenv("BARCODE_TYPE")); err == nil {
+		cfg.BarcodeType = v
+	}
+	if v := os.Getenv("APP_LINK_BASE"); v != "" {
+		cfg.AppLinkBase = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("NOTIFICATION_RETENTION_DAYS"), 64); err == nil && v > 0 {
+		cfg.NotificationRetention = time.Duration(v * float64(24*time.Hour))
+	}
+}
+
+// apply overlays the known keys of the stock, uploads, models, passes and
+// notifications documents
+func apply(cfg *Config, docs Documents) {
+	if stock, ok := docs["stock"]; ok {
+		if v, ok := number(stock["expiring_soon_days"]); ok && v > 0 {
+			cfg.ExpiringSoonWindow = time.Duration(v * float64(24*time.Hour))
+		}
+		if v, ok := stringList(stock["perishable_categories"]); ok {
+			cfg.PerishableCategories = v
+		}
+		if v, ok := stringList(stock["pantry_categories"]); ok {
+			cfg.PantryCategories = v
+		}
+	}
+	if uploads, ok := docs["uploads"]; ok {
+		if v, ok := number(uploads["max_upload_mb"]); ok && v > 0 {
+			cfg.MaxUploadBytes = int64(v * float64(1<<20))
+		}
+	}
+	if models, ok := docs["models"]; ok {
+		if v, ok := models["receipt_extraction"].(string); ok && v != "" {
+			cfg.ReceiptModel = v
+		}
+		if v, ok := models["query"].(string); ok && v != "" {
+			cfg.QueryModel = v
+		}
+	}
+	if passes, ok := docs["passes"]; ok {
+		if v, ok := passes["barcode_type"].(string); ok {
+			if symbology, err := barcode.Symbology(v); err == nil {
+				cfg.BarcodeType = symbology
+			}
+		}
+		if v, ok := passes["app_link_base"].(string); ok && v != "" {
+			cfg.AppLinkBase = v
+		}
+	}
+	if notifications, ok := docs["notifications"]; ok {
+		if v, ok := number(notifications["retention_days"]); ok && v > 0 {
+			cfg.NotificationRetention = time.Duration(v * float64(24*time.Hour))
+		}
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func stringList(v interface{}) ([]string, bool) {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, 0, len(raw))
+	for _, entry := range raw {
+		if s, ok := entry.(string); ok && s != "" {
+			list = append(list, s)
+		}
+	}
+	return list, true
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	docs Documents
+	err  error
+}
+
+func (f *fakeSource) Load(ctx context.Context) (Documents, error) {
+	return f.docs, f.err
+}
+
+func TestDefaults(t *testing.T) {
+	cfg := Defaults()
+	if cfg.ExpiringSoonWindow != 7*24*time.Hour {
+		t.Errorf("Expected 7 day window, got %v", cfg.ExpiringSoonWindow)
+	}
+	if cfg.MaxUploadBytes != 32<<20 {
+		t.Errorf("Expected 32MB upload limit, got %d", cfg.MaxUploadBytes)
+	}
+	if !cfg.IsPerishable("Dairy") {
+		t.Error("Expected dairy to be perishable")
+	}
+	if cfg.IsPerishable("electronics") {
+		t.Error("Expected electronics not to be perishable")
+	}
+	if !cfg.IsStockable("Groceries") || !cfg.IsStockable("dairy") {
+		t.Error("Expected groceries and dairy to be stockable")
+	}
+	if cfg.IsStockable("electronics") {
+		t.Error("Expected electronics not to be stockable")
+	}
+}
+
+func TestExpiryStatus(t *testing.T) {
+	cfg := Defaults()
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"expired":       now.Add(-time.Hour),
+		"expiring_soon": now.Add(3 * 24 * time.Hour),
+		"fresh":         now.Add(30 * 24 * time.Hour),
+	}
+	for want, expiry := range tests {
+		if got := cfg.ExpiryStatus(expiry, now); got != want {
+			t.Errorf("ExpiryStatus(%v) = %s, want %s", expiry, got, want)
+		}
+	}
+}
+
+func TestExpiryStatusWithoutExpiry(t *testing.T) {
+	cfg := Defaults()
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	if got := cfg.ExpiryStatus(time.Time{}, now); got != "fresh" {
+		t.Errorf("Expected items without expiry to be fresh, got %s", got)
+	}
+	cfg.ExpiringSoonWindow = 0
+	if got := cfg.ExpiryStatus(time.Time{}, now); got != "fresh" {
+		t.Errorf("Expected items without expiry to be fresh with no window, got %s", got)
+	}
+}
+
+func TestStoreAppliesFirestoreDocuments(t *testing.T) {
+	source := &fakeSource{docs: Documents{
+		"stock":         {"expiring_soon_days": int64(3), "perishable_categories": []interface{}{"dairy"}},
+		"uploads":       {"max_upload_mb": float64(10)},
+		"models":        {"receipt_extraction": "gemini-1.5-flash"},
+		"passes":        {"barcode_type": "code128"},
+		"notifications": {"retention_days": int64(30)},
+	}}
+
+	cfg := New(context.Background(), source).Get(context.Background())
+	if cfg.ExpiringSoonWindow != 3*24*time.Hour {
+		t.Errorf("Expected 3 day window, got %v", cfg.ExpiringSoonWindow)
+	}
+	if cfg.MaxUploadBytes != 10<<20 {
+		t.Errorf("Expected 10MB upload limit, got %d", cfg.MaxUploadBytes)
+	}
+	if len(cfg.PerishableCategories) != 1 || cfg.IsPerishable("meat") {
+		t.Errorf("Expected only dairy to be perishable, got %v", cfg.PerishableCategories)
+	}
+	if cfg.ReceiptModel != "gemini-1.5-flash" || cfg.QueryModel != "gemini-pro" {
+		t.Errorf("Unexpected models %s / %s", cfg.ReceiptModel, cfg.QueryModel)
+	}
+	if cfg.BarcodeType != "CODE_128" || cfg.AppLinkBase != "https://raseed.app" {
+		t.Errorf("Unexpected pass settings %s / %s", cfg.BarcodeType, cfg.AppLinkBase)
+	}
+	if cfg.NotificationRetention != 30*24*time.Hour {
+		t.Errorf("Expected 30 day notification retention, got %v", cfg.NotificationRetention)
+	}
+}
+
+func TestStoreKeepsPreviousConfigOnError(t *testing.T) {
+	source := &fakeSource{docs: Documents{"uploads": {"max_upload_mb": float64(8)}}}
+	store := New(context.Background(), source)
+
+	source.err = errors.New("unavailable")
+	if err := store.Refresh(context.Background()); err == nil {
+		t.Fatal("Expected refresh error")
+	}
+	if got := store.Get(context.Background()).MaxUploadBytes; got != 8<<20 {
+		t.Errorf("Expected previous 8MB limit to be kept, got %d", got)
+	}
+}
+
+func TestFileAndEnvFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"stock": {"expiring_soon_days": 2}, "models": {"query": "local-model"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("QUERY_MODEL", "env-model")
+
+	cfg := New(context.Background(), &fakeSource{err: errors.New("offline")}).Get(context.Background())
+	if cfg.ExpiringSoonWindow != 2*24*time.Hour {
+		t.Errorf("Expected file window of 2 days, got %v", cfg.ExpiringSoonWindow)
+	}
+	if cfg.QueryModel != "env-model" {
+		t.Errorf("Expected env to override file, got %s", cfg.QueryModel)
+	}
+}
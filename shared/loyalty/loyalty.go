@@ -0,0 +1,128 @@
+// Package loyalty keeps store loyalty cards as wallet passes, linked to the
+// merchants receipts come from, so the card is at hand at the till.
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"raseed-shared/barcode"
+	"raseed-shared/wallet"
+)
+
+// Card sources
+const (
+	Manual  = "manual"
+	Receipt = "receipt" // read off a receipt by the receipt processor
+)
+
+// Card is a loyalty card as stored in the loyalty_cards collection
+type Card struct {
+	ID            string    `json:"id" firestore:"id"`
+	UserID        string    `json:"user_id" firestore:"user_id"`
+	Program       string    `json:"program" firestore:"program"`
+	MemberID      string    `json:"member_id" firestore:"member_id"` // normalized, see NormalizeMemberID
+	MerchantID    string    `json:"merchant_id" firestore:"merchant_id"`
+	MerchantName  string    `json:"merchant_name" firestore:"merchant_name"`
+	PointsBalance float64   `json:"points_balance" firestore:"points_balance"`
+	PointsAsOf    time.Time `json:"points_as_of" firestore:"points_as_of"`                     // zero when the balance isn't known
+	BarcodeType   string    `json:"barcode_type,omitempty" firestore:"barcode_type,omitempty"` // as printed on the card
+	Source        string    `json:"source" firestore:"source"`                                 // manual, receipt
+	ReceiptID     string    `json:"receipt_id,omitempty" firestore:"receipt_id,omitempty"`     // last receipt the card was seen on
+	CreatedAt     time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// NormalizeMemberID uppercases a member number and drops the spaces and
+// dashes it is often printed with, e.g. "dm 1234-5678" -> "DM12345678"
+func NormalizeMemberID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, id)
+}
+
+// Masked reports whether a member number is only partly printed, as receipts
+// often show just the last digits ("XXXXXX5678", "****5678")
+func Masked(id string) bool {
+	return strings.ContainsAny(id, "*•#") || strings.Contains(strings.ToUpper(id), "XXX")
+}
+
+// MatchesMasked reports whether a full member number fits one printed masked,
+// going by the characters left showing at the end. At least four must show.
+func MatchesMasked(masked, full string) bool {
+	runes := []rune(NormalizeMemberID(masked))
+	n := len(runes)
+	for n > 0 && !strings.ContainsRune("*•#X", runes[n-1]) {
+		n--
+	}
+	shown := string(runes[n:])
+	return len(runes)-n >= 4 && strings.HasSuffix(NormalizeMemberID(full), shown)
+}
+
+// Validate checks the card can be stored and shown as a pass
+func (c Card) Validate() error {
+	switch {
+	case c.UserID == "":
+		return errors.New("user_id is required")
+	case strings.TrimSpace(c.Program) == "":
+		return errors.New("program is required")
+	case c.MemberID == "":
+		return errors.New("member_id is required")
+	case Masked(c.MemberID):
+		return errors.New("member_id is masked")
+	case c.MerchantID == "":
+		return errors.New("merchant_id is required")
+	case c.PointsBalance < 0:
+		return errors.New("points_balance can't be negative")
+	}
+	if c.BarcodeType != "" {
+		if _, err := barcode.New(c.BarcodeType, c.MemberID, ""); err != nil {
+			return fmt.Errorf("invalid barcode_type: %v", err)
+		}
+	}
+	return nil
+}
+
+// RecordPoints sets the points balance unless a later one is already known,
+// so receipts processed out of order don't roll the balance back. It reports
+// whether the balance changed.
+func (c *Card) RecordPoints(balance float64, asOf time.Time) bool {
+	if !c.PointsAsOf.IsZero() && asOf.Before(c.PointsAsOf) {
+		return false
+	}
+	changed := balance != c.PointsBalance || !asOf.Equal(c.PointsAsOf)
+	c.PointsBalance, c.PointsAsOf = balance, asOf
+	return changed
+}
+
+// PassID is the ID of the card's wallet pass
+func (c Card) PassID() string {
+	return "loyalty_" + c.ID
+}
+
+// Pass returns the card's wallet pass document
+func (c Card) Pass() (wallet.Pass, error) {
+	description := fmt.Sprintf("%s member %s", c.MerchantName, c.MemberID)
+	if !c.PointsAsOf.IsZero() {
+		description += fmt.Sprintf(", %s points as of %s", strconv.FormatFloat(c.PointsBalance, 'f', -1, 64), c.PointsAsOf.Format("2006-01-02"))
+	}
+
+	return wallet.NewPass(c.PassID(), c.UserID, "loyalty", c.Program, description,
+		&wallet.LoyaltyPayload{
+			CardID:        c.ID,
+			Program:       c.Program,
+			MemberID:      c.MemberID,
+			MerchantID:    c.MerchantID,
+			MerchantName:  c.MerchantName,
+			PointsBalance: c.PointsBalance,
+			PointsAsOf:    c.PointsAsOf,
+			BarcodeType:   c.BarcodeType,
+		})
+}
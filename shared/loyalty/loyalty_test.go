@@ -0,0 +1,83 @@
+package loyalty
+
+import (
+	"testing"
+	"time"
+
+	"raseed-shared/barcode"
+	"raseed-shared/wallet"
+)
+
+func TestMemberID(t *testing.T) {
+	if got := NormalizeMemberID(" dm 1234-5678 "); got != "DM12345678" {
+		t.Errorf("NormalizeMemberID = %q, want DM12345678", got)
+	}
+	for id, want := range map[string]bool{"XXXXXX5678": true, "****5678": true, "98450 12345": false, "AX12": false} {
+		if got := Masked(id); got != want {
+			t.Errorf("Masked(%q) = %v, want %v", id, got, want)
+		}
+	}
+
+	if !MatchesMasked("XXXXXX2345", "98450 12345") || !MatchesMasked("••••-2345", "9845012345") {
+		t.Error("Expected the shown digits to match the full number")
+	}
+	if MatchesMasked("XXXXXX2346", "9845012345") || MatchesMasked("XXXXXXX45", "9845012345") {
+		t.Error("Expected other digits, or too few, not to match")
+	}
+}
+
+func TestRecordPoints(t *testing.T) {
+	jan := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC)
+
+	var c Card
+	if !c.RecordPoints(120, feb) || c.PointsBalance != 120 {
+		t.Fatalf("Expected the first balance to be recorded, got %v", c.PointsBalance)
+	}
+	// An older receipt processed late leaves the newer balance alone
+	if c.RecordPoints(80, jan) || c.PointsBalance != 120 {
+		t.Errorf("Expected an older balance to be ignored, got %v", c.PointsBalance)
+	}
+	if c.RecordPoints(120, feb) {
+		t.Error("Expected the same balance to be no change")
+	}
+}
+
+func TestPass(t *testing.T) {
+	c := Card{
+		ID:           "c1",
+		UserID:       "u1",
+		Program:      "More Rewards",
+		MemberID:     "9845012345",
+		MerchantID:   "m1",
+		MerchantName: "More Supermarket",
+		BarcodeType:  barcode.Code128,
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c.RecordPoints(250.5, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	pass, err := c.Pass()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass.ID != "loyalty_c1" || pass.Type != "loyalty" || pass.Title != "More Rewards" {
+		t.Errorf("unexpected pass %+v", pass)
+	}
+	if want := "More Supermarket member 9845012345, 250.5 points as of 2024-03-01"; pass.Description != want {
+		t.Errorf("Description = %q, want %q", pass.Description, want)
+	}
+
+	// The member number is scanned in the card's symbology, whatever the
+	// configured default
+	code := wallet.DefaultBarcode(pass, barcode.QRCode, "https://raseed.app")
+	if code == nil || code.Type != barcode.Code128 || code.Value != "9845012345" {
+		t.Errorf("DefaultBarcode = %+v", code)
+	}
+
+	c.MemberID = "XXXXXX2345"
+	if err := c.Validate(); err == nil {
+		t.Error("Expected a masked member number to be rejected")
+	}
+}
@@ -0,0 +1,159 @@
+package merchants
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Directory resolves store names against the merchants collection. It caches
+// the merchant list for fuzzy matching and is safe for concurrent use.
+type Directory struct {
+	client   *firestore.Client
+	interval time.Duration
+
+	mu        sync.Mutex
+	merchants []Merchant
+	loadedAt  time.Time
+}
+
+// NewDirectory returns a directory backed by the merchants collection
+func NewDirectory(client *firestore.Client) *Directory {
+	return &Directory{client: client, interval: 10 * time.Minute}
+}
+
+// Resolve returns the merchant for a store name, creating one when nothing
+// matches. Fuzzy matches record the name as a new alias. categories are the
+// item categories of the receipt or bill, used to categorize new merchants.
+// Names without letters or digits resolve to the zero Merchant.
+func (d *Directory) Resolve(ctx context.Context, name string, categories []string) (Merchant, error) {
+	key := Key(name)
+	if key == "" {
+		return Merchant{}, nil
+	}
+
+	// Known spellings are a single indexed lookup
+	docs, err := d.client.Collection("merchants").Where("alias_keys", "array-contains", key).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return Merchant{}, err
+	}
+	if len(docs) > 0 {
+		var m Merchant
+		err := docs[0].DataTo(&m)
+		return m, err
+	}
+
+	directory, err := d.list(ctx)
+	if err != nil {
+		return Merchant{}, err
+	}
+	if match, _, ok := Match(name, directory); ok {
+		// The cached list can predate a merge; a merged-away merchant
+		// resolves to the one it was merged into
+		m, err := d.get(ctx, match.ID)
+		if err != nil {
+			return Merchant{}, err
+		}
+		if m.AddAlias(name) {
+			_, err := d.client.Collection("merchants").Doc(m.ID).Update(ctx, []firestore.Update{
+				{Path: "aliases", Value: firestore.ArrayUnion(strings.TrimSpace(name))},
+				{Path: "alias_keys", Value: firestore.ArrayUnion(key)},
+				{Path: "updated_at", Value: time.Now()},
+			})
+			if err != nil {
+				return Merchant{}, err
+			}
+		}
+		return m, nil
+	}
+
+	// New merchants are keyed by their first spelling, so two receipts from
+	// the same new store can't create it twice
+	now := time.Now()
+	m := Merchant{
+		ID:        key,
+		Name:      strings.TrimSpace(name),
+		Category:  CategoryFor(categories),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.AddAlias(name)
+	ref := d.client.Collection("merchants").Doc(m.ID)
+	_, err = ref.Create(ctx, m)
+	if status.Code(err) == codes.AlreadyExists {
+		return d.get(ctx, m.ID)
+	}
+	if err != nil {
+		return Merchant{}, err
+	}
+
+	d.mu.Lock()
+	d.merchants = append(d.merchants, m)
+	d.mu.Unlock()
+	return m, nil
+}
+
+// get loads a merchant, following merges to the surviving merchant
+func (d *Directory) get(ctx context.Context, id string) (Merchant, error) {
+	for hops := 0; hops < 5; hops++ {
+		doc, err := d.client.Collection("merchants").Doc(id).Get(ctx)
+		if err != nil {
+			return Merchant{}, err
+		}
+		var m Merchant
+		if err := doc.DataTo(&m); err != nil {
+			return Merchant{}, err
+		}
+		if m.MergedInto == "" {
+			return m, nil
+		}
+		id = m.MergedInto
+	}
+	return Merchant{}, status.Errorf(codes.FailedPrecondition, "merchant %s is merged too many times", id)
+}
+
+// Invalidate drops the cached merchant list, so the next fuzzy match sees
+// merges right away
+func (d *Directory) Invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.merchants = nil
+}
+
+// list returns the active merchants, reloading them when the cache is stale
+func (d *Directory) list(ctx context.Context) ([]Merchant, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.merchants != nil && time.Since(d.loadedAt) < d.interval {
+		return d.merchants, nil
+	}
+
+	iter := d.client.Collection("merchants").Documents(ctx)
+	merchants := []Merchant{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var m Merchant
+		if err := doc.DataTo(&m); err != nil {
+			continue
+		}
+		if m.MergedInto == "" {
+			merchants = append(merchants, m)
+		}
+	}
+
+	d.merchants = merchants
+	d.loadedAt = time.Now()
+	return merchants, nil
+}
@@ -0,0 +1,214 @@
+// Package merchants keeps a directory of canonical merchants, so the store
+// names read off receipts and third-party bills ("DMART", "D-Mart Ltd") resolve
+// to one merchant with a single name, category and location.
+package merchants
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Threshold is the minimum similarity for a fuzzy match
+const Threshold = 0.85
+
+// Location is where a merchant's store is
+type Location struct {
+	Address   string  `json:"address,omitempty" firestore:"address,omitempty"`
+	City      string  `json:"city,omitempty" firestore:"city,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty" firestore:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty" firestore:"longitude,omitempty"`
+}
+
+// Merchant is a canonical store in the directory
+type Merchant struct {
+	ID         string    `json:"id" firestore:"id"`
+	Name       string    `json:"name" firestore:"name"`
+	Aliases    []string  `json:"aliases" firestore:"aliases"`       // spellings seen on receipts and bills
+	AliasKeys  []string  `json:"alias_keys" firestore:"alias_keys"` // Key of the name and every alias
+	Category   string    `json:"category" firestore:"category"`     // MCC code, e.g. 5411
+	Location   *Location `json:"location,omitempty" firestore:"location,omitempty"`
+	MergedInto string    `json:"merged_into,omitempty" firestore:"merged_into,omitempty"`
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// Categories are the supported MCC-style merchant categories
+var Categories = map[string]string{
+	"4121": "Taxicabs and Rideshare",
+	"4814": "Telecommunication Services",
+	"4900": "Utilities",
+	"5311": "Department Stores",
+	"5411": "Grocery Stores, Supermarkets",
+	"5541": "Service Stations",
+	"5651": "Family Clothing Stores",
+	"5732": "Electronics Stores",
+	"5812": "Eating Places, Restaurants",
+	"5912": "Drug Stores, Pharmacies",
+	"5999": "Miscellaneous Retail",
+}
+
+// itemCategories maps receipt item categories to merchant categories
+var itemCategories = map[string]string{
+	"groceries": "5411", "grocery": "5411", "dairy": "5411", "bakery": "5411",
+	"fruits": "5411", "vegetables": "5411", "produce": "5411", "meat": "5411",
+	"grains": "5411", "staples": "5411", "snacks": "5411", "household": "5411",
+	"food": "5812", "restaurant": "5812", "beverage": "5812",
+	"pharmacy": "5912", "medicine": "5912", "health": "5912",
+	"fuel": "5541", "electronics": "5732", "clothing": "5651", "apparel": "5651",
+	"utilities": "4900", "telecom": "4814", "transport": "4121",
+}
+
+// legalWords are dropped from keys so "D-Mart Ltd" and "DMART" match
+var legalWords = map[string]bool{
+	"the": true, "ltd": true, "limited": true, "pvt": true, "private": true,
+	"inc": true, "llc": true, "llp": true, "co": true, "corp": true,
+	"corporation": true, "company": true,
+}
+
+// Key reduces a store name to lowercase letters and digits without legal
+// suffixes, e.g. "D-Mart Ltd." -> "dmart"
+func Key(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, word := range words {
+		if !legalWords[word] {
+			b.WriteString(word)
+		}
+	}
+	// A name made only of legal words ("The Company") keeps them
+	if b.Len() == 0 {
+		return strings.Join(words, "")
+	}
+	return b.String()
+}
+
+// CategoryFor picks the merchant category most of the items belong to,
+// falling back to miscellaneous retail
+func CategoryFor(categories []string) string {
+	counts := make(map[string]int)
+	for _, category := range categories {
+		if code, ok := itemCategories[strings.ToLower(strings.TrimSpace(category))]; ok {
+			counts[code]++
+		}
+	}
+
+	best := "5999"
+	for code, n := range counts {
+		if n > counts[best] || (n == counts[best] && code < best) {
+			best = code
+		}
+	}
+	return best
+}
+
+// Match finds the merchant for a store name. A merchant with the same key
+// wins; otherwise the merchant with the most similar key, if it reaches
+// Threshold. exact reports whether the key was already known.
+func Match(name string, directory []Merchant) (merchant Merchant, exact, ok bool) {
+	key := Key(name)
+	if key == "" {
+		return Merchant{}, false, false
+	}
+
+	best, bestScore := -1, 0.0
+	for i, m := range directory {
+		if m.MergedInto != "" {
+			continue
+		}
+		for _, alias := range m.AliasKeys {
+			if alias == key {
+				return m, true, true
+			}
+			if score := Similarity(key, alias); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+	}
+	if best < 0 || bestScore < Threshold {
+		return Merchant{}, false, false
+	}
+	return directory[best], false, true
+}
+
+// Similarity is 1 minus the edit distance between two keys divided by the
+// length of the longer one
+func Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// AddAlias records another spelling of the merchant's name. It reports
+// whether anything changed.
+func (m *Merchant) AddAlias(name string) bool {
+	name = strings.TrimSpace(name)
+	key := Key(name)
+	if key == "" {
+		return false
+	}
+
+	changed := false
+	if !contains(m.Aliases, name) {
+		m.Aliases = append(m.Aliases, name)
+		changed = true
+	}
+	if !contains(m.AliasKeys, key) {
+		m.AliasKeys = append(m.AliasKeys, key)
+		changed = true
+	}
+	return changed
+}
+
+// Absorb takes over the other merchant's name and aliases, as when two
+// merchants are merged
+func (m *Merchant) Absorb(other Merchant) {
+	m.AddAlias(other.Name)
+	for _, alias := range other.Aliases {
+		m.AddAlias(alias)
+	}
+	for _, key := range other.AliasKeys {
+		if !contains(m.AliasKeys, key) {
+			m.AliasKeys = append(m.AliasKeys, key)
+		}
+	}
+	sort.Strings(m.AliasKeys)
+	if m.Location == nil {
+		m.Location = other.Location
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
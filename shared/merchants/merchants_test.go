@@ -0,0 +1,70 @@
+package merchants
+
+import "testing"
+
+func TestKey(t *testing.T) {
+	tests := map[string]string{
+		"DMART":              "dmart",
+		"D-Mart Ltd.":        "dmart",
+		"The Burger House":   "burgerhouse",
+		"Reliance Fresh Pvt": "reliancefresh",
+		"The Company":        "thecompany",
+		"  ":                 "",
+	}
+	for name, want := range tests {
+		if got := Key(name); got != want {
+			t.Errorf("Key(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	directory := []Merchant{
+		{ID: "dmart", Name: "D-Mart", AliasKeys: []string{"dmart", "avenuesupermarts"}},
+		{ID: "old", Name: "Old Mart", AliasKeys: []string{"oldmart"}, MergedInto: "dmart"},
+		{ID: "reliancefresh", Name: "Reliance Fresh", AliasKeys: []string{"reliancefresh"}},
+	}
+
+	m, exact, ok := Match("Avenue Supermarts Ltd", directory)
+	if !ok || !exact || m.ID != "dmart" {
+		t.Errorf("Expected exact alias match to dmart, got %+v %v %v", m, exact, ok)
+	}
+
+	m, exact, ok = Match("Relianse Fresh", directory)
+	if !ok || exact || m.ID != "reliancefresh" {
+		t.Errorf("Expected fuzzy match to reliancefresh, got %+v %v %v", m, exact, ok)
+	}
+
+	if _, _, ok := Match("Old Mart", directory); ok {
+		t.Error("Expected merged merchants to be skipped")
+	}
+	if _, _, ok := Match("Reliance Digital", directory); ok {
+		t.Error("Expected a different store not to match")
+	}
+}
+
+func TestCategoryFor(t *testing.T) {
+	if got := CategoryFor([]string{"Dairy", "bakery", "food"}); got != "5411" {
+		t.Errorf("Expected grocery, got %s", got)
+	}
+	if got := CategoryFor([]string{"toys"}); got != "5999" {
+		t.Errorf("Expected miscellaneous retail, got %s", got)
+	}
+}
+
+func TestAbsorb(t *testing.T) {
+	target := Merchant{ID: "dmart", Name: "D-Mart", Aliases: []string{"DMART"}, AliasKeys: []string{"dmart"}}
+	source := Merchant{ID: "avenuesupermarts", Name: "Avenue Supermarts", Aliases: []string{"Avenue Supermarts Ltd"},
+		AliasKeys: []string{"avenuesupermarts"}, Location: &Location{City: "Pune"}}
+
+	target.Absorb(source)
+	if len(target.Aliases) != 3 || len(target.AliasKeys) != 2 {
+		t.Errorf("Expected aliases to be combined, got %+v", target)
+	}
+	if target.Location == nil || target.Location.City != "Pune" {
+		t.Errorf("Expected the source location to be kept, got %+v", target.Location)
+	}
+	if target.AddAlias("D Mart") != true || target.AddAlias("D Mart") != false {
+		t.Error("Expected AddAlias to report changes only once")
+	}
+}
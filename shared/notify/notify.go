@@ -0,0 +1,174 @@
+// Package notify describes user notifications and how each user wants them
+// delivered: on which channels, for which types, and when to keep quiet.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Channels, in the order notifications go out on them
+const (
+	Inbox   = "inbox"   // the app's notification inbox
+	Push    = "push"    // Firebase Cloud Messaging
+	Email   = "email"   // SMTP
+	Webhook = "webhook" // POST to a URL of the user's
+)
+
+// AllChannels lists the channels in delivery order
+var AllChannels = []string{Inbox, Push, Email, Webhook}
+
+// defaults are the channels used when a user hasn't chosen. Email and
+// webhooks are opt-in.
+var defaults = map[string]bool{Inbox: true, Push: true}
+
+// Delivery statuses
+const (
+	StatusSent    = "sent"
+	StatusStubbed = "stubbed" // handed to a local stub instead of the real service
+	StatusSkipped = "skipped" // the user has no address for the channel
+	StatusHeld    = "held"    // push waiting for quiet hours to end, at next_attempt_at
+	StatusFailed  = "failed"
+)
+
+// Event is a notification as published to notification-events
+type Event struct {
+	ID      string                 `json:"id,omitempty"` // optional; defaults to the Pub/Sub event ID, so redeliveries aren't delivered twice
+	UserID  string                 `json:"user_id"`
+	Type    string                 `json:"type"` // e.g. stock_expiry, stock_digest, warranty_reminder, replenishment
+	Title   string                 `json:"title"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Validate checks the event can be delivered
+func (e Event) Validate() error {
+	if e.UserID == "" || e.Type == "" {
+		return errors.New("user_id and type are required")
+	}
+	if strings.TrimSpace(e.Title) == "" && strings.TrimSpace(e.Message) == "" {
+		return errors.New("title or message is required")
+	}
+	return nil
+}
+
+// Preferences are a user's notification settings, kept in the users
+// collection under preferences.notifications
+type Preferences struct {
+	Channels   map[string]bool     `json:"channels,omitempty" firestore:"channels,omitempty"` // channel on or off; unset channels use the defaults
+	Types      map[string][]string `json:"types,omitempty" firestore:"types,omitempty"`       // channels for one type, in place of Channels; empty mutes the type
+	QuietHours *QuietHours         `json:"quiet_hours,omitempty" firestore:"quiet_hours,omitempty"`
+	WebhookURL string              `json:"webhook_url,omitempty" firestore:"webhook_url,omitempty"`
+}
+
+// ChannelsFor returns the channels a notification type goes out on, in
+// delivery order
+func (p Preferences) ChannelsFor(notificationType string) []string {
+	enabled := func(channel string) bool {
+		if on, ok := p.Channels[channel]; ok {
+			return on
+		}
+		return defaults[channel]
+	}
+	if chosen, ok := p.Types[notificationType]; ok {
+		enabled = func(channel string) bool {
+			for _, c := range chosen {
+				if c == channel {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	var channels []string
+	for _, channel := range AllChannels {
+		if enabled(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// Held reports whether a channel stays silent for quiet hours at t, and
+// until when. Only push notifications, which sound on the user's phone, are
+// held back; the inbox, email and webhooks are read when the user chooses.
+func (p Preferences) Held(channel string, t time.Time) (time.Time, bool) {
+	if channel != Push || p.QuietHours == nil {
+		return time.Time{}, false
+	}
+	if quiet, err := p.QuietHours.Contains(t); err != nil || !quiet {
+		return time.Time{}, false
+	}
+	end, err := p.QuietHours.Until(t)
+	return end, err == nil
+}
+
+// QuietHours is a daily window, in the user's time zone, without push
+// notifications. A window that ends before it starts runs past midnight.
+type QuietHours struct {
+	Start    string `json:"start" firestore:"start"`                             // HH:MM
+	End      string `json:"end" firestore:"end"`                                 // HH:MM
+	TimeZone string `json:"time_zone,omitempty" firestore:"time_zone,omitempty"` // IANA name; UTC when empty
+}
+
+// Validate checks the window's times and time zone
+func (q QuietHours) Validate() error {
+	_, _, _, err := q.parse()
+	return err
+}
+
+// Contains reports whether t falls within the window
+func (q QuietHours) Contains(t time.Time) (bool, error) {
+	start, end, loc, err := q.parse()
+	if err != nil {
+		return false, err
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end, nil
+	}
+	return minute >= start || minute < end, nil
+}
+
+// Until returns the first end of the window after t
+func (q QuietHours) Until(t time.Time) (time.Time, error) {
+	_, end, loc, err := q.parse()
+	if err != nil {
+		return time.Time{}, err
+	}
+	local := t.In(loc)
+	until := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+	if !until.After(local) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, nil
+}
+
+func (q QuietHours) parse() (start, end int, loc *time.Location, err error) {
+	if start, err = minuteOfDay(q.Start); err != nil {
+		return
+	}
+	if end, err = minuteOfDay(q.End); err != nil {
+		return
+	}
+	if start == end {
+		return 0, 0, nil, errors.New("quiet hours must start and end at different times")
+	}
+	if loc, err = time.LoadLocation(q.TimeZone); err != nil {
+		return 0, 0, nil, fmt.Errorf("unknown time zone %q", q.TimeZone)
+	}
+	return start, end, loc, nil
+}
+
+// minuteOfDay reads an HH:MM time as minutes after midnight
+func minuteOfDay(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChannelsFor(t *testing.T) {
+	var p Preferences
+	if got := p.ChannelsFor("stock_expiry"); !reflect.DeepEqual(got, []string{Inbox, Push}) {
+		t.Errorf("default channels = %v, want inbox and push", got)
+	}
+
+	p = Preferences{
+		Channels: map[string]bool{Push: false, Email: true},
+		Types:    map[string][]string{"warranty_reminder": {Email, Inbox}, "stock_digest": {}},
+	}
+	if got := p.ChannelsFor("stock_expiry"); !reflect.DeepEqual(got, []string{Inbox, Email}) {
+		t.Errorf("channels = %v, want inbox and email", got)
+	}
+	// A type's own list replaces the channel settings, in delivery order
+	if got := p.ChannelsFor("warranty_reminder"); !reflect.DeepEqual(got, []string{Inbox, Email}) {
+		t.Errorf("warranty channels = %v, want inbox and email", got)
+	}
+	if got := p.ChannelsFor("stock_digest"); len(got) != 0 {
+		t.Errorf("Expected a muted type to go nowhere, got %v", got)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	q := QuietHours{Start: "22:00", End: "07:00", TimeZone: "Asia/Kolkata"}
+	for utc, want := range map[string]bool{
+		"2026-01-10T16:29:00Z": false, // 21:59 IST
+		"2026-01-10T16:30:00Z": true,  // 22:00 IST
+		"2026-01-10T20:00:00Z": true,  // 01:30 IST
+		"2026-01-11T01:30:00Z": false, // 07:00 IST
+	} {
+		at, _ := time.Parse(time.RFC3339, utc)
+		if got, err := q.Contains(at); err != nil || got != want {
+			t.Errorf("Contains(%s) = %v, %v; want %v", utc, got, err, want)
+		}
+	}
+
+	day := QuietHours{Start: "13:00", End: "14:00"}
+	if quiet, _ := day.Contains(time.Date(2026, 1, 10, 13, 30, 0, 0, time.UTC)); !quiet {
+		t.Error("Expected 13:30 UTC to be within 13:00-14:00")
+	}
+
+	for _, bad := range []QuietHours{{Start: "25:00", End: "07:00"}, {Start: "22:00", End: "22:00"}, {Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus"}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", bad)
+		}
+	}
+}
+
+func TestHeld(t *testing.T) {
+	p := Preferences{QuietHours: &QuietHours{Start: "22:00", End: "07:00"}}
+	night := time.Date(2026, 1, 10, 23, 0, 0, 0, time.UTC)
+	until, held := p.Held(Push, night)
+	if !held || !until.Equal(time.Date(2026, 1, 11, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected push to be held until 07:00, got %v, %v", until, held)
+	}
+	if until, held := p.Held(Push, night.Add(2*time.Hour)); !held || !until.Equal(time.Date(2026, 1, 11, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected push after midnight to be held until 07:00, got %v, %v", until, held)
+	}
+	if _, held := p.Held(Inbox, night); held {
+		t.Error("Expected only push to be held")
+	}
+	if _, held := p.Held(Email, night); held {
+		t.Error("Expected only push to be held")
+	}
+	if _, held := p.Held(Push, night.Add(9*time.Hour)); held {
+		t.Error("Expected push to go out in the morning")
+	}
+}
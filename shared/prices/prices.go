@@ -0,0 +1,160 @@
+// Package prices builds an item price index from receipt line items. Every
+// line becomes a price point with a unit price per kilogram, litre or piece,
+// so the same product can be compared over time and across stores whatever
+// pack size it was bought in.
+package prices
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"raseed-shared/catalog"
+)
+
+// Each is the unit of items without a recognizable pack size
+const Each = "each"
+
+// Point is the price paid for one product on one receipt
+type Point struct {
+	ID             string    `json:"id" firestore:"id"` // {receipt_id}_{line}
+	UserID         string    `json:"user_id" firestore:"user_id"`
+	HouseholdID    string    `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	ReceiptID      string    `json:"receipt_id" firestore:"receipt_id"`
+	Store          string    `json:"store" firestore:"store"`
+	StoreKey       string    `json:"store_key" firestore:"store_key"` // merchant ID, or the lowercased store name
+	Name           string    `json:"name" firestore:"name"`
+	NormalizedName string    `json:"normalized_name" firestore:"normalized_name"`
+	Category       string    `json:"category" firestore:"category"`
+	Price          float64   `json:"price" firestore:"price"` // per pack, as printed
+	PackSize       float64   `json:"pack_size" firestore:"pack_size"`
+	Unit           string    `json:"unit" firestore:"unit"` // kg, l, pc or each
+	UnitPrice      float64   `json:"unit_price" firestore:"unit_price"`
+	Date           time.Time `json:"date" firestore:"date"`
+}
+
+// NewPoint prices a line item by its pack size. Items without a size are
+// priced per item.
+func NewPoint(name, category, store string, price float64, date time.Time) Point {
+	p := Point{
+		Store:          strings.TrimSpace(store),
+		StoreKey:       StoreKey(store),
+		Name:           strings.TrimSpace(name),
+		NormalizedName: catalog.NormalizeName(name),
+		Category:       category,
+		Price:          price,
+		PackSize:       1,
+		Unit:           Each,
+		UnitPrice:      price,
+		Date:           date,
+	}
+	if amount, unit, ok := catalog.PackSize(name); ok {
+		p.PackSize, p.Unit = amount, unit
+		p.UnitPrice = round(price/amount, 4)
+	}
+	return p
+}
+
+// StoreKey groups spellings of the same store name when its merchant is unknown
+func StoreKey(store string) string {
+	return strings.Join(strings.Fields(strings.ToLower(store)), " ")
+}
+
+// StoreHistory is the price history of a product at one store
+type StoreHistory struct {
+	Store           string    `json:"store"`
+	LatestUnitPrice float64   `json:"latest_unit_price"`
+	LatestDate      time.Time `json:"latest_date"`
+	MinUnitPrice    float64   `json:"min_unit_price"`
+	MaxUnitPrice    float64   `json:"max_unit_price"`
+	ChangePercent   float64   `json:"change_percent"` // latest vs first price at this store
+	Points          []Point   `json:"points"`         // oldest first
+}
+
+// Summary compares a product's prices over time and across stores
+type Summary struct {
+	Item           string         `json:"item"`
+	NormalizedName string         `json:"normalized_name"`
+	Unit           string         `json:"unit"`
+	ChangePercent  float64        `json:"change_percent"` // latest vs first price anywhere
+	Stores         []StoreHistory `json:"stores"`         // cheapest latest price first
+	Cheapest       *StoreHistory  `json:"cheapest,omitempty"`
+}
+
+// Summarize groups a product's price points by store. Points are compared in
+// the product's most common unit; points in other units are left out. The
+// cheapest store is the one with the lowest latest price among stores
+// visited within recent of now.
+func Summarize(points []Point, now time.Time, recent time.Duration) Summary {
+	var s Summary
+	if len(points) == 0 {
+		return s
+	}
+
+	counts := make(map[string]int)
+	for _, p := range points {
+		counts[p.Unit]++
+	}
+	for unit, n := range counts {
+		if n > counts[s.Unit] || (n == counts[s.Unit] && unit < s.Unit) {
+			s.Unit = unit
+		}
+	}
+
+	var kept []Point
+	for _, p := range points {
+		if p.Unit == s.Unit {
+			kept = append(kept, p)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Date.Before(kept[j].Date) })
+
+	latest := kept[len(kept)-1]
+	s.Item, s.NormalizedName = latest.Name, latest.NormalizedName
+	s.ChangePercent = changePercent(kept[0].UnitPrice, latest.UnitPrice)
+
+	stores := make(map[string]*StoreHistory)
+	var order []string
+	for _, p := range kept {
+		h := stores[p.StoreKey]
+		if h == nil {
+			h = &StoreHistory{MinUnitPrice: p.UnitPrice, MaxUnitPrice: p.UnitPrice}
+			stores[p.StoreKey] = h
+			order = append(order, p.StoreKey)
+		}
+		h.Store = p.Store
+		h.Points = append(h.Points, p)
+		h.LatestUnitPrice, h.LatestDate = p.UnitPrice, p.Date
+		h.MinUnitPrice = math.Min(h.MinUnitPrice, p.UnitPrice)
+		h.MaxUnitPrice = math.Max(h.MaxUnitPrice, p.UnitPrice)
+	}
+
+	for _, key := range order {
+		h := stores[key]
+		h.ChangePercent = changePercent(h.Points[0].UnitPrice, h.LatestUnitPrice)
+		s.Stores = append(s.Stores, *h)
+	}
+	sort.SliceStable(s.Stores, func(i, j int) bool { return s.Stores[i].LatestUnitPrice < s.Stores[j].LatestUnitPrice })
+
+	for i := range s.Stores {
+		if now.Sub(s.Stores[i].LatestDate) <= recent {
+			cheapest := s.Stores[i]
+			s.Cheapest = &cheapest
+			break
+		}
+	}
+	return s
+}
+
+func changePercent(from, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return round((to-from)/from*100, 1)
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
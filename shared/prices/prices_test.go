@@ -0,0 +1,62 @@
+package prices
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewPoint(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	p := NewPoint("Amul Milk 500ml", "dairy", " Fresh Mart ", 30, date)
+	if p.NormalizedName != "amul milk" || p.Unit != "l" || p.PackSize != 0.5 || p.UnitPrice != 60 {
+		t.Errorf("Unexpected point %+v", p)
+	}
+	if p.Store != "Fresh Mart" || p.StoreKey != "fresh mart" {
+		t.Errorf("Expected trimmed store name, got %q / %q", p.Store, p.StoreKey)
+	}
+
+	p = NewPoint("Bread", "bakery", "Fresh Mart", 40, date)
+	if p.Unit != Each || p.UnitPrice != 40 {
+		t.Errorf("Expected bread priced per item, got %+v", p)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	now := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return now.Add(-time.Duration(d) * 24 * time.Hour) }
+
+	points := []Point{
+		NewPoint("Milk 1L", "dairy", "Fresh Mart", 50, day(60)),
+		NewPoint("Milk 1L", "dairy", "fresh mart", 57.5, day(5)),
+		NewPoint("Milk 500ml", "dairy", "Corner Store", 26, day(10)),
+		NewPoint("Milk 1L", "dairy", "Big Bazaar", 48, day(120)),
+		NewPoint("Milk", "dairy", "Corner Store", 10, day(1)),
+	}
+
+	s := Summarize(points, now, 90*24*time.Hour)
+	if s.Unit != "l" || s.NormalizedName != "milk" {
+		t.Fatalf("Expected milk compared per litre, got %+v", s)
+	}
+	if len(s.Stores) != 3 {
+		t.Fatalf("Expected 3 stores, got %+v", s.Stores)
+	}
+	if s.Stores[0].Store != "Big Bazaar" || s.Stores[1].Store != "Corner Store" || s.Stores[2].Store != "fresh mart" {
+		t.Errorf("Expected stores ordered by latest price, got %+v", s.Stores)
+	}
+	if s.Stores[2].ChangePercent != 15 || len(s.Stores[2].Points) != 2 {
+		t.Errorf("Expected Fresh Mart up 15%%, got %+v", s.Stores[2])
+	}
+	if s.ChangePercent != 19.8 {
+		t.Errorf("Expected overall change of 19.8%%, got %v", s.ChangePercent)
+	}
+
+	// Big Bazaar is cheapest but hasn't been visited in 120 days
+	if s.Cheapest == nil || s.Cheapest.Store != "Corner Store" || s.Cheapest.LatestUnitPrice != 52 {
+		t.Errorf("Expected Corner Store to be the cheapest recent store, got %+v", s.Cheapest)
+	}
+
+	if s := Summarize(nil, now, time.Hour); s.Cheapest != nil || len(s.Stores) != 0 {
+		t.Errorf("Expected an empty summary, got %+v", s)
+	}
+}
@@ -0,0 +1,152 @@
+// Package shelflife estimates how long groceries keep, so stock items added
+// without an expiry date still get one. Estimates come from a small table of
+// typical shelf lives by product and category for each storage location.
+package shelflife
+
+import (
+	"strings"
+	"time"
+
+	"raseed-shared/catalog"
+)
+
+// Storage locations
+const (
+	Pantry  = "pantry"
+	Fridge  = "fridge"
+	Freezer = "freezer"
+)
+
+// Life is the typical shelf life in days for each storage location. Missing
+// locations mean the item isn't usually kept there.
+type Life map[string]int
+
+// products maps product keywords, matched against whole words of the
+// normalized item name, to their shelf life. They take precedence over
+// categories.
+var products = map[string]Life{
+	"milk":        {Fridge: 7, Freezer: 90},
+	"curd":        {Fridge: 7},
+	"yogurt":      {Fridge: 14},
+	"paneer":      {Fridge: 5, Freezer: 90},
+	"butter":      {Fridge: 60, Freezer: 270},
+	"ghee":        {Pantry: 270, Fridge: 365},
+	"cheese":      {Fridge: 30, Freezer: 180},
+	"cream":       {Fridge: 7},
+	"ice cream":   {Freezer: 60},
+	"eggs":        {Fridge: 28},
+	"egg":         {Fridge: 28},
+	"bread":       {Pantry: 5, Fridge: 10, Freezer: 90},
+	"bun":         {Pantry: 3, Freezer: 60},
+	"cake":        {Pantry: 3, Fridge: 7, Freezer: 90},
+	"banana":      {Pantry: 5},
+	"bananas":     {Pantry: 5},
+	"apple":       {Pantry: 7, Fridge: 30},
+	"apples":      {Pantry: 7, Fridge: 30},
+	"tomato":      {Pantry: 5, Fridge: 14},
+	"tomatoes":    {Pantry: 5, Fridge: 14},
+	"onion":       {Pantry: 30},
+	"onions":      {Pantry: 30},
+	"potato":      {Pantry: 30},
+	"potatoes":    {Pantry: 30},
+	"spinach":     {Fridge: 5},
+	"coriander":   {Fridge: 7},
+	"chicken":     {Fridge: 2, Freezer: 270},
+	"mutton":      {Fridge: 3, Freezer: 180},
+	"fish":        {Fridge: 2, Freezer: 180},
+	"prawns":      {Fridge: 2, Freezer: 180},
+	"rice":        {Pantry: 365},
+	"atta":        {Pantry: 90},
+	"flour":       {Pantry: 180},
+	"dal":         {Pantry: 365},
+	"sugar":       {Pantry: 730},
+	"salt":        {Pantry: 1825},
+	"oil":         {Pantry: 365},
+	"biscuits":    {Pantry: 180},
+	"chips":       {Pantry: 90},
+	"juice":       {Pantry: 180, Fridge: 7},
+	"soft drink":  {Pantry: 270},
+	"frozen peas": {Freezer: 270},
+}
+
+// categories gives the fallback shelf life for each item category
+var categories = map[string]Life{
+	"dairy":          {Fridge: 10, Freezer: 90},
+	"dairy_products": {Fridge: 10, Freezer: 90},
+	"produce":        {Pantry: 4, Fridge: 7},
+	"fruits":         {Pantry: 5, Fridge: 10},
+	"vegetables":     {Pantry: 4, Fridge: 7},
+	"meat":           {Fridge: 3, Freezer: 180},
+	"meat_products":  {Fridge: 3, Freezer: 180},
+	"seafood":        {Fridge: 2, Freezer: 180},
+	"fish":           {Fridge: 2, Freezer: 180},
+	"bakery":         {Pantry: 4, Fridge: 7, Freezer: 90},
+	"frozen":         {Freezer: 180},
+	"beverages":      {Pantry: 180, Fridge: 14},
+	"groceries":      {Pantry: 180},
+	"grocery":        {Pantry: 180},
+	"pantry":         {Pantry: 180},
+	"staples":        {Pantry: 365},
+	"snacks":         {Pantry: 90},
+}
+
+// IsValidStorage reports whether storage is a known location
+func IsValidStorage(storage string) bool {
+	return storage == Pantry || storage == Fridge || storage == Freezer
+}
+
+// DefaultStorage returns where items of the category are usually kept
+func DefaultStorage(category string) string {
+	switch strings.ToLower(category) {
+	case "dairy", "dairy_products", "meat", "meat_products", "seafood", "fish":
+		return Fridge
+	case "frozen":
+		return Freezer
+	}
+	return Pantry
+}
+
+// Estimate returns the typical shelf life of the item when kept in storage. A
+// product match is tried first, then the category. An empty storage uses the
+// category's default storage.
+func Estimate(name, category, storage string) (time.Duration, bool) {
+	if storage == "" {
+		storage = DefaultStorage(category)
+	}
+
+	if life, ok := matchProduct(name); ok {
+		if days, ok := life[storage]; ok {
+			return time.Duration(days) * 24 * time.Hour, true
+		}
+	}
+	if life, ok := categories[strings.ToLower(category)]; ok {
+		if days, ok := life[storage]; ok {
+			return time.Duration(days) * 24 * time.Hour, true
+		}
+	}
+	return 0, false
+}
+
+// ExpiryDate estimates the expiry date of an item bought at purchase
+func ExpiryDate(purchase time.Time, name, category, storage string) (time.Time, bool) {
+	life, ok := Estimate(name, category, storage)
+	if !ok {
+		return time.Time{}, false
+	}
+	return purchase.Add(life), true
+}
+
+// matchProduct finds the longest product keyword contained in the name
+func matchProduct(name string) (Life, bool) {
+	padded := " " + catalog.NormalizeName(name) + " "
+	best := ""
+	for keyword := range products {
+		if len(keyword) > len(best) && strings.Contains(padded, " "+keyword+" ") {
+			best = keyword
+		}
+	}
+	if best == "" {
+		return nil, false
+	}
+	return products[best], true
+}
@@ -0,0 +1,45 @@
+package shelflife
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimate(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name, category, storage string
+		want                    time.Duration
+		ok                      bool
+	}{
+		{"Amul Taaza Milk 1L", "dairy", "", 7 * day, true},
+		{"Amul Taaza Milk 1L", "dairy", Freezer, 90 * day, true},
+		{"Vanilla Ice Cream", "dairy", Freezer, 60 * day, true},
+		{"Greek Yogurt", "dairy", Fridge, 14 * day, true},
+		{"Sourdough Bread", "bakery", Freezer, 90 * day, true},
+		{"Mystery Cheese Spread", "", Fridge, 30 * day, true},
+		{"Bhindi", "vegetables", "", 4 * day, true},
+		{"Bhindi", "vegetables", Freezer, 0, false},
+		{"USB Cable", "electronics", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Estimate(tt.name, tt.category, tt.storage)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Estimate(%q, %q, %q) = %v, %v, want %v, %v", tt.name, tt.category, tt.storage, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExpiryDate(t *testing.T) {
+	purchase := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	got, ok := ExpiryDate(purchase, "Eggs (12 pcs)", "dairy", "")
+	if !ok || !got.Equal(purchase.AddDate(0, 0, 28)) {
+		t.Errorf("Expected eggs to keep 28 days, got %v %v", got, ok)
+	}
+}
+
+func TestDefaultStorage(t *testing.T) {
+	if DefaultStorage("Dairy") != Fridge || DefaultStorage("frozen") != Freezer || DefaultStorage("snacks") != Pantry {
+		t.Error("Unexpected default storage")
+	}
+}
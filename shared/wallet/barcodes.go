@@ -0,0 +1,51 @@
+package wallet
+
+import (
+	"strings"
+
+	"raseed-shared/barcode"
+)
+
+// DefaultBarcode is the code a pass carries unless it is given one: a deep
+// link into the app for receipt and pantry passes, the return authorization
+// for proof-of-purchase passes and the member number, in the card's own
+// symbology, for loyalty cards. Other passes get none. Values the symbology
+// can't hold fall back to a QR code.
+func DefaultBarcode(pass Pass, symbology, linkBase string) *barcode.Barcode {
+	payload, err := pass.TypedPayload()
+	if err != nil {
+		return nil
+	}
+
+	var value, text string
+	switch p := payload.(type) {
+	case *ReceiptPayload:
+		value, text = barcode.Link(linkBase, "receipts", p.ReceiptID), p.StoreName
+	case *StockItemPayload:
+		value, text = barcode.Link(linkBase, "stock-items", p.ItemID), p.Name
+	case *WarrantyPayload:
+		value = p.ReturnAuthorization()
+		text = value
+	case *LoyaltyPayload:
+		value, text = p.MemberID, p.MemberID
+		if p.BarcodeType != "" {
+			symbology = p.BarcodeType
+		}
+	default:
+		return nil
+	}
+
+	code, err := barcode.New(symbology, value, text)
+	if err != nil {
+		if code, err = barcode.New(barcode.QRCode, value, text); err != nil {
+			return nil
+		}
+	}
+	return &code
+}
+
+// ReturnAuthorization is the code store staff scan to find the purchase
+// behind a return
+func (p *WarrantyPayload) ReturnAuthorization() string {
+	return "RA-" + strings.ToUpper(p.WarrantyID)
+}
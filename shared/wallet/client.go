@@ -0,0 +1,65 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Scope is the OAuth scope the Wallet REST API needs
+const Scope = "https://www.googleapis.com/auth/wallet_object.issuer"
+
+// ErrNotSaved is returned when updating an object nobody has saved yet. Wallet
+// creates objects from the save link, which always carries the latest pass.
+var ErrNotSaved = errors.New("wallet object not saved yet")
+
+// Client updates issued objects through the Google Wallet REST API
+type Client struct {
+	http    *http.Client // authorized for Scope
+	baseURL string
+}
+
+// NewClient wraps an HTTP client authorized for Scope
+func NewClient(httpClient *http.Client) *Client {
+	return &Client{http: httpClient, baseURL: "https://walletobjects.googleapis.com/walletobjects/v1"}
+}
+
+// PatchObject changes the given fields of a Generic object
+func (c *Client) PatchObject(ctx context.Context, objectID string, fields map[string]interface{}) error {
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch,
+		c.baseURL+"/genericObject/"+url.PathEscape(objectID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotSaved
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("wallet API returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// Revoke makes an object inactive. Wallet objects can't be deleted, so this is
+// how a deleted pass leaves users' wallets.
+func (c *Client) Revoke(ctx context.Context, objectID string) error {
+	return c.PatchObject(ctx, objectID, map[string]interface{}{"state": objectStates[StateRevoked]})
+}
@@ -0,0 +1,49 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// EventTopic is the Pub/Sub topic the wallet pass creator consumes
+const EventTopic = "wallet-pass-creation"
+
+// Event tells the wallet pass creator that a pass was created, updated or
+// deleted, so its Wallet object follows the document
+type Event struct {
+	PassID         string `json:"pass_id"`
+	UserID         string `json:"user_id"`
+	Type           string `json:"type"`
+	Title          string `json:"title"`
+	Action         string `json:"action"`           // created, updated, deleted; empty means created
+	WalletObjectID string `json:"wallet_object_id"` // for deleted passes, whose document is gone
+}
+
+// Event returns the event for an action on the pass. objectID is only needed
+// for deleted passes.
+func (p Pass) Event(action, objectID string) Event {
+	return Event{
+		PassID:         p.ID,
+		UserID:         p.UserID,
+		Type:           p.Type,
+		Title:          p.Title,
+		Action:         action,
+		WalletObjectID: objectID,
+	}
+}
+
+// Publish sends the event to the wallet pass creator and waits for Pub/Sub
+// to accept it
+func Publish(ctx context.Context, topic *pubsub.Topic, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal wallet pass event: %v", err)
+	}
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx); err != nil {
+		return fmt.Errorf("failed to publish wallet pass event for %s: %v", event.PassID, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Signer signs "Save to Google Wallet" JWTs with a service account key
+type Signer struct {
+	IssuerID string   // Google Wallet issuer
+	Email    string   // service account the issuer trusts
+	Origins  []string // sites allowed to show the save button, optional
+
+	key *rsa.PrivateKey
+	now func() time.Time
+}
+
+// NewSigner reads a service account JSON key file, the kind downloaded from
+// the Cloud console, for the issuer
+func NewSigner(issuerID string, keyFile []byte) (*Signer, error) {
+	if issuerID == "" {
+		return nil, errors.New("issuer ID is required")
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(keyFile, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %v", err)
+	}
+	if account.ClientEmail == "" {
+		return nil, errors.New("service account key has no client_email")
+	}
+
+	key, err := parseKey([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{IssuerID: issuerID, Email: account.ClientEmail, key: key, now: time.Now}, nil
+}
+
+// parseKey reads a PEM RSA private key in PKCS#8 or PKCS#1 form
+func parseKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("service account key has no PEM private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// SaveJWT signs a JWT that adds the passes to the wallet of whoever opens its
+// save link. Their classes are included so they are created on first use.
+func (s *Signer) SaveJWT(passes ...Pass) (string, error) {
+	if len(passes) == 0 {
+		return "", errors.New("no passes to save")
+	}
+
+	var classes, objects []map[string]interface{}
+	seen := make(map[string]bool)
+	for _, pass := range passes {
+		kind := KindOf(pass.Type)
+		if !seen[kind.Name] {
+			seen[kind.Name] = true
+			classes = append(classes, GenericClass(s.IssuerID, kind))
+		}
+		objects = append(objects, GenericObject(s.IssuerID, pass))
+	}
+
+	claims := map[string]interface{}{
+		"iss": s.Email,
+		"aud": "google",
+		"typ": "savetowallet",
+		"iat": s.now().Unix(),
+		"payload": map[string]interface{}{
+			"genericClasses": classes,
+			"genericObjects": objects,
+		},
+	}
+	if len(s.Origins) > 0 {
+		claims["origins"] = s.Origins
+	}
+	return s.sign(claims)
+}
+
+// SaveURL signs a save link for a single pass
+func (s *Signer) SaveURL(pass Pass) (string, error) {
+	token, err := s.SaveJWT(pass)
+	if err != nil {
+		return "", err
+	}
+	return SaveURL(token), nil
+}
+
+// sign encodes the claims as an RS256 JWT
+func (s *Signer) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
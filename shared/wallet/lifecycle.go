@@ -0,0 +1,86 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Pass states. Active passes expire when expires_at passes or on request and
+// can be revoked; expired passes can be reactivated or revoked. Revoked is
+// final.
+const (
+	StateActive  = "active"
+	StateExpired = "expired"
+	StateRevoked = "revoked"
+)
+
+var (
+	// ErrTransition is returned for a state change the lifecycle doesn't allow
+	ErrTransition = errors.New("invalid pass state transition")
+	// ErrRevoked is returned for changes to a revoked pass
+	ErrRevoked = errors.New("pass is revoked")
+)
+
+// objectStates maps pass states to Wallet object states
+var objectStates = map[string]string{
+	StateActive:  "ACTIVE",
+	StateExpired: "EXPIRED",
+	StateRevoked: "INACTIVE",
+}
+
+// transitions lists the states each state can move to
+var transitions = map[string][]string{
+	StateActive:  {StateExpired, StateRevoked},
+	StateExpired: {StateActive, StateRevoked},
+}
+
+// CurrentState is the pass's state at now: its stored state, except that an
+// active pass whose expiry has passed is expired
+func (p Pass) CurrentState(now time.Time) string {
+	switch p.State {
+	case StateRevoked, StateExpired:
+		return p.State
+	}
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(now) {
+		return StateExpired
+	}
+	return StateActive
+}
+
+// Transition moves the pass to state. Expiring a pass ends its validity now;
+// reactivating one needs an expiry that is still ahead, or none.
+func (p *Pass) Transition(state string, now time.Time) error {
+	from := p.CurrentState(now)
+	if state == from {
+		return nil
+	}
+	if objectStates[state] == "" {
+		return fmt.Errorf("%w: unknown state %q", ErrTransition, state)
+	}
+	if !allowed(from, state) {
+		return fmt.Errorf("%w: %s to %s", ErrTransition, from, state)
+	}
+
+	switch state {
+	case StateExpired:
+		if p.ExpiresAt == nil || p.ExpiresAt.After(now) {
+			p.ExpiresAt = &now
+		}
+	case StateActive:
+		if p.ExpiresAt != nil && !p.ExpiresAt.After(now) {
+			return fmt.Errorf("%w: expires_at has passed, set a later one to reactivate", ErrTransition)
+		}
+	}
+	p.State = state
+	return nil
+}
+
+func allowed(from, to string) bool {
+	for _, state := range transitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
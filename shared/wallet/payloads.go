@@ -0,0 +1,340 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the pass payload schemas below. Passes
+// written before payloads were typed have version 0 and a JSON string in data.
+const SchemaVersion = 1
+
+// Payload is the typed data of a pass. Each pass type has one payload type.
+type Payload interface {
+	Validate() error
+	Fields() []Field // shown on the back of the pass, in order
+}
+
+// Field is a labelled value shown on a pass
+type Field struct {
+	ID    string
+	Label string
+	Value string
+}
+
+// schemas maps pass types to their payload
+var schemas = map[string]func() Payload{
+	"receipt":                 func() Payload { return &ReceiptPayload{} },
+	"shopping":                func() Payload { return &InsightPayload{} },
+	"insight":                 func() Payload { return &InsightPayload{} },
+	"cooking":                 func() Payload { return &InsightPayload{} },
+	"shopping_list":           func() Payload { return &ShoppingListPayload{} },
+	"stock_item":              func() Payload { return &StockItemPayload{} },
+	"third_party_bill":        func() Payload { return &BillPayload{} },
+	"third_party_integration": func() Payload { return &IntegrationPayload{} },
+	"warranty":                func() Payload { return &WarrantyPayload{} },
+	"loyalty":                 func() Payload { return &LoyaltyPayload{} },
+}
+
+// ReceiptPayload is the data of a receipt pass
+type ReceiptPayload struct {
+	ReceiptID   string    `json:"receipt_id" firestore:"receipt_id"`
+	StoreName   string    `json:"store_name" firestore:"store_name"`
+	TotalAmount float64   `json:"total_amount" firestore:"total_amount"`
+	ItemCount   int       `json:"item_count" firestore:"item_count"`
+	Date        time.Time `json:"date" firestore:"date"` // zero when the date couldn't be read
+}
+
+func (p *ReceiptPayload) Validate() error {
+	if p.ReceiptID == "" {
+		return errors.New("receipt_id is required")
+	}
+	if err := nonNegative("total_amount", p.TotalAmount); err != nil {
+		return err
+	}
+	return nonNegative("item_count", float64(p.ItemCount))
+}
+
+func (p *ReceiptPayload) Fields() []Field {
+	return []Field{
+		{"store_name", "Store", p.StoreName},
+		{"total_amount", "Total", money(p.TotalAmount)},
+		{"item_count", "Items", strconv.Itoa(p.ItemCount)},
+		{"date", "Date", day(p.Date)},
+	}
+}
+
+// InsightPayload is the data of a pass made from a query response: an
+// insight, cooking suggestions or a shopping suggestion
+type InsightPayload struct {
+	QueryID     string                 `json:"query_id" firestore:"query_id"`
+	Intent      string                 `json:"intent" firestore:"intent"`
+	Suggestions []string               `json:"suggestions" firestore:"suggestions"`
+	Details     map[string]interface{} `json:"details,omitempty" firestore:"details,omitempty"` // intent-specific, free-form
+}
+
+func (p *InsightPayload) Validate() error {
+	if p.QueryID == "" {
+		return errors.New("query_id is required")
+	}
+	return nil
+}
+
+func (p *InsightPayload) Fields() []Field {
+	var fields []Field
+	for i, suggestion := range p.Suggestions {
+		fields = append(fields, Field{fmt.Sprintf("suggestion_%d", i+1), fmt.Sprintf("Suggestion %d", i+1), suggestion})
+	}
+	return fields
+}
+
+// ShoppingListPayload is the data of a shopping list pass
+type ShoppingListPayload struct {
+	ListID    string   `json:"list_id" firestore:"list_id"`
+	Name      string   `json:"name" firestore:"name"`
+	Items     []string `json:"items" firestore:"items"`
+	ItemCount int      `json:"item_count" firestore:"item_count"`
+}
+
+func (p *ShoppingListPayload) Validate() error {
+	if p.ListID == "" {
+		return errors.New("list_id is required")
+	}
+	return nonNegative("item_count", float64(p.ItemCount))
+}
+
+func (p *ShoppingListPayload) Fields() []Field {
+	return []Field{
+		{"name", "List", p.Name},
+		{"item_count", "Items", strconv.Itoa(p.ItemCount)},
+		{"items", "To buy", strings.Join(p.Items, ", ")},
+	}
+}
+
+// StockItemPayload is the data of a pantry item pass
+type StockItemPayload struct {
+	ItemID     string    `json:"item_id" firestore:"item_id"`
+	Name       string    `json:"name" firestore:"name"`
+	Category   string    `json:"category" firestore:"category"`
+	Quantity   int       `json:"quantity" firestore:"quantity"`
+	Unit       string    `json:"unit" firestore:"unit"`
+	ExpiryDate time.Time `json:"expiry_date" firestore:"expiry_date"` // zero when unknown
+	Status     string    `json:"status" firestore:"status"`           // fresh, expiring_soon, expired, depleted
+}
+
+func (p *StockItemPayload) Validate() error {
+	if p.ItemID == "" || p.Name == "" {
+		return errors.New("item_id and name are required")
+	}
+	return nonNegative("quantity", float64(p.Quantity))
+}
+
+func (p *StockItemPayload) Fields() []Field {
+	return []Field{
+		{"quantity", "Quantity", strings.TrimSpace(fmt.Sprintf("%d %s", p.Quantity, p.Unit))},
+		{"expiry_date", "Expires", day(p.ExpiryDate)},
+		{"status", "Status", strings.ReplaceAll(p.Status, "_", " ")},
+		{"category", "Category", p.Category},
+	}
+}
+
+// BillPayload is the data of a third-party bill pass
+type BillPayload struct {
+	BillID      string    `json:"bill_id" firestore:"bill_id"`
+	Service     string    `json:"service" firestore:"service"`
+	OrderID     string    `json:"order_id" firestore:"order_id"`
+	Merchant    string    `json:"merchant" firestore:"merchant"`
+	TotalAmount float64   `json:"total_amount" firestore:"total_amount"`
+	ItemCount   int       `json:"item_count" firestore:"item_count"`
+	OrderDate   time.Time `json:"order_date" firestore:"order_date"`
+	Status      string    `json:"status" firestore:"status"`
+}
+
+func (p *BillPayload) Validate() error {
+	if p.BillID == "" || p.Service == "" {
+		return errors.New("bill_id and service are required")
+	}
+	if err := nonNegative("total_amount", p.TotalAmount); err != nil {
+		return err
+	}
+	return nonNegative("item_count", float64(p.ItemCount))
+}
+
+func (p *BillPayload) Fields() []Field {
+	return []Field{
+		{"merchant", "Merchant", p.Merchant},
+		{"order_id", "Order", p.OrderID},
+		{"total_amount", "Total", money(p.TotalAmount)},
+		{"item_count", "Items", strconv.Itoa(p.ItemCount)},
+		{"order_date", "Ordered", day(p.OrderDate)},
+		{"status", "Status", p.Status},
+	}
+}
+
+// IntegrationPayload is the data of a pass requested by a third-party service
+type IntegrationPayload struct {
+	Service     string                 `json:"service" firestore:"service"`
+	Action      string                 `json:"action" firestore:"action"`
+	ServiceData map[string]interface{} `json:"service_data,omitempty" firestore:"service_data,omitempty"` // as sent by the service
+	RequestedAt string                 `json:"requested_at" firestore:"requested_at"`
+}
+
+func (p *IntegrationPayload) Validate() error {
+	if p.Service == "" || p.Action == "" {
+		return errors.New("service and action are required")
+	}
+	return nil
+}
+
+func (p *IntegrationPayload) Fields() []Field {
+	return []Field{
+		{"service", "Service", p.Service},
+		{"action", "Action", p.Action},
+		{"requested_at", "Requested", p.RequestedAt},
+	}
+}
+
+// WarrantyPayload is the data of a proof-of-purchase pass
+type WarrantyPayload struct {
+	WarrantyID    string    `json:"warranty_id" firestore:"warranty_id"`
+	ReceiptID     string    `json:"receipt_id" firestore:"receipt_id"`
+	ItemName      string    `json:"item_name" firestore:"item_name"`
+	StoreName     string    `json:"store_name" firestore:"store_name"`
+	Price         float64   `json:"price" firestore:"price"`
+	PurchaseDate  time.Time `json:"purchase_date" firestore:"purchase_date"`
+	ImageURL      string    `json:"image_url" firestore:"image_url"`
+	ReturnBy      time.Time `json:"return_by" firestore:"return_by"`           // zero without a return window
+	WarrantyUntil time.Time `json:"warranty_until" firestore:"warranty_until"` // zero without a warranty
+}
+
+func (p *WarrantyPayload) Validate() error {
+	if p.WarrantyID == "" || p.ReceiptID == "" {
+		return errors.New("warranty_id and receipt_id are required")
+	}
+	if p.ReturnBy.IsZero() && p.WarrantyUntil.IsZero() {
+		return errors.New("return_by or warranty_until is required")
+	}
+	return nonNegative("price", p.Price)
+}
+
+func (p *WarrantyPayload) Fields() []Field {
+	return []Field{
+		{"store_name", "Store", p.StoreName},
+		{"price", "Price", money(p.Price)},
+		{"purchase_date", "Purchased", day(p.PurchaseDate)},
+		{"return_by", "Return by", day(p.ReturnBy)},
+		{"warranty_until", "Warranty until", day(p.WarrantyUntil)},
+	}
+}
+
+// LoyaltyPayload is the data of a store loyalty card pass
+type LoyaltyPayload struct {
+	CardID        string    `json:"card_id" firestore:"card_id"`
+	Program       string    `json:"program" firestore:"program"`
+	MemberID      string    `json:"member_id" firestore:"member_id"`
+	MerchantID    string    `json:"merchant_id" firestore:"merchant_id"`
+	MerchantName  string    `json:"merchant_name" firestore:"merchant_name"`
+	PointsBalance float64   `json:"points_balance" firestore:"points_balance"`
+	PointsAsOf    time.Time `json:"points_as_of" firestore:"points_as_of"`                     // zero when the balance isn't known
+	BarcodeType   string    `json:"barcode_type,omitempty" firestore:"barcode_type,omitempty"` // as printed on the card
+}
+
+func (p *LoyaltyPayload) Validate() error {
+	if p.CardID == "" || p.MemberID == "" {
+		return errors.New("card_id and member_id are required")
+	}
+	return nonNegative("points_balance", p.PointsBalance)
+}
+
+func (p *LoyaltyPayload) Fields() []Field {
+	points := ""
+	if !p.PointsAsOf.IsZero() {
+		points = strconv.FormatFloat(p.PointsBalance, 'f', -1, 64)
+	}
+	return []Field{
+		{"program", "Program", p.Program},
+		{"member_id", "Member", p.MemberID},
+		{"points_balance", "Points", points},
+		{"points_as_of", "Points as of", day(p.PointsAsOf)},
+		{"merchant_name", "Store", p.MerchantName},
+	}
+}
+
+// NewPass builds a pass of the given type around its payload
+func NewPass(id, userID, passType, title, description string, payload Payload) (Pass, error) {
+	pass := Pass{
+		ID:            id,
+		UserID:        userID,
+		Type:          passType,
+		Title:         title,
+		Description:   description,
+		SchemaVersion: SchemaVersion,
+		Payload:       payload,
+		State:         StateActive,
+	}
+	return pass, pass.Validate()
+}
+
+// DecodePayload reads a pass type's payload from JSON. Unknown keys are
+// rejected, so misspelled fields don't silently go missing.
+func DecodePayload(passType string, data []byte) (Payload, error) {
+	newPayload, ok := schemas[passType]
+	if !ok {
+		return nil, fmt.Errorf("unknown pass type %q", passType)
+	}
+	payload := newPayload()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(payload); err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %v", passType, err)
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %v", passType, err)
+	}
+	return payload, nil
+}
+
+// TypedPayload returns the pass's payload as its type's schema. Payloads read
+// back from Firestore are maps and are decoded.
+func (p Pass) TypedPayload() (Payload, error) {
+	if payload, ok := p.Payload.(Payload); ok {
+		if _, known := schemas[p.Type]; !known {
+			return nil, fmt.Errorf("unknown pass type %q", p.Type)
+		}
+		if fmt.Sprintf("%T", payload) != fmt.Sprintf("%T", schemas[p.Type]()) {
+			return nil, fmt.Errorf("%T is not a %s payload", payload, p.Type)
+		}
+		return payload, payload.Validate()
+	}
+	if p.Payload == nil {
+		return nil, errors.New("pass has no payload")
+	}
+	data, err := json.Marshal(p.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return DecodePayload(p.Type, data)
+}
+
+func nonNegative(name string, value float64) error {
+	if value < 0 {
+		return fmt.Errorf("%s can't be negative", name)
+	}
+	return nil
+}
+
+func money(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func day(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
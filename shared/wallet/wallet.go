@@ -0,0 +1,240 @@
+// Package wallet turns wallet pass documents into Google Wallet Generic passes
+// and signs the "Save to Google Wallet" links that add them to a user's wallet.
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"raseed-shared/barcode"
+)
+
+// Pass is a wallet pass document as stored in the wallet_passes collection
+type Pass struct {
+	ID            string           `json:"id" firestore:"id"`
+	UserID        string           `json:"user_id" firestore:"user_id"`
+	Type          string           `json:"type" firestore:"type"`
+	Title         string           `json:"title" firestore:"title"`
+	Description   string           `json:"description" firestore:"description"`
+	SchemaVersion int              `json:"schema_version" firestore:"schema_version"`
+	Payload       interface{}      `json:"payload,omitempty" firestore:"payload,omitempty"` // the type's Payload; a map when read back
+	Data          string           `json:"data,omitempty" firestore:"data,omitempty"`       // JSON string, schema version 0 only
+	State         string           `json:"state" firestore:"state"`                         // active, expired, revoked; empty reads as active
+	ExpiresAt     *time.Time       `json:"expires_at,omitempty" firestore:"expires_at,omitempty"`
+	Barcode       *barcode.Barcode `json:"barcode,omitempty" firestore:"barcode,omitempty"`
+	CreatedAt     time.Time        `json:"created_at" firestore:"created_at,serverTimestamp"`
+}
+
+// Issuance statuses recorded on pass documents
+const (
+	StatusIssued  = "issued"
+	StatusInvalid = "invalid" // the pass can't become a Wallet object as it is
+	StatusFailed  = "failed"
+)
+
+// Validate checks that the pass can become a Wallet object
+func (p Pass) Validate() error {
+	switch {
+	case p.ID == "":
+		return errors.New("pass has no id")
+	case p.UserID == "":
+		return errors.New("pass has no user_id")
+	case p.Type == "":
+		return errors.New("pass has no type")
+	case strings.TrimSpace(p.Title) == "":
+		return errors.New("pass has no title")
+	case p.State != "" && objectStates[p.State] == "":
+		return fmt.Errorf("unknown pass state %q", p.State)
+	}
+	if p.Barcode != nil {
+		if err := p.Barcode.Validate(); err != nil {
+			return fmt.Errorf("invalid barcode: %v", err)
+		}
+	}
+	if p.SchemaVersion == 0 {
+		if p.Data != "" && !json.Valid([]byte(p.Data)) {
+			return errors.New("pass data is not valid JSON")
+		}
+		return nil
+	}
+	if p.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("unsupported schema version %d", p.SchemaVersion)
+	}
+	_, err := p.TypedPayload()
+	return err
+}
+
+// Kind is a Generic pass class shared by passes of similar types
+type Kind struct {
+	Name  string // class ID suffix
+	Label string // shown above the pass title
+	Color string // card background
+}
+
+var (
+	kindReceipt  = Kind{"receipt", "Receipt", "#1a73e8"}
+	kindShopping = Kind{"shopping", "Shopping List", "#188038"}
+	kindInsight  = Kind{"insight", "Insight", "#9334e6"}
+	kindStock    = Kind{"stock_item", "Pantry", "#e37400"}
+	kindBill     = Kind{"third_party_bill", "Bill", "#d93025"}
+	kindWarranty = Kind{"warranty", "Proof of Purchase", "#5f6368"}
+	kindLoyalty  = Kind{"loyalty", "Loyalty Card", "#c5221f"}
+	kindGeneral  = Kind{"general", "Raseed", "#202124"}
+)
+
+// kinds maps pass types to their class
+var kinds = map[string]Kind{
+	"receipt":                 kindReceipt,
+	"shopping":                kindShopping,
+	"shopping_list":           kindShopping,
+	"insight":                 kindInsight,
+	"cooking":                 kindInsight,
+	"stock_item":              kindStock,
+	"third_party_bill":        kindBill,
+	"third_party_integration": kindBill,
+	"warranty":                kindWarranty,
+	"loyalty":                 kindLoyalty,
+}
+
+// KindOf returns the class of a pass type, a general one for unknown types
+func KindOf(passType string) Kind {
+	if kind, ok := kinds[passType]; ok {
+		return kind
+	}
+	return kindGeneral
+}
+
+// maxTextModules caps the data fields shown on the back of a pass
+const maxTextModules = 10
+
+// ClassID is the Generic class of a kind under the issuer
+func ClassID(issuerID string, kind Kind) string {
+	return issuerID + ".raseed_" + kind.Name
+}
+
+// ObjectID is the Generic object of a pass under the issuer. Wallet IDs only
+// allow letters, digits, '.', '_' and '-'.
+func ObjectID(issuerID, passID string) string {
+	return issuerID + "." + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, passID)
+}
+
+// GenericClass is the Wallet class for a kind of pass
+func GenericClass(issuerID string, kind Kind) map[string]interface{} {
+	return map[string]interface{}{
+		"id": ClassID(issuerID, kind),
+	}
+}
+
+// GenericObject maps a pass to a Wallet Generic object. The title and
+// description are shown on the front; the payload's fields on the back. An
+// expiry becomes the object's valid interval, so Wallet moves the pass to
+// expired on its own once it passes.
+func GenericObject(issuerID string, pass Pass) map[string]interface{} {
+	kind := KindOf(pass.Type)
+	object := map[string]interface{}{
+		"id":                 ObjectID(issuerID, pass.ID),
+		"classId":            ClassID(issuerID, kind),
+		"state":              objectStates[pass.CurrentState(time.Now())],
+		"cardTitle":          localized("Raseed"),
+		"subheader":          localized(kind.Label),
+		"header":             localized(pass.Title),
+		"hexBackgroundColor": kind.Color,
+	}
+	if pass.Barcode != nil {
+		object["barcode"] = map[string]interface{}{
+			"type":          pass.Barcode.Type,
+			"value":         pass.Barcode.Value,
+			"alternateText": pass.Barcode.AlternateText,
+		}
+	}
+	if pass.ExpiresAt != nil {
+		object["validTimeInterval"] = map[string]interface{}{
+			"end": map[string]interface{}{"date": pass.ExpiresAt.UTC().Format(time.RFC3339)},
+		}
+	}
+
+	modules := []map[string]interface{}{}
+	if pass.Description != "" {
+		modules = append(modules, textModule("description", "Details", pass.Description))
+	}
+	if pass.SchemaVersion == 0 {
+		modules = append(modules, dataModules(pass.Data)...)
+	} else if payload, err := pass.TypedPayload(); err == nil {
+		for _, field := range payload.Fields() {
+			if field.Value != "" {
+				modules = append(modules, textModule(field.ID, field.Label, field.Value))
+			}
+		}
+	}
+	if len(modules) > maxTextModules {
+		modules = modules[:maxTextModules]
+	}
+	object["textModulesData"] = modules
+	return object
+}
+
+// dataModules lists the plain fields of a version 0 pass's data, in key order
+func dataModules(data string) []map[string]interface{} {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var modules []map[string]interface{}
+	for _, key := range keys {
+		var body string
+		switch value := fields[key].(type) {
+		case string:
+			body = value
+		case float64:
+			body = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			body = strconv.FormatBool(value)
+		}
+		if body == "" {
+			continue // nested, null or empty
+		}
+		modules = append(modules, textModule(key, fieldLabel(key), body))
+	}
+	return modules
+}
+
+// fieldLabel turns a snake_case key into a label: total_amount is "Total amount"
+func fieldLabel(key string) string {
+	label := strings.ReplaceAll(key, "_", " ")
+	if label == "" {
+		return label
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+func textModule(id, header, body string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "header": header, "body": body}
+}
+
+func localized(value string) map[string]interface{} {
+	return map[string]interface{}{
+		"defaultValue": map[string]interface{}{"language": "en", "value": value},
+	}
+}
+
+// SaveURL is the "Save to Google Wallet" link for a signed JWT
+func SaveURL(token string) string {
+	return fmt.Sprintf("https://pay.google.com/gp/v/save/%s", token)
+}
@@ -0,0 +1,324 @@
+package wallet
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"raseed-shared/barcode"
+)
+
+func testSigner(t *testing.T) (*Signer, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "wallet@raseed.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+
+	signer, err := NewSigner("3388000000012345678", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return signer, &key.PublicKey
+}
+
+func TestSaveJWT(t *testing.T) {
+	signer, public := testSigner(t)
+	receipt, err := NewPass("receipt_123", "user123", "receipt", "Receipt - D-Mart", "Total: $12.50, Items: 3",
+		&ReceiptPayload{ReceiptID: "123", StoreName: "D-Mart", TotalAmount: 12.5, ItemCount: 3,
+			Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passes := []Pass{
+		receipt,
+		// Written before payloads were typed
+		{ID: "receipt_456", Type: "receipt", Title: "Receipt - Croma",
+			Data: `{"receipt_id":"456","items_count":3,"store_name":"Croma","extra":{"a":1}}`},
+		{ID: "query_1/2", Type: "cooking", Title: "Cooking Suggestions", SchemaVersion: SchemaVersion,
+			Payload: map[string]interface{}{"query_id": "1/2", "intent": "cooking_suggestion", "suggestions": []interface{}{"Dal"}}},
+	}
+
+	token, err := signer.SaveJWT(passes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a three-part JWT, got %q", token)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("Expected a valid RS256 signature: %v", err)
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iss     string `json:"iss"`
+		Aud     string `json:"aud"`
+		Typ     string `json:"typ"`
+		Iat     int64  `json:"iat"`
+		Payload struct {
+			GenericClasses []struct {
+				ID string `json:"id"`
+			} `json:"genericClasses"`
+			GenericObjects []struct {
+				ID          string `json:"id"`
+				ClassID     string `json:"classId"`
+				TextModules []struct {
+					ID     string `json:"id"`
+					Header string `json:"header"`
+					Body   string `json:"body"`
+				} `json:"textModulesData"`
+			} `json:"genericObjects"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Iss != signer.Email || claims.Aud != "google" || claims.Typ != "savetowallet" || claims.Iat != 1700000000 {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	classes := claims.Payload.GenericClasses
+	if len(classes) != 2 || classes[0].ID != "3388000000012345678.raseed_receipt" || classes[1].ID != "3388000000012345678.raseed_insight" {
+		t.Errorf("Expected one class per kind, got %+v", classes)
+	}
+	objects := claims.Payload.GenericObjects
+	if len(objects) != 3 || objects[2].ID != "3388000000012345678.query_1_2" || objects[2].ClassID != classes[1].ID {
+		t.Fatalf("Unexpected objects %+v", objects)
+	}
+
+	for i, want := range []string{
+		"Details=Total: $12.50, Items: 3,Store=D-Mart,Total=12.50,Items=3,Date=2024-03-05",
+		"Items count=3,Receipt id=456,Store name=Croma",
+		"Suggestion 1=Dal",
+	} {
+		var modules []string
+		for _, m := range objects[i].TextModules {
+			modules = append(modules, m.Header+"="+m.Body)
+		}
+		if got := strings.Join(modules, ","); got != want {
+			t.Errorf("Expected text modules %s, got %s", want, got)
+		}
+	}
+}
+
+func TestNewSignerRejectsBadKeys(t *testing.T) {
+	if _, err := NewSigner("issuer", []byte(`{"client_email":"a@b.c","private_key":"nope"}`)); err == nil {
+		t.Error("Expected an error for a key without PEM data")
+	}
+	if _, err := NewSigner("", []byte(`{}`)); err == nil {
+		t.Error("Expected an error without an issuer")
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	if KindOf("shopping_list") != KindOf("shopping") {
+		t.Error("Expected shopping lists to share the shopping class")
+	}
+	if KindOf("unknown").Name != "general" {
+		t.Error("Expected unknown types to use the general class")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	legacy := Pass{ID: "receipt_1", UserID: "user123", Type: "receipt", Title: "Receipt - D-Mart", Data: `{"a":1}`}
+	if err := legacy.Validate(); err != nil {
+		t.Errorf("Expected a valid version 0 pass, got %v", err)
+	}
+	legacy.Data = "{not json"
+	if legacy.Validate() == nil {
+		t.Error("Expected malformed data to be rejected")
+	}
+
+	if _, err := NewPass("receipt_1", "user123", "receipt", "  ", "", &ReceiptPayload{ReceiptID: "1"}); err == nil {
+		t.Error("Expected a blank title to be rejected")
+	}
+	if _, err := NewPass("receipt_1", "user123", "receipt", "Receipt", "", &StockItemPayload{ItemID: "1", Name: "Milk"}); err == nil {
+		t.Error("Expected a payload of another type to be rejected")
+	}
+	if _, err := NewPass("receipt_1", "user123", "receipt", "Receipt", "", &ReceiptPayload{ReceiptID: "1", TotalAmount: -1}); err == nil {
+		t.Error("Expected a negative total to be rejected")
+	}
+
+	// Payloads read back from Firestore are maps
+	stored := Pass{ID: "stock_1", UserID: "user123", Type: "stock_item", Title: "Stock - Milk", SchemaVersion: SchemaVersion,
+		Payload: map[string]interface{}{"item_id": "1", "name": "Milk", "quantity": int64(2), "expiry_date": time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)}}
+	payload, err := stored.TypedPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := payload.(*StockItemPayload); item.Quantity != 2 || item.ExpiryDate.Day() != 9 {
+		t.Errorf("Unexpected stock payload %+v", item)
+	}
+	stored.Payload = map[string]interface{}{"item_id": "1", "name": "Milk", "items_count": 2}
+	if stored.Validate() == nil {
+		t.Error("Expected an unknown payload key to be rejected")
+	}
+}
+
+func TestTransition(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		pass      Pass
+		to        string
+		wantErr   error
+		wantState string
+	}{
+		{"legacy pass revoked", Pass{}, StateRevoked, nil, StateRevoked},
+		{"active expired by hand", Pass{State: StateActive, ExpiresAt: &future}, StateExpired, nil, StateExpired},
+		{"lapsed pass reactivated", Pass{State: StateActive, ExpiresAt: &past}, StateActive, ErrTransition, StateExpired},
+		{"expired pass reactivated", Pass{State: StateExpired, ExpiresAt: &future}, StateActive, nil, StateActive},
+		{"revoked is final", Pass{State: StateRevoked}, StateActive, ErrTransition, StateRevoked},
+		{"unknown state", Pass{State: StateActive}, "paused", ErrTransition, StateActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pass := tt.pass
+			err := pass.Transition(tt.to, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Transition(%s) error = %v, want %v", tt.to, err, tt.wantErr)
+			}
+			if got := pass.CurrentState(now); got != tt.wantState {
+				t.Errorf("state = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestTransitionExpiresNow(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	pass := Pass{State: StateActive}
+	if err := pass.Transition(StateExpired, now); err != nil {
+		t.Fatal(err)
+	}
+	if pass.ExpiresAt == nil || !pass.ExpiresAt.Equal(now) {
+		t.Errorf("expires_at = %v, want %v", pass.ExpiresAt, now)
+	}
+}
+
+func TestGenericObjectState(t *testing.T) {
+	expiry := time.Now().Add(-time.Minute)
+	pass := Pass{ID: "p1", UserID: "u1", Type: "stock_item", Title: "Milk", State: StateActive, ExpiresAt: &expiry}
+	object := GenericObject("3388000000012345678", pass)
+	if object["state"] != "EXPIRED" {
+		t.Errorf("state = %v, want EXPIRED", object["state"])
+	}
+	if _, ok := object["validTimeInterval"]; !ok {
+		t.Error("validTimeInterval missing for a pass with an expiry")
+	}
+
+	pass.State = StateRevoked
+	if got := GenericObject("3388000000012345678", pass)["state"]; got != "INACTIVE" {
+		t.Errorf("revoked state = %v, want INACTIVE", got)
+	}
+}
+
+func TestPatchObject(t *testing.T) {
+	var gotPath, gotMethod string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotMethod = r.URL.Path, r.Method
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		if strings.HasSuffix(r.URL.Path, ".missing") {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+	client.baseURL = server.URL
+	if err := client.Revoke(context.Background(), "3388.stock_1"); err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != http.MethodPatch || gotPath != "/genericObject/3388.stock_1" || gotBody["state"] != "INACTIVE" {
+		t.Errorf("request = %s %s %v", gotMethod, gotPath, gotBody)
+	}
+
+	if err := client.Revoke(context.Background(), "3388.missing"); !errors.Is(err, ErrNotSaved) {
+		t.Errorf("error = %v, want ErrNotSaved", err)
+	}
+}
+
+func TestDefaultBarcode(t *testing.T) {
+	receipt, err := NewPass("receipt_r1", "u1", "receipt", "Receipt", "", &ReceiptPayload{ReceiptID: "r1", StoreName: "Walmart"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := DefaultBarcode(receipt, barcode.QRCode, "https://raseed.app/")
+	if code == nil || code.Value != "https://raseed.app/receipts/r1" || code.Type != barcode.QRCode {
+		t.Errorf("receipt barcode = %+v", code)
+	}
+
+	// Deep links too long for Code 128 fall back to QR
+	stock, err := NewPass("stock_1", "u1", "stock_item", "Stock", "", &StockItemPayload{ItemID: strings.Repeat("1", 40), Name: "Milk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := DefaultBarcode(stock, barcode.Code128, "https://raseed.app"); code == nil || code.Type != barcode.QRCode {
+		t.Errorf("stock barcode = %+v, want a QR code", code)
+	}
+
+	warrantyPass, err := NewPass("warranty_r1_0", "u1", "warranty", "Proof", "",
+		&WarrantyPayload{WarrantyID: "r1_0", ReceiptID: "r1", ReturnBy: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := DefaultBarcode(warrantyPass, barcode.Code128, "https://raseed.app"); code == nil || code.Value != "RA-R1_0" || code.Type != barcode.Code128 {
+		t.Errorf("warranty barcode = %+v", code)
+	}
+
+	insight, err := NewPass("query_q1", "u1", "insight", "Insight", "", &InsightPayload{QueryID: "q1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := DefaultBarcode(insight, barcode.QRCode, "https://raseed.app"); code != nil {
+		t.Errorf("insight barcode = %+v, want none", code)
+	}
+
+	receipt.Barcode = DefaultBarcode(receipt, barcode.QRCode, "https://raseed.app")
+	object := GenericObject("3388000000012345678", receipt)
+	if got := object["barcode"].(map[string]interface{})["value"]; got != "https://raseed.app/receipts/r1" {
+		t.Errorf("object barcode value = %v", got)
+	}
+}
+
+func TestPassEvent(t *testing.T) {
+	pass := Pass{ID: "stock_1", UserID: "user123", Type: "stock_item", Title: "Milk"}
+	data, err := json.Marshal(pass.Event("deleted", "issuer.stock_1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"pass_id":"stock_1","user_id":"user123","type":"stock_item","title":"Milk","action":"deleted","wallet_object_id":"issuer.stock_1"}`
+	if string(data) != want {
+		t.Errorf("Event = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,173 @@
+// Package warranty tracks the return window and warranty of purchased items,
+// so reminders can go out before they lapse and the proof of purchase is a
+// wallet pass away.
+package warranty
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"raseed-shared/wallet"
+)
+
+// Reminder lead times before a deadline
+const (
+	ReturnLead   = 3 * 24 * time.Hour
+	WarrantyLead = 30 * 24 * time.Hour
+)
+
+// Deadline kinds
+const (
+	Return = "return"
+	Cover  = "warranty"
+)
+
+// Terms are the usual warranty in months and return window in days
+type Terms struct {
+	WarrantyMonths int
+	ReturnDays     int
+}
+
+// defaults are used when the receipt model suggests nothing for a category
+var defaults = map[string]Terms{
+	"electronics": {12, 7},
+	"appliances":  {24, 7},
+	"appliance":   {24, 7},
+	"computers":   {12, 7},
+	"mobile":      {12, 7},
+	"phones":      {12, 7},
+	"furniture":   {12, 7},
+	"clothing":    {0, 30},
+	"apparel":     {0, 30},
+	"footwear":    {0, 30},
+	"toys":        {0, 14},
+}
+
+// Suggest returns the usual terms for a category, or zero terms when items of
+// the category aren't usually covered
+func Suggest(category string) Terms {
+	return defaults[strings.ToLower(strings.TrimSpace(category))]
+}
+
+// Warranty is a purchased item whose return window or warranty is tracked
+type Warranty struct {
+	ID               string    `json:"id" firestore:"id"` // {receipt_id}_{item index}
+	UserID           string    `json:"user_id" firestore:"user_id"`
+	HouseholdID      string    `json:"household_id,omitempty" firestore:"household_id,omitempty"`
+	ReceiptID        string    `json:"receipt_id" firestore:"receipt_id"`
+	ItemIndex        int       `json:"item_index" firestore:"item_index"`
+	ItemName         string    `json:"item_name" firestore:"item_name"`
+	Category         string    `json:"category" firestore:"category"`
+	StoreName        string    `json:"store_name" firestore:"store_name"`
+	Price            float64   `json:"price" firestore:"price"`
+	ImageURL         string    `json:"image_url" firestore:"image_url"`
+	PurchaseDate     time.Time `json:"purchase_date" firestore:"purchase_date"`
+	WarrantyMonths   int       `json:"warranty_months" firestore:"warranty_months"`
+	ReturnDays       int       `json:"return_days" firestore:"return_days"`
+	ReturnBy         time.Time `json:"return_by" firestore:"return_by"`
+	WarrantyUntil    time.Time `json:"warranty_until" firestore:"warranty_until"`
+	ReturnReminded   bool      `json:"return_reminded" firestore:"return_reminded"`
+	WarrantyReminded bool      `json:"warranty_reminded" firestore:"warranty_reminded"`
+	CreatedAt        time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// ID identifies the warranty of a receipt item
+func ID(receiptID string, itemIndex int) string {
+	return fmt.Sprintf("%s_%d", receiptID, itemIndex)
+}
+
+// SetTerms sets the warranty and return window and recomputes the deadlines
+// from the purchase date. A deadline that moves gets a new reminder.
+func (w *Warranty) SetTerms(t Terms) {
+	w.WarrantyMonths, w.ReturnDays = t.WarrantyMonths, t.ReturnDays
+
+	returnBy, warrantyUntil := time.Time{}, time.Time{}
+	if t.ReturnDays > 0 {
+		returnBy = w.PurchaseDate.AddDate(0, 0, t.ReturnDays)
+	}
+	if t.WarrantyMonths > 0 {
+		warrantyUntil = w.PurchaseDate.AddDate(0, t.WarrantyMonths, 0)
+	}
+
+	if !returnBy.Equal(w.ReturnBy) {
+		w.ReturnBy, w.ReturnReminded = returnBy, false
+	}
+	if !warrantyUntil.Equal(w.WarrantyUntil) {
+		w.WarrantyUntil, w.WarrantyReminded = warrantyUntil, false
+	}
+}
+
+// Tracked reports whether the item has a return window or warranty
+func (w Warranty) Tracked() bool {
+	return w.ReturnDays > 0 || w.WarrantyMonths > 0
+}
+
+// NextDeadline returns the earliest deadline that hasn't passed, and its kind.
+// ok is false once both have lapsed.
+func (w Warranty) NextDeadline(now time.Time) (deadline time.Time, kind string, ok bool) {
+	if !w.ReturnBy.IsZero() && !w.ReturnBy.Before(now) {
+		deadline, kind, ok = w.ReturnBy, Return, true
+	}
+	if !w.WarrantyUntil.IsZero() && !w.WarrantyUntil.Before(now) && (!ok || w.WarrantyUntil.Before(deadline)) {
+		deadline, kind, ok = w.WarrantyUntil, Cover, true
+	}
+	return deadline, kind, ok
+}
+
+// DueReminders returns the kinds of deadline that fall within their lead
+// time and haven't been reminded about yet
+func (w Warranty) DueReminders(now time.Time) []string {
+	var due []string
+	if !w.ReturnReminded && within(w.ReturnBy, now, ReturnLead) {
+		due = append(due, Return)
+	}
+	if !w.WarrantyReminded && within(w.WarrantyUntil, now, WarrantyLead) {
+		due = append(due, Cover)
+	}
+	return due
+}
+
+func within(deadline, now time.Time, lead time.Duration) bool {
+	return !deadline.IsZero() && !deadline.Before(now) && deadline.Sub(now) <= lead
+}
+
+// Message is the reminder text for a deadline kind
+func (w Warranty) Message(kind string) string {
+	if kind == Return {
+		return fmt.Sprintf("The return window for %s from %s closes on %s", w.ItemName, w.StoreName, w.ReturnBy.Format("2 Jan 2006"))
+	}
+	return fmt.Sprintf("The warranty on %s from %s ends on %s", w.ItemName, w.StoreName, w.WarrantyUntil.Format("2 Jan 2006"))
+}
+
+// PassID is the ID of the item's proof-of-purchase wallet pass
+func (w Warranty) PassID() string {
+	return "warranty_" + w.ID
+}
+
+// Pass returns the proof-of-purchase wallet pass document
+func (w Warranty) Pass() (wallet.Pass, error) {
+	var terms []string
+	if !w.ReturnBy.IsZero() {
+		terms = append(terms, "Return by "+w.ReturnBy.Format("2006-01-02"))
+	}
+	if !w.WarrantyUntil.IsZero() {
+		terms = append(terms, "Warranty until "+w.WarrantyUntil.Format("2006-01-02"))
+	}
+
+	return wallet.NewPass(w.PassID(), w.UserID, "warranty",
+		fmt.Sprintf("Proof of Purchase - %s", w.ItemName),
+		fmt.Sprintf("%s, $%.2f on %s. %s", w.StoreName, w.Price, w.PurchaseDate.Format("2006-01-02"), strings.Join(terms, ", ")),
+		&wallet.WarrantyPayload{
+			WarrantyID:    w.ID,
+			ReceiptID:     w.ReceiptID,
+			ItemName:      w.ItemName,
+			StoreName:     w.StoreName,
+			Price:         w.Price,
+			PurchaseDate:  w.PurchaseDate,
+			ImageURL:      w.ImageURL,
+			ReturnBy:      w.ReturnBy,
+			WarrantyUntil: w.WarrantyUntil,
+		})
+}
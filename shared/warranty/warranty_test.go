@@ -0,0 +1,73 @@
+package warranty
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetTermsAndDeadlines(t *testing.T) {
+	purchase := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	w := Warranty{ItemName: "Headphones", StoreName: "Croma", PurchaseDate: purchase}
+	w.SetTerms(Terms{WarrantyMonths: 12, ReturnDays: 7})
+
+	if !w.ReturnBy.Equal(time.Date(2024, 2, 7, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ReturnBy = %v", w.ReturnBy)
+	}
+	if !w.WarrantyUntil.Equal(time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("WarrantyUntil = %v", w.WarrantyUntil)
+	}
+
+	now := time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)
+	if deadline, kind, ok := w.NextDeadline(now); !ok || kind != Return || !deadline.Equal(w.ReturnBy) {
+		t.Errorf("Expected the return window next, got %v %s %v", deadline, kind, ok)
+	}
+	if due := w.DueReminders(now); len(due) != 1 || due[0] != Return {
+		t.Errorf("Expected a return reminder, got %v", due)
+	}
+
+	w.ReturnReminded = true
+	later := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	if due := w.DueReminders(later); len(due) != 1 || due[0] != Cover {
+		t.Errorf("Expected a warranty reminder, got %v", due)
+	}
+	if _, _, ok := w.NextDeadline(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("Expected no deadline once both lapsed")
+	}
+
+	// Extending the return window asks for a new reminder; dropping the
+	// warranty clears it
+	w.SetTerms(Terms{ReturnDays: 10})
+	if w.ReturnReminded || !w.WarrantyUntil.IsZero() || w.Tracked() != true {
+		t.Errorf("Unexpected warranty after changing terms %+v", w)
+	}
+	w.SetTerms(Terms{})
+	if w.Tracked() {
+		t.Error("Expected zero terms not to be tracked")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	if got := Suggest(" Electronics "); got != (Terms{12, 7}) {
+		t.Errorf("Suggest(electronics) = %+v", got)
+	}
+	if got := Suggest("groceries"); got != (Terms{}) {
+		t.Errorf("Expected no terms for groceries, got %+v", got)
+	}
+}
+
+func TestPass(t *testing.T) {
+	w := Warranty{ID: "r1_0", UserID: "user123", ReceiptID: "r1", ItemName: "Kettle", StoreName: "Croma", Price: 25,
+		PurchaseDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	w.SetTerms(Terms{WarrantyMonths: 24})
+
+	pass, err := w.Pass()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass.ID != "warranty_r1_0" || pass.Type != "warranty" {
+		t.Errorf("Unexpected pass %+v", pass)
+	}
+	if want := "Croma, $25.00 on 2024-03-01. Warranty until 2026-03-01"; pass.Description != want {
+		t.Errorf("description = %q, want %q", pass.Description, want)
+	}
+}
@@ -0,0 +1,377 @@
+// Package webhooks delivers events to URLs users register for their own
+// automations. Each delivery is signed with the webhook's secret so receivers
+// can check it came from Raseed, and failed deliveries are retried with
+// exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Topic is the Pub/Sub topic events are published to for delivery
+const Topic = "webhook-events"
+
+// Event types users can subscribe to
+const (
+	ReceiptExtracted = "receipt.extracted"
+	StockExpiring    = "stock.expiring"
+	QueryAnswered    = "query.answered"
+	PassCreated      = "pass.created"
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{ReceiptExtracted, StockExpiring, QueryAnswered, PassCreated}
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "Raseed-Signature" // t={unix time},v1={hex HMAC-SHA256}
+	EventHeader     = "Raseed-Event"
+	DeliveryHeader  = "Raseed-Delivery"
+)
+
+// Delivery statuses
+const (
+	StatusSucceeded = "succeeded"
+	StatusRetrying  = "retrying" // failed, with another attempt at next_attempt_at
+	StatusFailed    = "failed"   // gave up after MaxAttempts
+)
+
+// MaxAttempts bounds the automatic deliveries of an event to one webhook
+const MaxAttempts = 8
+
+var (
+	ErrInvalidWebhook   = errors.New("invalid webhook")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrPrivateAddress   = errors.New("webhook address is not public")
+)
+
+// Event is the payload delivered to webhooks
+type Event struct {
+	ID        string                 `json:"id"` // the same on every delivery of the event
+	Type      string                 `json:"type"`
+	UserID    string                 `json:"user_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Validate checks the event can be delivered
+func (e Event) Validate() error {
+	if e.ID == "" || e.UserID == "" {
+		return errors.New("id and user_id are required")
+	}
+	if !KnownType(e.Type) {
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+	return nil
+}
+
+// EventID builds the ID of an event from its type and what it is about, so
+// an event raised twice, by a retried function or a second sweep, is
+// delivered once
+func EventID(eventType string, parts ...string) string {
+	return strings.Join(append([]string{strings.ReplaceAll(eventType, ".", "_")}, parts...), "_")
+}
+
+// KnownType reports whether t is one of EventTypes
+func KnownType(t string) bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish hands an event to the webhook dispatcher, which delivers it to the
+// user's webhooks subscribed to its type. It waits for Pub/Sub to accept the
+// event.
+func Publish(ctx context.Context, topic *pubsub.Topic, event Event) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %v", err)
+	}
+	_, err = topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
+
+// ExpiringItem is the stock item a stock.expiring event is about
+type ExpiringItem struct {
+	ID          string
+	UserID      string
+	HouseholdID string
+	Name        string
+	Category    string
+	Quantity    int
+	Unit        string
+	ExpiryDate  time.Time
+}
+
+// StockExpiringEvent is the event for an item that became expiring soon. It
+// is raised once per item and expiry date.
+func StockExpiringEvent(item ExpiringItem) Event {
+	return Event{
+		ID:     EventID(StockExpiring, item.ID, item.ExpiryDate.Format("2006-01-02")),
+		Type:   StockExpiring,
+		UserID: item.UserID,
+		Data: map[string]interface{}{
+			"item_id":      item.ID,
+			"name":         item.Name,
+			"category":     item.Category,
+			"quantity":     item.Quantity,
+			"unit":         item.Unit,
+			"household_id": item.HouseholdID,
+			"expiry_date":  item.ExpiryDate,
+		},
+	}
+}
+
+// Webhook is a URL a user registered for some event types, kept in webhooks
+type Webhook struct {
+	ID        string    `json:"id" firestore:"id"`
+	UserID    string    `json:"user_id" firestore:"user_id"`
+	URL       string    `json:"url" firestore:"url"`
+	Events    []string  `json:"events" firestore:"events"`
+	Secret    string    `json:"secret,omitempty" firestore:"secret"` // only returned when the webhook is created
+	Active    bool      `json:"active" firestore:"active"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// Validate checks the URL is HTTPS and the event types are known. URLs
+// naming a private address are rejected here; hostnames are checked again
+// when deliveries connect, see NewClient.
+func (w Webhook) Validate() error {
+	if w.UserID == "" {
+		return fmt.Errorf("%w: user_id is required", ErrInvalidWebhook)
+	}
+	if err := CheckURL(w.URL); err != nil {
+		return err
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("%w: at least one event type is required", ErrInvalidWebhook)
+	}
+	for _, t := range w.Events {
+		if !KnownType(t) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
+
+// CheckURL checks a URL chosen by a user is HTTPS and doesn't name a
+// private address. Hostnames are checked again on connecting, see NewClient.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: url must be an https URL", ErrInvalidWebhook)
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || (ip != nil && !publicIP(ip)) {
+		return fmt.Errorf("%w: url must be on a public host", ErrInvalidWebhook)
+	}
+	return nil
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header for a body sent at t. The HMAC covers
+// the timestamp as well as the body, so a captured delivery can't be
+// replayed later with a fresh timestamp.
+func Sign(secret string, t time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), signature(secret, t.Unix(), body))
+}
+
+// Verify checks a signature header against the body, rejecting signatures
+// more than tolerance away from now. Receivers can use it as is.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header", ErrInvalidSignature)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	expected := signature(secret, timestamp, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait after a failed attempt before the next: 30 seconds,
+// doubling with each attempt, up to an hour
+func Backoff(attempts int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempts && wait < time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > time.Hour {
+		wait = time.Hour
+	}
+	return wait
+}
+
+// Delivery is an event sent to one webhook, kept in webhook_deliveries as the
+// delivery log. Automatic deliveries are {event_id}_{webhook_id}; each
+// redelivery is a delivery of its own.
+type Delivery struct {
+	ID            string     `json:"id" firestore:"id"`
+	WebhookID     string     `json:"webhook_id" firestore:"webhook_id"`
+	UserID        string     `json:"user_id" firestore:"user_id"`
+	EventID       string     `json:"event_id" firestore:"event_id"`
+	EventType     string     `json:"event_type" firestore:"event_type"`
+	URL           string     `json:"url" firestore:"url"`
+	Payload       string     `json:"payload" firestore:"payload"` // the event JSON, sent again as is on retries
+	Status        string     `json:"status" firestore:"status"`
+	StatusCode    int        `json:"status_code,omitempty" firestore:"status_code,omitempty"`
+	Error         string     `json:"error,omitempty" firestore:"error,omitempty"`
+	Attempts      int        `json:"attempts" firestore:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" firestore:"next_attempt_at,omitempty"`
+	RedeliveryOf  string     `json:"redelivery_of,omitempty" firestore:"redelivery_of,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" firestore:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" firestore:"updated_at"`
+}
+
+// Due reports whether the delivery should be attempted at now
+func (d Delivery) Due(now time.Time) bool {
+	switch d.Status {
+	case StatusSucceeded, StatusFailed:
+		return false
+	case StatusRetrying:
+		return d.NextAttemptAt == nil || !now.Before(*d.NextAttemptAt)
+	}
+	return true
+}
+
+// NewClient returns the HTTP client to send deliveries with. Webhook URLs are
+// chosen by users, so it only connects to public addresses, checked after DNS
+// resolution, and doesn't follow redirects, which count as failures.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: dialPublic}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would make the connection past the check
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dialPublic refuses connections to loopback, private and link-local
+// addresses, such as the metadata server
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+// Send makes one attempt at the delivery, signed with the webhook's secret,
+// and records the outcome on it. Any 2xx response is a success; anything
+// else is retried after Backoff, until MaxAttempts.
+func Send(ctx context.Context, client *http.Client, hook Webhook, d *Delivery, now time.Time) error {
+	d.Attempts++
+	d.UpdatedAt = now
+	d.StatusCode, d.Error = 0, ""
+
+	err := post(ctx, client, hook, d, now)
+	if err == nil {
+		d.Status, d.NextAttemptAt = StatusSucceeded, nil
+		return nil
+	}
+
+	d.Error = err.Error()
+	if d.Attempts >= MaxAttempts || d.RedeliveryOf != "" {
+		// Redeliveries are asked for by hand and aren't retried
+		d.Status, d.NextAttemptAt = StatusFailed, nil
+		return err
+	}
+	next := now.Add(Backoff(d.Attempts))
+	d.Status, d.NextAttemptAt = StatusRetrying, &next
+	return err
+}
+
+func post(ctx context.Context, client *http.Client, hook Webhook, d *Delivery, now time.Time) error {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Raseed-Webhooks/1.0")
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, now, body))
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, d.ID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	// Only the status is kept; the body is the receiver's
+	resp.Body.Close()
+
+	d.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	hook := Webhook{UserID: "u1", URL: "https://example.com/hook", Events: []string{ReceiptExtracted, PassCreated}}
+	if err := hook.Validate(); err != nil {
+		t.Fatalf("Expected a valid webhook, got %v", err)
+	}
+
+	for _, bad := range []Webhook{
+		{UserID: "u1", URL: "http://example.com/hook", Events: []string{ReceiptExtracted}},
+		{UserID: "u1", URL: "not a url", Events: []string{ReceiptExtracted}},
+		{UserID: "u1", URL: "https://example.com/hook"},
+		{UserID: "u1", URL: "https://example.com/hook", Events: []string{"receipt.deleted"}},
+		{UserID: "u1", URL: "https://localhost/hook", Events: []string{ReceiptExtracted}},
+		{UserID: "u1", URL: "https://127.0.0.1:8443/hook", Events: []string{ReceiptExtracted}},
+		{UserID: "u1", URL: "https://10.0.0.5/hook", Events: []string{ReceiptExtracted}},
+		{UserID: "u1", URL: "https://169.254.169.254/computeMetadata/v1/", Events: []string{ReceiptExtracted}},
+		{UserID: "u1", URL: "https://[::1]/hook", Events: []string{ReceiptExtracted}},
+	} {
+		if err := bad.Validate(); !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("Expected %+v to be invalid, got %v", bad, err)
+		}
+	}
+}
+
+func TestEventID(t *testing.T) {
+	if got := EventID(StockExpiring, "item1", "2024-03-10"); got != "stock_expiring_item1_2024-03-10" {
+		t.Errorf("EventID = %s", got)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	header := Sign("whsec_test", now, body)
+
+	if err := Verify("whsec_test", header, body, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+	if err := Verify("whsec_other", header, body, 5*time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected another secret to fail, got %v", err)
+	}
+	if err := Verify("whsec_test", header, []byte(`{"id":"evt_2"}`), 5*time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a changed body to fail, got %v", err)
+	}
+	if err := Verify("whsec_test", header, body, 5*time.Minute, now.Add(time.Hour)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected an old signature to fail, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	hook := Webhook{ID: "wh1", Secret: "whsec_test"}
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(hook.Secret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("Delivery not verifiable: %v", err)
+		}
+		if r.Header.Get(EventHeader) != ReceiptExtracted || r.Header.Get(DeliveryHeader) != "evt_1_wh1" {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+		if fail {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	d := Delivery{ID: "evt_1_wh1", EventType: ReceiptExtracted, URL: server.URL, Payload: `{"id":"evt_1"}`}
+	if err := Send(context.Background(), server.Client(), hook, &d, now); err == nil {
+		t.Fatal("Expected a 503 to fail")
+	}
+	if d.Status != StatusRetrying || d.StatusCode != 503 || d.NextAttemptAt == nil || !d.NextAttemptAt.Equal(now.Add(30*time.Second)) {
+		t.Errorf("Expected a retry in 30s, got %+v", d)
+	}
+	if d.Due(now) || !d.Due(now.Add(30*time.Second)) {
+		t.Error("Expected the delivery to be due only after the backoff")
+	}
+
+	fail = false
+	if err := Send(context.Background(), server.Client(), hook, &d, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != StatusSucceeded || d.Attempts != 2 || d.NextAttemptAt != nil || d.Error != "" {
+		t.Errorf("Expected success on the second attempt, got %+v", d)
+	}
+
+	fail = true
+	d = Delivery{ID: "evt_1_wh1", EventType: ReceiptExtracted, URL: server.URL, Attempts: MaxAttempts - 1}
+	Send(context.Background(), server.Client(), hook, &d, now)
+	if d.Status != StatusFailed || d.Due(now.Add(24*time.Hour)) {
+		t.Errorf("Expected the last attempt to give up, got %+v", d)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	hook := Webhook{ID: "wh1", Secret: "whsec_test"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/hook", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	// httptest listens on loopback, which deliveries may not reach
+	d := Delivery{ID: "evt_1_wh1", EventType: ReceiptExtracted, URL: server.URL + "/hook"}
+	if err := Send(context.Background(), NewClient(time.Second), hook, &d, time.Now()); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected loopback to be refused, got %v", err)
+	}
+
+	client := NewClient(time.Second)
+	client.Transport = server.Client().Transport
+	d = Delivery{ID: "evt_1_wh1", EventType: ReceiptExtracted, URL: server.URL + "/moved"}
+	if err := Send(context.Background(), client, hook, &d, time.Now()); err == nil || d.StatusCode != http.StatusFound {
+		t.Errorf("Expected the redirect not to be followed, got %v with %+v", err, d)
+	}
+}
+
+func TestStockExpiringEvent(t *testing.T) {
+	expiry := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	event := StockExpiringEvent(ExpiringItem{ID: "item1", UserID: "u1", HouseholdID: "h1", Name: "Milk", Quantity: 2, ExpiryDate: expiry})
+	if event.ID != "stock_expiring_item1_2024-03-10" || event.Type != StockExpiring || event.UserID != "u1" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if err := event.Validate(); err != nil {
+		t.Errorf("Expected a valid event, got %v", err)
+	}
+	if event.Data["household_id"] != "h1" || event.Data["quantity"] != 2 || event.Data["expiry_date"] != expiry {
+		t.Errorf("Unexpected data %v", event.Data)
+	}
+}